@@ -0,0 +1,136 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "time"
+  "regexp"
+  "path/filepath"
+
+  "rewinged/logging"
+  "rewinged/models"
+)
+
+// How often autoInternalizePath is checked for installers no manifest references anymore
+const installerCleanupInterval = 1 * time.Hour
+
+// Internalized installers are named after their InstallerSha256, incomplete downloads
+// additionally are hidden and have a .part suffix. Any other files are left alone.
+var internalizedInstallerName = regexp.MustCompile(`^\.?([0-9a-f]{64})(\.part)?$`)
+
+// The installerCleaner finds internalized installers that are no longer referenced by any
+// manifest, for example because a package version was removed or its installer changed.
+// Orphans are only deleted once they have been orphaned for the whole grace period, so an
+// installer survives a manifest being temporarily removed or moved around, and with dryRun
+// they are only ever reported.
+type installerCleaner struct {
+  autoInternalizePath string
+  gracePeriod time.Duration
+  dryRun bool
+
+  // When each orphan was first found to be orphaned
+  orphanedSince map[string]time.Time
+}
+
+func newInstallerCleaner(autoInternalizePath string, gracePeriod time.Duration, dryRun bool) *installerCleaner {
+  return &installerCleaner{
+    autoInternalizePath: autoInternalizePath,
+    gracePeriod: gracePeriod,
+    dryRun: dryRun,
+    orphanedSince: make(map[string]time.Time),
+  }
+}
+
+func (c *installerCleaner) runPeriodically() {
+  for {
+    c.run()
+    time.Sleep(installerCleanupInterval)
+  }
+}
+
+func (c *installerCleaner) run() {
+  entries, err := os.ReadDir(c.autoInternalizePath)
+  if err != nil {
+    logging.Logger.Error().Err(err).Msgf("cannot read autoInternalizePath %s to clean up orphaned installers", c.autoInternalizePath)
+    return
+  }
+
+  liveInstallerShas := models.Manifests.GetInstallerShas()
+  now := time.Now()
+  var orphans, deleted int
+  var orphanedBytes, reclaimedBytes int64
+  stillOrphaned := make(map[string]bool)
+
+  for _, entry := range entries {
+    match := internalizedInstallerName.FindStringSubmatch(entry.Name())
+    if match == nil || !entry.Type().IsRegular() {
+      continue
+    }
+    installerSha256 := match[1]
+    if liveInstallerShas[installerSha256] || installerDownloads.isPending(installerSha256) {
+      continue
+    }
+    info, err := entry.Info()
+    if err != nil {
+      continue
+    }
+
+    stillOrphaned[entry.Name()] = true
+    since, known := c.orphanedSince[entry.Name()]
+    if !known {
+      since = now
+      c.orphanedSince[entry.Name()] = now
+    }
+    orphans++
+    orphanedBytes += info.Size()
+
+    file := filepath.Join(c.autoInternalizePath, entry.Name())
+    if c.dryRun || now.Sub(since) < c.gracePeriod {
+      logging.Logger.Info().Str("file", file).Int64("bytes", info.Size()).Time("orphanedsince", since).Msg("found orphaned installer")
+      continue
+    }
+
+    // Stop rewriting InstallerUrls to the file before it's gone, in case a manifest
+    // referencing it is added again before it has been downloaded again
+    models.InternalizedInstallers.Remove(installerSha256)
+    if err := os.Remove(file); err != nil {
+      logging.Logger.Error().Err(err).Str("file", file).Msg("cannot delete orphaned installer")
+      continue
+    }
+    logging.Logger.Info().Str("file", file).Int64("bytes", info.Size()).Time("orphanedsince", since).Msg("deleted orphaned installer")
+    delete(c.orphanedSince, entry.Name())
+    deleted++
+    reclaimedBytes += info.Size()
+  }
+
+  // Forget about orphans that are referenced again or were deleted by someone else
+  for name := range c.orphanedSince {
+    if !stillOrphaned[name] {
+      delete(c.orphanedSince, name)
+    }
+  }
+
+  if orphans > 0 {
+    event := logging.Logger.Info().Int("orphans", orphans).Int64("orphanedbytes", orphanedBytes)
+    if c.dryRun {
+      event.Msg("orphaned installer cleanup dry run finished, nothing was deleted")
+    } else {
+      event.Int("deleted", deleted).Int64("reclaimedbytes", reclaimedBytes).Msgf("orphaned installer cleanup finished, reclaimed %s", formatBytes(reclaimedBytes))
+    }
+  } else {
+    logging.Logger.Debug().Msg("no orphaned installers found")
+  }
+}
+
+func formatBytes(n int64) string {
+  const unit = 1024
+  if n < unit {
+    return fmt.Sprintf("%d B", n)
+  }
+  div, exp := int64(unit), 0
+  for m := n / unit; m >= unit; m /= unit {
+    div *= unit
+    exp++
+  }
+  return fmt.Sprintf("%.1f %ciB", float64(n) / float64(div), "KMGTPE"[exp])
+}
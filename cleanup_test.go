@@ -0,0 +1,180 @@
+package main
+
+import (
+  "os"
+  "time"
+  "slices"
+  "strings"
+  "testing"
+  "path/filepath"
+
+  "rewinged/models"
+)
+
+// An autoInternalizePath with an installer of each kind, and the names of their files
+type cleanupTest struct {
+  path string
+  live, orphan, partial, pending string
+}
+
+func newCleanupTest(t *testing.T) *cleanupTest {
+  test := &cleanupTest{
+    path: t.TempDir(),
+    live: sha256Of(t.Name() + " live installer"),
+    orphan: sha256Of(t.Name() + " orphaned installer"),
+    partial: "." + sha256Of(t.Name() + " orphaned partial download") + ".part",
+    pending: "." + sha256Of(t.Name() + " pending download") + ".part",
+  }
+  for _, name := range []string{test.live, test.orphan, test.partial, test.pending, "README.txt", strings.ToUpper(test.orphan)} {
+    if err := os.WriteFile(filepath.Join(test.path, name), []byte(name), 0644); err != nil {
+      t.Fatal(err)
+    }
+  }
+  // Only regular files are cleaned up
+  if err := os.Mkdir(filepath.Join(test.path, sha256Of(t.Name() + " directory")), 0755); err != nil {
+    t.Fatal(err)
+  }
+
+  sourceDir := "/cleanup_test/" + t.Name()
+  models.Manifests.Set("Contoso.Cleanup", t.Name(), models.API_ManifestVersion_1_10_0{
+    PackageVersion: t.Name(),
+    Installers: []models.API_Installer_1_10_0{{InstallerSha256: strings.ToUpper(test.live)}},
+  }, sourceDir + "/Contoso.Cleanup.yaml")
+  t.Cleanup(func() { models.Manifests.RemoveSourcesBelow(sourceDir) })
+
+  dm := installerDownloads
+  t.Cleanup(func() { installerDownloads = dm })
+  // Never started, so the download stays pending
+  installerDownloads = newDownloadManager(1, time.Second, func(string) {})
+  installerDownloads.enqueue(installerDownload{installerSha256: strings.ToUpper(sha256Of(t.Name() + " pending download"))})
+
+  models.InternalizedInstallers.Set(models.InternalizedInstaller{InstallerSha256: test.orphan, Path: filepath.Join(test.path, test.orphan)})
+  t.Cleanup(func() { models.InternalizedInstallers.Remove(test.orphan) })
+  return test
+}
+
+// Returns the sorted names of the files left in the autoInternalizePath
+func (test *cleanupTest) files(t *testing.T) []string {
+  entries, err := os.ReadDir(test.path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  var names []string
+  for _, entry := range entries {
+    if entry.Type().IsRegular() {
+      names = append(names, entry.Name())
+    }
+  }
+  return names
+}
+
+// Makes the cleaner believe the orphans it knows about were orphaned this long ago
+func backdate(c *installerCleaner, d time.Duration) {
+  for name, since := range c.orphanedSince {
+    c.orphanedSince[name] = since.Add(-d)
+  }
+}
+
+func TestInstallerCleanerGracePeriod(t *testing.T) {
+  test := newCleanupTest(t)
+  all := test.files(t)
+  c := newInstallerCleaner(test.path, time.Hour, false)
+
+  c.run()
+  if got := test.files(t); !slices.Equal(got, all) {
+    t.Errorf("got files %v right after they were orphaned, want %v", got, all)
+  }
+  orphaned := make([]string, 0, len(c.orphanedSince))
+  for name := range c.orphanedSince {
+    orphaned = append(orphaned, name)
+  }
+  slices.Sort(orphaned)
+  if want := []string{test.partial, test.orphan}; !slices.Equal(orphaned, want) {
+    t.Errorf("got orphans %v, want %v", orphaned, want)
+  }
+
+  backdate(c, 59 * time.Minute)
+  c.run()
+  if got := test.files(t); !slices.Equal(got, all) {
+    t.Errorf("got files %v within the grace period, want %v", got, all)
+  }
+
+  backdate(c, time.Minute)
+  c.run()
+  want := slices.DeleteFunc(slices.Clone(all), func(name string) bool { return name == test.orphan || name == test.partial })
+  if got := test.files(t); !slices.Equal(got, want) {
+    t.Errorf("got files %v after the grace period, want %v", got, want)
+  }
+  if models.InternalizedInstallers.IsInternalized(test.orphan) {
+    t.Errorf("the deleted installer is still registered")
+  }
+  if len(c.orphanedSince) != 0 {
+    t.Errorf("the deleted orphans are still remembered: %v", c.orphanedSince)
+  }
+}
+
+func TestInstallerCleanerDryRun(t *testing.T) {
+  test := newCleanupTest(t)
+  all := test.files(t)
+  c := newInstallerCleaner(test.path, 0, true)
+  for range 2 {
+    c.run()
+    backdate(c, 24 * time.Hour)
+  }
+  if got := test.files(t); !slices.Equal(got, all) {
+    t.Errorf("got files %v after a dry run, want %v", got, all)
+  }
+  if !models.InternalizedInstallers.IsInternalized(test.orphan) {
+    t.Errorf("the orphaned installer was unregistered in a dry run")
+  }
+}
+
+// An orphan that is referenced again in between has to be orphaned for the whole grace period again
+func TestInstallerCleanerForgetsReferencedOrphans(t *testing.T) {
+  test := newCleanupTest(t)
+  all := test.files(t)
+  c := newInstallerCleaner(test.path, time.Hour, false)
+  c.run()
+  backdate(c, 2 * time.Hour)
+
+  sourceFile := "/cleanup_test/" + t.Name() + "-readded/Contoso.Readded.yaml"
+  models.Manifests.Set("Contoso.Readded", "1.0.0", models.API_ManifestVersion_1_10_0{
+    PackageVersion: "1.0.0",
+    Installers: []models.API_Installer_1_10_0{{InstallerSha256: test.orphan}},
+  }, sourceFile)
+  c.run()
+  if _, ok := c.orphanedSince[test.orphan]; ok {
+    t.Errorf("the installer referenced again is still remembered as an orphan")
+  }
+  if got := test.files(t); !slices.Contains(got, test.orphan) || slices.Contains(got, test.partial) {
+    t.Errorf("got files %v, want the installer referenced again but not the partial download", got)
+  }
+
+  models.Manifests.RemoveSourcesBelow(filepath.Dir(sourceFile))
+  c.run()
+  if got := test.files(t); !slices.Contains(got, test.orphan) {
+    t.Errorf("the installer was deleted right after it was orphaned again")
+  }
+  backdate(c, time.Hour)
+  c.run()
+  want := slices.DeleteFunc(slices.Clone(all), func(name string) bool { return name == test.orphan || name == test.partial })
+  if got := test.files(t); !slices.Equal(got, want) {
+    t.Errorf("got files %v after the grace period, want %v", got, want)
+  }
+}
+
+// The files of an installer that is being downloaded are not orphans even though nothing references them yet
+func TestInstallerCleanerSkipsPendingDownloads(t *testing.T) {
+  test := newCleanupTest(t)
+  c := newInstallerCleaner(test.path, 0, false)
+  c.run()
+  got := test.files(t)
+  for _, name := range []string{test.pending, test.live, "README.txt", strings.ToUpper(test.orphan)} {
+    if !slices.Contains(got, name) {
+      t.Errorf("%v was deleted", name)
+    }
+  }
+  if slices.Contains(got, test.orphan) || slices.Contains(got, test.partial) {
+    t.Errorf("got files %v, want the orphans to be deleted without a grace period", got)
+  }
+}
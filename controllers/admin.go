@@ -0,0 +1,34 @@
+package controllers
+
+import (
+    "strings"
+    "net/http"
+    "crypto/subtle"
+
+    "rewinged/logging"
+)
+
+// AdminAuthenticator protects the endpoints under /api/admin. They are meant for the people
+// and pipelines maintaining the repository rather than winget clients, so they use their own
+// static token instead of the source authentication, which can also be turned off entirely.
+type AdminAuthenticator struct {
+    token []byte
+}
+
+func NewAdminAuthenticator(token string) *AdminAuthenticator {
+    return &AdminAuthenticator{token: []byte(token)}
+}
+
+func (a *AdminAuthenticator) Middleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+        if !found || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), a.token) != 1 {
+            logging.Logger.Info().Str("path", r.URL.Path).Msg("admin request without valid adminToken")
+            w.Header().Set("WWW-Authenticate", `Bearer realm="rewinged admin"`)
+            http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+            return
+        }
+
+        next.ServeHTTP(w, r)
+    })
+}
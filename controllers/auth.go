@@ -2,57 +2,199 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"slices"
 	"strings"
+	"time"
 
 	"rewinged/logging"
-	"rewinged/settings"
+	"rewinged/metrics"
 
+	"github.com/go-jose/go-jose/v4"
 	"github.com/coreos/go-oidc/v3/oidc"
 )
 
-func JWTAuthMiddleware(next http.Handler) http.Handler {
+// JWTAuthenticator verifies the bearer tokens of clients against one OIDC issuer.
+// Discovery and fetching the signing keys happen once when it is created, not on
+// every request.
+type JWTAuthenticator struct {
+    verifier *oidc.IDTokenVerifier
+    keySet *cachedKeySet
+    requiredClaims map[string][]string
+    policy *AuthorizationPolicy
+    clockSkew time.Duration
+}
+
+// How far in the future the nbf claim of a token may be, the same leeway go-oidc allows
+const notBeforeLeeway = 5 * time.Minute
+
+// NewJWTAuthenticator performs OIDC discovery for issuerURL and fetches its signing keys,
+// which are then refreshed in the background until ctx is cancelled. Tokens must be issued
+// for clientID (aud), must contain each of the requiredClaims with one of its listed values
+// and are still accepted for up to clockSkew after they have expired to tolerate clocks
+// drifting apart between the IdP, the clients and this server. If policy is not nil, it
+// restricts which packages each authenticated client may access.
+func NewJWTAuthenticator(ctx context.Context, issuerURL string, clientID string, requiredClaims map[string][]string, policy *AuthorizationPolicy, clockSkew time.Duration) (*JWTAuthenticator, error) {
+    client := &http.Client{Timeout: 30 * time.Second}
+
+    provider, err := oidc.NewProvider(oidc.ClientContext(ctx, client), issuerURL)
+    if err != nil {
+        return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+    }
+
+    var discovery struct {
+        Issuer string `json:"issuer"`
+        JWKSURL string `json:"jwks_uri"`
+        SigningAlgorithms []string `json:"id_token_signing_alg_values_supported"`
+    }
+    if err := provider.Claims(&discovery); err != nil {
+        return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+    }
+    if discovery.JWKSURL == "" {
+        return nil, fmt.Errorf("OIDC discovery failed: issuer %v does not advertise a jwks_uri", issuerURL)
+    }
+
+    keySet, err := newCachedKeySet(ctx, discovery.JWKSURL, client)
+    if err != nil {
+        return nil, err
+    }
+
+    // Symmetric algorithms and "none" can't be verified with the public keys of the IdP
+    var signingAlgorithms []string
+    for _, alg := range discovery.SigningAlgorithms {
+        if slices.Contains(asymmetricSigningAlgorithms, jose.SignatureAlgorithm(alg)) {
+            signingAlgorithms = append(signingAlgorithms, alg)
+        }
+    }
+
+    verifier := oidc.NewVerifier(discovery.Issuer, keySet, &oidc.Config{
+        ClientID: clientID,
+        SupportedSigningAlgs: signingAlgorithms,
+        SkipIssuerCheck: false, // Validate iss / issuer, see: https://datatracker.ietf.org/doc/html/draft-ietf-oauth-mix-up-mitigation-01
+        SkipClientIDCheck: false, // Validate aud / audience / client_id, see: https://datatracker.ietf.org/doc/html/draft-ietf-oauth-mix-up-mitigation-01
+        // go-oidc compares the exp claim strictly against the current time, so exp and nbf are checked in verify instead
+        SkipExpiryCheck: true,
+    })
+
+    return &JWTAuthenticator{verifier: verifier, keySet: keySet, requiredClaims: requiredClaims, policy: policy, clockSkew: clockSkew}, nil
+}
+
+// Verifies a token and returns it along with its claims. Expired tokens are accepted for up to
+// clockSkew, and tokens that are not valid yet for up to notBeforeLeeway before their nbf.
+func (a *JWTAuthenticator) verify(ctx context.Context, rawToken string) (*oidc.IDToken, map[string]any, error) {
+    token, err := a.verifier.Verify(ctx, rawToken)
+    if err != nil {
+        return nil, nil, err
+    }
+    claims := map[string]any{}
+    if err := token.Claims(&claims); err != nil {
+        return nil, nil, fmt.Errorf("failed to parse JWT claims: %w", err)
+    }
+
+    now := time.Now()
+    if token.Expiry.Add(a.clockSkew).Before(now) {
+        return nil, nil, &oidc.TokenExpiredError{Expiry: token.Expiry}
+    }
+    if nbf, ok := claims["nbf"].(float64); ok {
+        if notBefore := time.Unix(int64(nbf), 0); now.Add(notBeforeLeeway).Before(notBefore) {
+            return nil, nil, fmt.Errorf("token is not valid before %v", notBefore)
+        }
+    }
+    return token, claims, nil
+}
+
+// ProviderReachable reports whether the signing keys of the OIDC provider can currently be fetched
+func (a *JWTAuthenticator) ProviderReachable(ctx context.Context) error {
+    return a.keySet.check(ctx)
+}
+
+// HasSigningKeys reports whether any signing keys of the OIDC provider are known to verify tokens with
+func (a *JWTAuthenticator) HasSigningKeys() bool {
+    return len(a.keySet.lookup("")) > 0
+}
+
+// Claim names can be dotted paths to reach into nested claims, e.g. Keycloak puts
+// realm roles into realm_access.roles. If a claim is an array, it's enough for any
+// one of its elements to match.
+func lookupClaim(claims map[string]any, name string) (any, bool) {
+    var value any = claims
+    for _, part := range strings.Split(name, ".") {
+        object, ok := value.(map[string]any)
+        if !ok {
+            return nil, false
+        }
+        if value, ok = object[part]; !ok {
+            return nil, false
+        }
+    }
+    return value, true
+}
+
+func claimMatches(claim any, allowedValues []string) bool {
+    if elements, ok := claim.([]any); ok {
+        return slices.ContainsFunc(elements, func(element any) bool {
+            return claimMatches(element, allowedValues)
+        })
+    }
+    switch claim.(type) {
+    case string, float64, bool:
+        return slices.Contains(allowedValues, fmt.Sprint(claim))
+    default:
+        return false
+    }
+}
+
+func (a *JWTAuthenticator) checkRequiredClaims(claims map[string]any) error {
+    for name, allowedValues := range a.requiredClaims {
+        claim, ok := lookupClaim(claims, name)
+        if !ok {
+            return fmt.Errorf("required claim %v is missing", name)
+        }
+        if !claimMatches(claim, allowedValues) {
+            return fmt.Errorf("required claim %v does not have any of the allowed values %v", name, allowedValues)
+        }
+    }
+    return nil
+}
+
+func (a *JWTAuthenticator) Middleware(next http.Handler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
         rawAuthHeader := r.Header.Get("Authorization")
         if rawAuthHeader == "" {
             logging.Logger.Info().Msg("client request missing Authorization header")
+            metrics.AuthFailures.WithLabelValues(metrics.AuthMissingToken).Inc()
             http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
             return
         }
 
         idToken := strings.TrimSpace(strings.Replace(rawAuthHeader, "Bearer", "", 1))
-        ctxBg := context.Background()
 
-        provider, err := oidc.NewProvider(ctxBg, settings.SourceAuthenticationEntraIDAuthorityURL)
+        parsedToken, claims, err := a.verify(r.Context(), idToken)
         if err != nil {
-            logging.Logger.Err(err).Msg("could not create OIDC provider")
-            http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized) // maybe this is more of a server-error but let's not tell the client this happened
+            logging.Logger.Err(err).Msg("jwt didn't check out / no valid auth")
+            metrics.AuthFailures.WithLabelValues(metrics.AuthInvalidToken).Inc()
+            http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
             return
         }
-        verifier := provider.Verifier(&oidc.Config{
-            ClientID: settings.SourceAuthenticationEntraIDResource,
-            SkipIssuerCheck: false, // Validate iss / issuer, see: https://datatracker.ietf.org/doc/html/draft-ietf-oauth-mix-up-mitigation-01
-            SkipClientIDCheck: false, // Validate aud / audience / client_id, see: https://datatracker.ietf.org/doc/html/draft-ietf-oauth-mix-up-mitigation-01
-        })
 
-        parsedToken, err := verifier.Verify(ctxBg, idToken)
-        if err != nil {
-            logging.Logger.Err(err).Msg("jwt didn't check out / no valid auth")
-            http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+        if err := a.checkRequiredClaims(claims); err != nil {
+            logging.Logger.Info().Err(err).Str("sub", parsedToken.Subject).Msg("client is authenticated but not authorized")
+            metrics.AuthFailures.WithLabelValues(metrics.AuthMissingClaims).Inc()
+            http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
             return
         }
 
         // Auth checked out!
         logging.Logger.Debug().Msgf("OIDC token info: User (sub) '%v' from IdP (iss) '%v' authenticated", parsedToken.Subject, parsedToken.Issuer)
 
-        //claims := map[string]interface{}{}
-        //if parsedToken.Claims(&claims); err != nil {
-        //    logging.Logger.Err(err).Msg("failed to parse JWT claims")
-        //}
         //claimsJSON, _ := json.MarshalIndent(claims, "", "  ")
         //logging.Logger.Debug().Msg(string(claimsJSON))
 
+        if a.policy != nil {
+            r = r.WithContext(withAllowedPackagePatterns(r.Context(), a.policy.allowedPackagePatterns(claims)))
+        }
+
         next.ServeHTTP(w, r)
     })
 }
-
@@ -0,0 +1,209 @@
+package controllers
+
+import (
+    "crypto/rand"
+    "crypto/rsa"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/go-jose/go-jose/v4"
+)
+
+// A local stand-in for an OIDC issuer, with one signing key that can be rotated
+type testIssuer struct {
+    *httptest.Server
+    jwksRequests atomic.Int32
+    jwksDown atomic.Bool
+
+    mu sync.Mutex
+    key *rsa.PrivateKey
+    keyID string
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+    issuer := &testIssuer{}
+    issuer.rotateKey(t, "key-1")
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+        json.NewEncoder(w).Encode(map[string]any{
+            "issuer": issuer.URL,
+            "jwks_uri": issuer.URL + "/keys",
+            "id_token_signing_alg_values_supported": []string{"RS256", "HS256"},
+        })
+    })
+    mux.HandleFunc("GET /keys", func(w http.ResponseWriter, r *http.Request) {
+        issuer.jwksRequests.Add(1)
+        if issuer.jwksDown.Load() {
+            http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+            return
+        }
+        issuer.mu.Lock()
+        defer issuer.mu.Unlock()
+        json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+            {Key: &issuer.key.PublicKey, KeyID: issuer.keyID, Algorithm: string(jose.RS256), Use: "sig"},
+        }})
+    })
+    issuer.Server = httptest.NewServer(mux)
+    t.Cleanup(issuer.Close)
+    return issuer
+}
+
+func (i *testIssuer) rotateKey(t *testing.T, keyID string) {
+    key, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatal(err)
+    }
+    i.mu.Lock()
+    i.key, i.keyID = key, keyID
+    i.mu.Unlock()
+}
+
+// Signs a token with the current key of the issuer. The standard claims are valid
+// for an hour and can be changed or removed (by setting them to nil) with claims.
+func (i *testIssuer) sign(t *testing.T, claims map[string]any) string {
+    payload := map[string]any{
+        "iss": i.URL,
+        "aud": "rewinged",
+        "sub": "client",
+        "iat": time.Now().Unix(),
+        "exp": time.Now().Add(time.Hour).Unix(),
+    }
+    for name, value := range claims {
+        if value == nil {
+            delete(payload, name)
+        } else {
+            payload[name] = value
+        }
+    }
+    data, err := json.Marshal(payload)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    i.mu.Lock()
+    key := jose.JSONWebKey{Key: i.key, KeyID: i.keyID}
+    i.mu.Unlock()
+    signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    signed, err := signer.Sign(data)
+    if err != nil {
+        t.Fatal(err)
+    }
+    token, err := signed.CompactSerialize()
+    if err != nil {
+        t.Fatal(err)
+    }
+    return token
+}
+
+func authenticateRequest(a *JWTAuthenticator, token string) int {
+    r := httptest.NewRequest(http.MethodGet, "/packages", nil)
+    if token != "" {
+        r.Header.Set("Authorization", "Bearer " + token)
+    }
+    w := httptest.NewRecorder()
+    a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusOK)
+    })).ServeHTTP(w, r)
+    return w.Code
+}
+
+func TestJWTAuthenticatorMiddleware(t *testing.T) {
+    issuer := newTestIssuer(t)
+    otherIssuer := newTestIssuer(t)
+    a, err := NewJWTAuthenticator(t.Context(), issuer.URL, "rewinged", map[string][]string{"roles": {"winget"}}, nil, 5 * time.Minute)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    now := time.Now()
+    tests := []struct {
+        name string
+        token string
+        want int
+    }{
+        {"valid", issuer.sign(t, map[string]any{"roles": []string{"other", "winget"}}), http.StatusOK},
+        {"no token", "", http.StatusUnauthorized},
+        {"malformed", "not.a.jwt", http.StatusUnauthorized},
+        {"expired within the clock skew", issuer.sign(t, map[string]any{"roles": "winget", "exp": now.Add(-2 * time.Minute).Unix()}), http.StatusOK},
+        {"expired beyond the clock skew", issuer.sign(t, map[string]any{"roles": "winget", "exp": now.Add(-10 * time.Minute).Unix()}), http.StatusUnauthorized},
+        {"without expiry", issuer.sign(t, map[string]any{"roles": "winget", "exp": nil}), http.StatusUnauthorized},
+        {"not valid yet within the leeway", issuer.sign(t, map[string]any{"roles": "winget", "nbf": now.Add(2 * time.Minute).Unix()}), http.StatusOK},
+        {"not valid yet beyond the leeway", issuer.sign(t, map[string]any{"roles": "winget", "nbf": now.Add(10 * time.Minute).Unix()}), http.StatusUnauthorized},
+        {"issued in the future", issuer.sign(t, map[string]any{"roles": "winget", "iat": now.Add(2 * time.Minute).Unix()}), http.StatusOK},
+        {"other audience", issuer.sign(t, map[string]any{"roles": "winget", "aud": "someone-else"}), http.StatusUnauthorized},
+        {"other issuer", issuer.sign(t, map[string]any{"roles": "winget", "iss": otherIssuer.URL}), http.StatusUnauthorized},
+        {"signed by another issuer", otherIssuer.sign(t, map[string]any{"roles": "winget", "iss": issuer.URL}), http.StatusUnauthorized},
+        {"missing required claim", issuer.sign(t, nil), http.StatusForbidden},
+        {"wrong required claim", issuer.sign(t, map[string]any{"roles": "admin"}), http.StatusForbidden},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := authenticateRequest(a, tt.token); got != tt.want {
+                t.Errorf("got status %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestJWTAuthenticatorKeyRotation(t *testing.T) {
+    issuer := newTestIssuer(t)
+    a, err := NewJWTAuthenticator(t.Context(), issuer.URL, "rewinged", nil, nil, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    allowRefetch := func() {
+        a.keySet.fetchMu.Lock()
+        a.keySet.lastAttempt = time.Now().Add(-signingKeysMinimumRefetchInterval)
+        a.keySet.fetchMu.Unlock()
+    }
+    // Sends several requests at once and returns how often the keys were fetched for them
+    concurrently := func(token string, want int) int32 {
+        before := issuer.jwksRequests.Load()
+        var wg sync.WaitGroup
+        for range 10 {
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                if got := authenticateRequest(a, token); got != want {
+                    t.Errorf("got status %v, want %v", got, want)
+                }
+            }()
+        }
+        wg.Wait()
+        return issuer.jwksRequests.Load() - before
+    }
+
+    issuer.rotateKey(t, "key-2")
+    rotated := issuer.sign(t, nil)
+    if fetches := concurrently(rotated, http.StatusUnauthorized); fetches != 0 {
+        t.Errorf("keys were fetched %v times right after they were fetched at startup", fetches)
+    }
+    allowRefetch()
+    if fetches := concurrently(rotated, http.StatusOK); fetches != 1 {
+        t.Errorf("keys were fetched %v times for the rotated key, want once", fetches)
+    }
+
+    issuer.rotateKey(t, "key-3")
+    issuer.jwksDown.Store(true)
+    allowRefetch()
+    unknown := issuer.sign(t, nil)
+    if fetches := concurrently(unknown, http.StatusUnauthorized); fetches != 1 {
+        t.Errorf("keys were fetched %v times while the IdP is down, want once", fetches)
+    }
+    if fetches := concurrently(unknown, http.StatusUnauthorized); fetches != 0 {
+        t.Errorf("keys were fetched %v times again right after a failed attempt", fetches)
+    }
+    // The keys fetched before the IdP went down are still used
+    if got := authenticateRequest(a, rotated); got != http.StatusOK {
+        t.Errorf("got status %v for a token signed by a known key while the IdP is down, want %v", got, http.StatusOK)
+    }
+}
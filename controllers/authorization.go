@@ -0,0 +1,118 @@
+package controllers
+
+import (
+    "fmt"
+    "os"
+    "path"
+    "context"
+    "strings"
+    "net/http"
+    "encoding/json"
+
+    "rewinged/logging"
+    "rewinged/models"
+)
+
+// An AuthorizationRule grants access to all packages whose PackageIdentifier matches
+// one of the Packages patterns to clients whose token contains the Claim with one of
+// the Values. A rule without a Claim applies to every authenticated client.
+type AuthorizationRule struct {
+    Claim string `json:"claim"`
+    Values []string `json:"values"`
+    Packages []string `json:"packages"`
+}
+
+// An AuthorizationPolicy restricts which packages authenticated clients may see and
+// download. Packages not granted by any of the rules are hidden from the client.
+type AuthorizationPolicy struct {
+    Rules []AuthorizationRule `json:"rules"`
+}
+
+func LoadAuthorizationPolicy(file string) (*AuthorizationPolicy, error) {
+    f, err := os.Open(file)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    policy := new(AuthorizationPolicy)
+    d := json.NewDecoder(f)
+    d.DisallowUnknownFields() // catch typos in property names, they would silently change the meaning of a rule
+    if err := d.Decode(policy); err != nil {
+        return nil, err
+    }
+
+    for i, rule := range policy.Rules {
+        if rule.Claim != "" && len(rule.Values) == 0 {
+            return nil, fmt.Errorf("rule %d: a claim requires at least one value", i)
+        }
+        for _, pattern := range rule.Packages {
+            if _, err := path.Match(pattern, ""); err != nil {
+                return nil, fmt.Errorf("rule %d: invalid package pattern '%v': %w", i, pattern, err)
+            }
+        }
+    }
+    return policy, nil
+}
+
+// Returns the patterns of all PackageIdentifiers the rules grant to a client with these token claims
+func (p *AuthorizationPolicy) allowedPackagePatterns(claims map[string]any) []string {
+    patterns := []string{}
+    for _, rule := range p.Rules {
+        if rule.Claim != "" {
+            claim, ok := lookupClaim(claims, rule.Claim)
+            if !ok || !claimMatches(claim, rule.Values) {
+                continue
+            }
+        }
+        patterns = append(patterns, rule.Packages...)
+    }
+    return patterns
+}
+
+type contextKey int
+
+const allowedPackagePatternsKey contextKey = iota
+
+func withAllowedPackagePatterns(ctx context.Context, patterns []string) context.Context {
+    return context.WithValue(ctx, allowedPackagePatternsKey, patterns)
+}
+
+// Reports whether the client of this request may see the package. Without an authorization
+// policy, or without authentication at all, every client may see every package.
+// PackageIdentifiers are case-insensitive, so the patterns are too.
+func packageAllowed(r *http.Request, packageIdentifier string) bool {
+    patterns, ok := r.Context().Value(allowedPackagePatternsKey).([]string)
+    if !ok {
+        return true
+    }
+    for _, pattern := range patterns {
+        if matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(packageIdentifier)); matched {
+            return true
+        }
+    }
+    return false
+}
+
+// AuthorizeInstallerDownloads only serves an internalized installer to clients that may see
+// at least one of the packages using it. It has to be placed after the authentication middleware.
+func AuthorizeInstallerDownloads(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if _, ok := r.Context().Value(allowedPackagePatternsKey).([]string); !ok {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        // Internalized installers are stored under their InstallerSha256
+        installerSha256 := path.Base(r.URL.Path)
+        for _, packageIdentifier := range models.Manifests.GetPackageIdentifiersByInstallerSha(installerSha256) {
+            if packageAllowed(r, packageIdentifier) {
+                next.ServeHTTP(w, r)
+                return
+            }
+        }
+
+        logging.Logger.Info().Str("installer", installerSha256).Msg("client is not authorized to download this installer")
+        http.NotFound(w, r)
+    })
+}
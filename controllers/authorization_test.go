@@ -0,0 +1,169 @@
+package controllers
+
+import (
+    "os"
+    "slices"
+    "strings"
+    "testing"
+    "net/http"
+    "net/http/httptest"
+    "path/filepath"
+
+    "rewinged/models"
+)
+
+func TestLoadAuthorizationPolicy(t *testing.T) {
+    tests := []struct {
+        name string
+        policy string
+        wantErr string
+    }{
+        {"valid", `{"rules": [{"claim": "groups", "values": ["developers"], "packages": ["Contoso.*"]}, {"packages": ["Fabrikam.Tool"]}]}`, ""},
+        {"no rules", `{}`, ""},
+        {"unknown field", `{"rules": [{"claims": "groups", "values": ["developers"], "packages": ["Contoso.*"]}]}`, "unknown field"},
+        {"claim without values", `{"rules": [{"packages": ["*"]}, {"claim": "groups", "packages": ["Contoso.*"]}]}`, "rule 1: a claim requires at least one value"},
+        {"invalid pattern", `{"rules": [{"packages": ["Contoso.["]}]}`, "rule 0: invalid package pattern"},
+        {"invalid JSON", `{"rules": [`, "unexpected EOF"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            file := filepath.Join(t.TempDir(), "policy.json")
+            if err := os.WriteFile(file, []byte(tt.policy), 0644); err != nil {
+                t.Fatal(err)
+            }
+            policy, err := LoadAuthorizationPolicy(file)
+            if tt.wantErr == "" && (err != nil || policy == nil) {
+                t.Fatalf("got %v, %v", policy, err)
+            }
+            if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+                t.Fatalf("got error %v, want %q", err, tt.wantErr)
+            }
+        })
+    }
+
+    if _, err := LoadAuthorizationPolicy(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+        t.Errorf("loading a missing policy file didn't fail")
+    }
+}
+
+func TestAllowedPackagePatterns(t *testing.T) {
+    policy := &AuthorizationPolicy{Rules: []AuthorizationRule{
+        {Packages: []string{"Public.*"}},
+        {Claim: "groups", Values: []string{"developers", "testers"}, Packages: []string{"Contoso.*"}},
+        {Claim: "realm_access.roles", Values: []string{"admin"}, Packages: []string{"*"}},
+        {Claim: "department", Values: []string{"finance"}, Packages: []string{"Fabrikam.Ledger", "Fabrikam.Budget"}},
+        {Claim: "employee", Values: []string{"true"}, Packages: []string{"Internal.*"}},
+    }}
+    tests := []struct {
+        name string
+        claims map[string]any
+        want []string
+    }{
+        {"no claims", map[string]any{}, []string{"Public.*"}},
+        {"string claim", map[string]any{"department": "finance"}, []string{"Public.*", "Fabrikam.Ledger", "Fabrikam.Budget"}},
+        {"string claim with another value", map[string]any{"department": "sales"}, []string{"Public.*"}},
+        {"array claim", map[string]any{"groups": []any{"everyone", "testers"}}, []string{"Public.*", "Contoso.*"}},
+        {"array claim without a matching element", map[string]any{"groups": []any{"everyone"}}, []string{"Public.*"}},
+        {"nested claim", map[string]any{"realm_access": map[string]any{"roles": []any{"admin"}}}, []string{"Public.*", "*"}},
+        {"nested claim at the top level", map[string]any{"realm_access.roles": []any{"admin"}}, []string{"Public.*"}},
+        {"claim that isn't an object", map[string]any{"realm_access": "admin"}, []string{"Public.*"}},
+        {"boolean claim", map[string]any{"employee": true}, []string{"Public.*", "Internal.*"}},
+        {"object claim", map[string]any{"department": map[string]any{"name": "finance"}}, []string{"Public.*"}},
+        {
+            "several rules",
+            map[string]any{"groups": []any{"developers"}, "department": "finance"},
+            []string{"Public.*", "Contoso.*", "Fabrikam.Ledger", "Fabrikam.Budget"},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := policy.allowedPackagePatterns(tt.claims); !slices.Equal(got, tt.want) {
+                t.Errorf("got %q, want %q", got, tt.want)
+            }
+        })
+    }
+
+    // Authenticated clients that no rule applies to must not be treated like clients without a policy
+    if got := (&AuthorizationPolicy{}).allowedPackagePatterns(map[string]any{}); got == nil || len(got) != 0 {
+        t.Errorf("got %#v, want no patterns", got)
+    }
+}
+
+func TestPackageAllowed(t *testing.T) {
+    tests := []struct {
+        name string
+        patterns []string
+        packageIdentifier string
+        want bool
+    }{
+        {"exact match", []string{"Contoso.App"}, "Contoso.App", true},
+        {"wildcard", []string{"Contoso.*"}, "Contoso.App", true},
+        {"no match", []string{"Contoso.*"}, "Fabrikam.App", false},
+        {"case-insensitive pattern", []string{"contoso.*"}, "Contoso.App", true},
+        {"case-insensitive PackageIdentifier", []string{"Contoso.App"}, "CONTOSO.APP", true},
+        {"any of the patterns", []string{"Fabrikam.*", "Contoso.App"}, "Contoso.App", true},
+        {"no patterns", []string{}, "Contoso.App", false},
+        {"single character wildcard", []string{"Contoso.App?"}, "Contoso.App2", true},
+        {"wildcard within a segment only", []string{"Contoso"}, "Contoso.App", false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            r := httptest.NewRequest(http.MethodGet, "/packageManifests/" + tt.packageIdentifier, nil)
+            r = r.WithContext(withAllowedPackagePatterns(r.Context(), tt.patterns))
+            if got := packageAllowed(r, tt.packageIdentifier); got != tt.want {
+                t.Errorf("got %v, want %v", got, tt.want)
+            }
+        })
+    }
+
+    // Without an authorization policy, every package is allowed
+    if !packageAllowed(httptest.NewRequest(http.MethodGet, "/packageManifests/Contoso.App", nil), "Contoso.App") {
+        t.Errorf("a package isn't allowed without an authorization policy")
+    }
+}
+
+func TestAuthorizeInstallerDownloads(t *testing.T) {
+    sharedSha256 := strings.Repeat("a", 64)
+    deniedSha256 := strings.Repeat("b", 64)
+    manifest := func(installerSha256s ...string) models.API_ManifestVersion_1_10_0 {
+        version := models.API_ManifestVersion_1_10_0{PackageVersion: "1.0.0"}
+        for _, installerSha256 := range installerSha256s {
+            version.Installers = append(version.Installers, models.API_Installer_1_10_0{InstallerSha256: strings.ToUpper(installerSha256)})
+        }
+        return version
+    }
+    models.Manifests.Set("Contoso.Allowed", "1.0.0", manifest(sharedSha256), "/authorization_test/Contoso.Allowed.yaml")
+    models.Manifests.Set("Fabrikam.Denied", "1.0.0", manifest(sharedSha256, deniedSha256), "/authorization_test/Fabrikam.Denied.yaml")
+    t.Cleanup(func() { models.Manifests.RemoveSourcesBelow("/authorization_test") })
+
+    handler := AuthorizeInstallerDownloads(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusOK)
+    }))
+    tests := []struct {
+        name string
+        installerSha256 string
+        // nil when there is no authorization policy
+        patterns []string
+        want int
+    }{
+        {"installer shared with an allowed package", sharedSha256, []string{"Contoso.*"}, http.StatusOK},
+        {"installer of a denied package only", deniedSha256, []string{"Contoso.*"}, http.StatusNotFound},
+        {"installer of the package allowed case-insensitively", deniedSha256, []string{"fabrikam.denied"}, http.StatusOK},
+        {"installer of no package at all", strings.Repeat("c", 64), []string{"*"}, http.StatusNotFound},
+        {"no packages allowed", sharedSha256, []string{}, http.StatusNotFound},
+        {"no authorization policy", deniedSha256, nil, http.StatusOK},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            r := httptest.NewRequest(http.MethodGet, "/installers/" + tt.installerSha256, nil)
+            if tt.patterns != nil {
+                r = r.WithContext(withAllowedPackagePatterns(r.Context(), tt.patterns))
+            }
+            w := httptest.NewRecorder()
+            handler.ServeHTTP(w, r)
+            if w.Code != tt.want {
+                t.Errorf("got status %v, want %v", w.Code, tt.want)
+            }
+        })
+    }
+}
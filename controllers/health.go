@@ -0,0 +1,77 @@
+package controllers
+
+import (
+    "net/http"
+    "sync/atomic"
+    "encoding/json"
+)
+
+// HealthHandler serves the liveness and readiness endpoints. rewinged starts answering
+// requests while it is still ingesting manifests, so only once it reports ready are its
+// package listings and search results complete.
+type HealthHandler struct {
+    // Only set when source authentication is enabled
+    Authenticator *JWTAuthenticator
+
+    initialScanDone atomic.Bool
+    rescanInProgress atomic.Bool
+}
+
+type healthResponse struct {
+    Status string `json:"status"`
+    Checks map[string]string `json:"checks,omitempty"`
+}
+
+func (h *HealthHandler) SetInitialScanDone() {
+    h.initialScanDone.Store(true)
+}
+
+// A full rescan of the manifestPath is needed when live-reload events were lost,
+// until it's done manifests may be missing or outdated
+func (h *HealthHandler) SetRescanInProgress(inProgress bool) {
+    h.rescanInProgress.Store(inProgress)
+}
+
+// GetHealth reports whether rewinged is running at all, it never depends on anything else
+func (h *HealthHandler) GetHealth(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusOK)
+    json.NewEncoder(w).Encode(healthResponse{Status: "ok"})
+}
+
+// GetReadiness reports whether rewinged should receive traffic, and if not, why
+func (h *HealthHandler) GetReadiness(w http.ResponseWriter, r *http.Request) {
+    response := healthResponse{Status: "ready", Checks: map[string]string{}}
+
+    response.Checks["initialScan"] = "ok"
+    if !h.initialScanDone.Load() {
+        response.Checks["initialScan"] = "manifests are still being loaded"
+        response.Status = "not ready"
+    }
+
+    response.Checks["rescan"] = "ok"
+    if h.rescanInProgress.Load() {
+        response.Checks["rescan"] = "manifests are being rescanned because live-reload events were lost"
+        response.Status = "not ready"
+    }
+
+    if h.Authenticator != nil {
+        response.Checks["oidcProvider"] = "ok"
+        if err := h.Authenticator.ProviderReachable(r.Context()); err != nil {
+            // Tokens can still be verified with the keys fetched before, taking every replica
+            // out of rotation whenever the IdP has a hiccup would only make things worse
+            response.Checks["oidcProvider"] = err.Error()
+            if !h.Authenticator.HasSigningKeys() {
+                response.Status = "not ready"
+            }
+        }
+    }
+
+    status := http.StatusOK
+    if response.Status != "ready" {
+        status = http.StatusServiceUnavailable
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func getReadiness(t *testing.T, h *HealthHandler) (int, healthResponse) {
+    w := httptest.NewRecorder()
+    h.GetReadiness(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+    var response healthResponse
+    if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+        t.Fatal(err)
+    }
+    return w.Code, response
+}
+
+func TestGetReadiness(t *testing.T) {
+    h := &HealthHandler{}
+    if code, response := getReadiness(t, h); code != http.StatusServiceUnavailable {
+        t.Errorf("got status %v before the initial scan, want %v: %v", code, http.StatusServiceUnavailable, response)
+    }
+    h.SetInitialScanDone()
+    if code, response := getReadiness(t, h); code != http.StatusOK {
+        t.Errorf("got status %v after the initial scan, want %v: %v", code, http.StatusOK, response)
+    }
+    h.SetRescanInProgress(true)
+    if code, response := getReadiness(t, h); code != http.StatusServiceUnavailable {
+        t.Errorf("got status %v during a rescan, want %v: %v", code, http.StatusServiceUnavailable, response)
+    }
+}
+
+func TestGetReadinessProviderDown(t *testing.T) {
+    issuer := newTestIssuer(t)
+    a, err := NewJWTAuthenticator(t.Context(), issuer.URL, "rewinged", nil, nil, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    h := &HealthHandler{Authenticator: a}
+    h.SetInitialScanDone()
+
+    issuer.jwksDown.Store(true)
+    code, response := getReadiness(t, h)
+    if code != http.StatusOK {
+        t.Errorf("got status %v while the IdP is down, want %v as the keys fetched before are still used: %v", code, http.StatusOK, response)
+    }
+    if response.Checks["oidcProvider"] == "ok" {
+        t.Errorf("the unreachable IdP is not reported: %v", response)
+    }
+    if got := authenticateRequest(a, issuer.sign(t, nil)); got != http.StatusOK {
+        t.Errorf("got status %v for a token signed by a known key while the IdP is down, want %v", got, http.StatusOK)
+    }
+
+    // Without any keys to verify tokens with, no client could be served
+    a.keySet.mu.Lock()
+    a.keySet.keys.Keys = nil
+    a.keySet.mu.Unlock()
+    if code, response := getReadiness(t, h); code != http.StatusServiceUnavailable {
+        t.Errorf("got status %v without any signing keys, want %v: %v", code, http.StatusServiceUnavailable, response)
+    }
+}
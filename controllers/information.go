@@ -14,9 +14,7 @@ func GetInformation(w http.ResponseWriter, r *http.Request) {
     // will be omitted if unset making the response identical to API 1.1.0
     response := new(models.API_Information_1_7_0)
     response.Data.SourceIdentifier = "rewinged"
-    // New API schema versions have to be included here or winget CLI client won't pick
-    // up the features / data fields from newer packages even if they are returned
-    response.Data.ServerSupportedVersions = []string{"1.1.0", "1.4.0", "1.5.0", "1.6.0", "1.7.0", "1.9.0", "1.10.0"}
+    response.Data.ServerSupportedVersions = supportedApiVersions
 
     switch settings.SourceAuthenticationType {
     case "microsoftEntraId":
@@ -32,6 +30,9 @@ func GetInformation(w http.ResponseWriter, r *http.Request) {
                 Scope: "user_impersonation",
             },
         }
+    case "oidc":
+        // winget only knows how to obtain tokens from Entra ID, so there is nothing to advertise
+        // for a generic OIDC provider. Clients have to bring their own token in this case.
     default:
     }
 
@@ -0,0 +1,183 @@
+package controllers
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "sync"
+    "time"
+
+    "github.com/go-jose/go-jose/v4"
+
+    "rewinged/logging"
+)
+
+// How often the signing keys of the IdP are re-fetched in the background, how long
+// to wait at least after the last attempt, successful or not, before fetching them again
+// because a token referenced an unknown key, and for how long the result of checking
+// whether the IdP is reachable is reused.
+const (
+    signingKeysRefreshInterval = 1 * time.Hour
+    signingKeysMinimumRefetchInterval = 1 * time.Minute
+    providerCheckInterval = 30 * time.Second
+    providerCheckTimeout = 5 * time.Second
+)
+
+var asymmetricSigningAlgorithms = []jose.SignatureAlgorithm{
+    jose.RS256, jose.RS384, jose.RS512,
+    jose.ES256, jose.ES384, jose.ES512,
+    jose.PS256, jose.PS384, jose.PS512,
+    jose.EdDSA,
+}
+
+// cachedKeySet implements oidc.KeySet. Unlike oidc.RemoteKeySet, which only ever
+// re-fetches keys when it sees a token signed by an unknown key, it also refreshes
+// them periodically so that keys the IdP has revoked stop being accepted, and it
+// keeps the last known keys if a refresh fails so a flaky IdP doesn't lock clients out.
+type cachedKeySet struct {
+    jwksURL string
+    client *http.Client
+
+    // Held while fetching, so that concurrent requests with an unknown key wait for
+    // one fetch instead of each asking the IdP themselves
+    fetchMu sync.Mutex
+    lastAttempt time.Time
+
+    mu sync.RWMutex
+    keys jose.JSONWebKeySet
+
+    checkMu sync.Mutex
+    lastCheck time.Time
+    lastCheckErr error
+}
+
+func newCachedKeySet(ctx context.Context, jwksURL string, client *http.Client) (*cachedKeySet, error) {
+    keySet := &cachedKeySet{
+        jwksURL: jwksURL,
+        client: client,
+    }
+    if err := keySet.refresh(ctx); err != nil {
+        return nil, err
+    }
+    go keySet.refreshPeriodically(ctx)
+    return keySet, nil
+}
+
+func (k *cachedKeySet) refresh(ctx context.Context) error {
+    k.fetchMu.Lock()
+    defer k.fetchMu.Unlock()
+    return k.fetch(ctx)
+}
+
+// Caller must hold fetchMu.
+func (k *cachedKeySet) fetch(ctx context.Context) error {
+    k.lastAttempt = time.Now()
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.jwksURL, nil)
+    if err != nil {
+        return err
+    }
+    resp, err := k.client.Do(req)
+    if err != nil {
+        return fmt.Errorf("fetching signing keys: %w", err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("fetching signing keys: unexpected status %v", resp.Status)
+    }
+
+    var keys jose.JSONWebKeySet
+    if err := json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+        return fmt.Errorf("decoding signing keys: %w", err)
+    }
+
+    k.mu.Lock()
+    k.keys = keys
+    k.mu.Unlock()
+    logging.Logger.Debug().Str("jwks_uri", k.jwksURL).Msgf("fetched %v signing keys", len(keys.Keys))
+    return nil
+}
+
+func (k *cachedKeySet) refreshPeriodically(ctx context.Context) {
+    ticker := time.NewTicker(signingKeysRefreshInterval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            if err := k.refresh(ctx); err != nil {
+                logging.Logger.Warn().Err(err).Str("jwks_uri", k.jwksURL).Msg("could not refresh signing keys, keeping the previous ones")
+            }
+        }
+    }
+}
+
+// Reports whether the signing keys can currently be fetched from the IdP. Readiness probes
+// can come in every few seconds from several sources, so the IdP is only actually asked
+// once per providerCheckInterval and the result is reused in between. The check is not
+// aborted when the probe that triggered it gives up, the result is for the next probes too.
+func (k *cachedKeySet) check(ctx context.Context) error {
+    k.checkMu.Lock()
+    defer k.checkMu.Unlock()
+    if !k.lastCheck.IsZero() && time.Since(k.lastCheck) < providerCheckInterval {
+        return k.lastCheckErr
+    }
+    ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), providerCheckTimeout)
+    defer cancel()
+    k.lastCheckErr = k.refresh(ctx)
+    k.lastCheck = time.Now()
+    if k.lastCheckErr != nil {
+        logging.Logger.Warn().Err(k.lastCheckErr).Str("jwks_uri", k.jwksURL).Msg("IdP is unreachable, keeping the previous signing keys")
+    }
+    return k.lastCheckErr
+}
+
+func (k *cachedKeySet) lookup(keyID string) []jose.JSONWebKey {
+    k.mu.RLock()
+    defer k.mu.RUnlock()
+    if keyID == "" {
+        return k.keys.Keys
+    }
+    return k.keys.Key(keyID)
+}
+
+// Fetches the signing keys again for a token signed by a key that's not known yet, because the
+// IdP may have rotated its keys since the last refresh. While the IdP is down or a client keeps
+// sending tokens with a bogus key ID, it's asked at most once per signingKeysMinimumRefetchInterval.
+func (k *cachedKeySet) refetchFor(ctx context.Context, keyID string) ([]jose.JSONWebKey, error) {
+    k.fetchMu.Lock()
+    defer k.fetchMu.Unlock()
+    // Another request may have fetched the keys while this one was waiting
+    if keys := k.lookup(keyID); len(keys) > 0 || time.Since(k.lastAttempt) < signingKeysMinimumRefetchInterval {
+        return keys, nil
+    }
+    if err := k.fetch(ctx); err != nil {
+        return nil, err
+    }
+    return k.lookup(keyID), nil
+}
+
+func (k *cachedKeySet) VerifySignature(ctx context.Context, jwt string) ([]byte, error) {
+    // The verifier has already checked the algorithm against the allowed ones
+    jws, err := jose.ParseSigned(jwt, asymmetricSigningAlgorithms)
+    if err != nil {
+        return nil, fmt.Errorf("malformed jwt: %w", err)
+    }
+    keyID := jws.Signatures[0].Header.KeyID
+
+    keys := k.lookup(keyID)
+    if len(keys) == 0 {
+        if keys, err = k.refetchFor(ctx, keyID); err != nil {
+            return nil, err
+        }
+    }
+
+    for _, key := range keys {
+        if payload, err := jws.Verify(&key); err == nil {
+            return payload, nil
+        }
+    }
+    return nil, errors.New("no signing key of the IdP matches the token signature")
+}
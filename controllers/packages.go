@@ -2,18 +2,37 @@ package controllers
 
 import (
     "fmt"
+    "maps"
+    "slices"
     "strings"
     "net/http"
     "encoding/json"
 
+    "rewinged/forwarded"
     "rewinged/logging"
+    "rewinged/metrics"
     "rewinged/settings"
     "rewinged/models"
 )
 
 func GetPackages(w http.ResponseWriter, r *http.Request) {
+    allowedPackages := slices.DeleteFunc(models.Manifests.GetAllPackageIdentifiers(), func(p models.API_Package) bool {
+        return !packageAllowed(r, p.PackageIdentifier)
+    })
+    packages, continuationToken, err := paginate(
+        allowedPackages,
+        func(p models.API_Package) string { return p.PackageIdentifier },
+        getContinuationToken(r),
+        0,
+    )
+    if err != nil {
+        writeInvalidContinuationToken(w, err)
+        return
+    }
+
     response := &models.API_PackageMultipleResponse{
-        Data: models.Manifests.GetAllPackageIdentifiers(),
+        Data: packages,
+        ContinuationToken: continuationToken,
     }
 
     logging.Logger.Debug().Msgf("%v", response)
@@ -24,43 +43,94 @@ func GetPackages(w http.ResponseWriter, r *http.Request) {
 }
 
 type GetPackageHandler struct {
-    TlsEnabled bool
     InternalizationEnabled bool
 }
 
+// The query parameters of GET /packageManifests that are applied to the returned versions
+var supportedPackageManifestsQueryParameters = []string{"Version", "Channel", "Market"}
+
+// Returns the value of a query parameter, ignoring the case of its name
+func getQueryParameter(r *http.Request, name string) string {
+  for parameter, values := range r.URL.Query() {
+    if strings.EqualFold(parameter, name) && len(values) > 0 {
+      return values[0]
+    }
+  }
+  return ""
+}
+
+// An installer is available in a market if it is not excluded from it and, if the
+// installer is restricted to a list of allowed markets, the market is in that list.
+func availableInMarket(installer models.API_InstallerInterface, market string) bool {
+  isMarket := func(m string) bool { return strings.EqualFold(m, market) }
+  allowedMarkets, excludedMarkets := installer.GetMarkets()
+  if len(allowedMarkets) > 0 && !slices.ContainsFunc(allowedMarkets, isMarket) {
+    return false
+  }
+  return !slices.ContainsFunc(excludedMarkets, isMarket)
+}
+
 func (this *GetPackageHandler) GetPackage(w http.ResponseWriter, r *http.Request) {
   logging.Logger.Debug().Msgf("/packageManifests: Someone tried to GET package '%v' with query params: %v", r.PathValue("package_identifier"), r.URL.Query())
-  logging.Logger.Debug().Msgf("client requested API version %v", r.Header.Get("Version"))
+  apiVersion := negotiateApiVersion(r)
+  logging.Logger.Debug().Msgf("client requested API version %v, responding with %v", r.Header.Get("Version"), apiVersion)
 
+  // The envelope of this response is identical in all API versions, only
+  // the schema of the package versions in it differs between them.
   response := models.API_ManifestSingleResponse_1_1_0 {
     RequiredQueryParameters: []string{},
     UnsupportedQueryParameters: []string{},
     Data: nil,
   }
 
-  var pkg []models.API_ManifestVersionInterface = models.Manifests.GetAllVersions(r.PathValue("package_identifier"))
+  for parameter := range r.URL.Query() {
+    if !slices.ContainsFunc(supportedPackageManifestsQueryParameters, func(p string) bool { return strings.EqualFold(p, parameter) }) {
+      response.UnsupportedQueryParameters = append(response.UnsupportedQueryParameters, parameter)
+    }
+  }
+  versionFilter := getQueryParameter(r, "Version")
+  channelFilter := getQueryParameter(r, "Channel")
+  marketFilter := getQueryParameter(r, "Market")
+
+  // Converting the package versions to the negotiated API schema also makes a copy
+  // of them, so the InstallerUrls can be rewritten without modifying the stored data.
+  // Packages the client is not authorized for are treated as if they didn't exist
+  var versions []models.API_ManifestVersionInterface
+  if packageAllowed(r, r.PathValue("package_identifier")) {
+    versions = models.Manifests.GetAllVersions(r.PathValue("package_identifier"))
+  } else {
+    logging.Logger.Info().Str("package", r.PathValue("package_identifier")).Msg("client is not authorized for this package")
+  }
+
+  var pkg []models.API_ManifestVersionInterface
+  for _, version := range versions {
+    if versionFilter != "" && !strings.EqualFold(version.GetPackageVersion(), versionFilter) {
+      continue
+    }
+    if channelFilter != "" && !strings.EqualFold(version.GetChannel(), channelFilter) {
+      continue
+    }
+
+    converted, err := models.ConvertManifestVersion(version, apiVersion)
+    if err != nil {
+      logging.Logger.Error().Err(err).Str("package", r.PathValue("package_identifier")).Str("packageversion", version.GetPackageVersion()).Msg("cannot convert package version to the requested API version")
+      continue
+    }
+    if marketFilter != "" {
+      converted = converted.FilterInstallers(func(installer models.API_InstallerInterface) bool {
+        return availableInMarket(installer, marketFilter)
+      })
+    }
+    if len(converted.GetInstallers()) == 0 {
+      logging.Logger.Debug().Str("package", r.PathValue("package_identifier")).Str("packageversion", version.GetPackageVersion()).Msgf("no installers of this package version are available in market '%v' or supported by API version %v", marketFilter, apiVersion)
+      continue
+    }
+    pkg = append(pkg, converted)
+  }
 
   if this.InternalizationEnabled {
-      var rewrittenOrigin string
-      // TODO: Only accept these headers if c.RemoteIP() is a trusted proxy configured by the user
-      xfProto := r.Header.Values("X-Forwarded-Proto")
-      xfHost  := r.Header.Values("X-Forwarded-Host")
-
-      if len(xfProto) == 1 && xfProto[0] != "" && len(xfHost) == 1 && xfHost[0] != "" {
-          rewrittenOrigin = fmt.Sprintf(
-              "%s://%s", xfProto[0], xfHost[0],
-          )
-      } else {
-          var proto string
-          if this.TlsEnabled {
-              proto = "https"
-          } else {
-              proto = "http"
-          }
-          rewrittenOrigin = fmt.Sprintf(
-              "%s://%s", proto, r.Host,
-          )
-      }
+      // Clients have to be able to reach the installers under the same URL they reached rewinged
+      rewrittenOrigin := forwarded.BaseURL(r)
 
       // We cannot use a range loop over the installers here because range loops
       // always put the current element in the loop into the same one memory address.
@@ -71,7 +141,7 @@ func (this *GetPackageHandler) GetPackage(w http.ResponseWriter, r *http.Request
 
           for j := 0; j < len(installers); j++ {
               // Only rewrite this installers InstallerUrl if it was marked for it on ingest
-              if models.InternalizedInstallers[installers[j].GetInstallerSha()] {
+              if models.InternalizedInstallers.IsInternalized(installers[j].GetInstallerSha()) {
                   installers[j].SetInstallerUrl(
                       fmt.Sprintf(
                           "%s/installers/%s",
@@ -93,6 +163,8 @@ func (this *GetPackageHandler) GetPackage(w http.ResponseWriter, r *http.Request
                   //   2. We have to explicitly edit the metadata of internalized installers
                   //      to say they will require authentication to download to make winget CLI
                   //      pass credentials with the download request
+                  // There is no InstallerAuthentication for generic OIDC, winget cannot obtain such
+                  // tokens anyway, so in oidc mode clients have to send their token along by themselves.
                   if settings.SourceAuthenticationType == "microsoftEntraId" {
                       // Currently hardcoded to insert a v1.10.0 Authentication struct, but it doesn't matter as the
                       // struct itself hasn't changed since being introduced in API 1.7.0 and it's only allowed in
@@ -156,9 +228,52 @@ func SearchForPackage(w http.ResponseWriter, r *http.Request) {
   }
 
   logging.Logger.Debug().Msgf("%+v", post)
-  response := &models.API_ManifestSearchResult[models.API_ManifestSearchVersion_1_1_0]{
+
+  if post.Query.KeyWord != "" {
+    metrics.CountSearch("Query", post.Query.MatchType)
+  }
+  for _, filter := range slices.Concat(post.Inclusions, post.Filters) {
+    metrics.CountSearch(filter.PackageMatchField, filter.RequestMatch.MatchType)
+  }
+
+  // API 1.4.0 added properties to the versions in search results, the schema of which
+  // has not changed since. Older clients, and like before the negotiation, clients that
+  // don't send a Version header, get the original 1.1.0 search result schema.
+  if apiVersion := negotiateApiVersion(r); apiVersion == "" || apiVersion == "1.1.0" {
+    writeSearchResponse(w, r, post, func(version models.API_ManifestVersionInterface) models.API_ManifestSearchVersion_1_1_0 {
+      return models.API_ManifestSearchVersion_1_1_0{
+        PackageVersion: version.GetPackageVersion(),
+        Channel: version.GetChannel(),
+        PackageFamilyNames: version.GetInstallerPackageFamilyNames(),
+        ProductCodes: version.GetInstallerProductCodes(),
+      }
+    })
+  } else {
+    writeSearchResponse(w, r, post, func(version models.API_ManifestVersionInterface) models.API_ManifestSearchVersion_1_4_0 {
+      return models.API_ManifestSearchVersion_1_4_0{
+        PackageVersion: version.GetPackageVersion(),
+        Channel: version.GetChannel(),
+        PackageFamilyNames: version.GetInstallerPackageFamilyNames(),
+        ProductCodes: version.GetInstallerProductCodes(),
+        AppsAndFeaturesEntryVersions: version.GetAppsAndFeaturesEntryVersions(),
+        UpgradeCodes: version.GetInstallerUpgradeCodes(),
+      }
+    })
+  }
+}
+
+// Runs the search requested by the client and writes the results, with every package
+// version converted to the search result schema MSVI by toSearchVersion.
+func writeSearchResponse[MSVI models.API_ManifestSearchVersionInterface](
+  w http.ResponseWriter,
+  r *http.Request,
+  post models.API_ManifestSearchRequest_1_1_0,
+  toSearchVersion func(models.API_ManifestVersionInterface) MSVI,
+) {
+  response := &models.API_ManifestSearchResult[MSVI]{
     RequiredPackageMatchFields: []string{},
-    Data: []models.API_ManifestSearchResponse[models.API_ManifestSearchVersion_1_1_0] {},
+    UnsupportedPackageMatchFields: []string{},
+    Data: []models.API_ManifestSearchResponse[MSVI] {},
   }
 
   // results is a map where the PackageIdentifier is the key
@@ -171,26 +286,41 @@ func SearchForPackage(w http.ResponseWriter, r *http.Request) {
     results = models.Manifests.GetByKeyword(post.Query.KeyWord)
   } else if (post.Inclusions != nil && len(post.Inclusions) > 0) || (post.Filters != nil && len(post.Filters) > 0) {
     logging.Logger.Debug().Msg("advanced search with inclusions[] and/or filters[]")
-    results = models.Manifests.GetByMatchFilter(post.Inclusions, post.Filters)
+    results, response.UnsupportedPackageMatchFields = models.Manifests.GetByMatchFilter(post.Inclusions, post.Filters)
   }
 
+  maps.DeleteFunc(results, func(packageId string, _ []models.API_ManifestVersionInterface) bool {
+    return !packageAllowed(r, packageId)
+  })
+
   logging.Logger.Debug().Msgf("with %v results", len(results))
 
-  if len(results) > 0 {
-    for packageId, packageVersions := range results {
+  // Sort the packages so results can be paginated
+  packageIds := slices.Sorted(maps.Keys(results))
+  packageIds, continuationToken, err := paginate(
+    packageIds,
+    func(packageId string) string { return packageId },
+    getContinuationToken(r),
+    post.MaximumResults,
+  )
+  if err != nil {
+    writeInvalidContinuationToken(w, err)
+    return
+  }
+  response.ContinuationToken = continuationToken
+
+  if len(packageIds) > 0 {
+    for _, packageId := range packageIds {
+      packageVersions := results[packageId]
       logging.Logger.Debug().Msgf("package %v with %v versions", packageId, len(packageVersions))
-      var versions []models.API_ManifestSearchVersion_1_1_0
+      var versions []MSVI
 
       for _, version := range packageVersions {
-        versions = append(versions, models.API_ManifestSearchVersion_1_1_0{
-          PackageVersion: version.GetPackageVersion(),
-          Channel: "",
-          PackageFamilyNames: []string{},
-          ProductCodes: version.GetInstallerProductCodes(),
-        })
+        versions = append(versions, toSearchVersion(version))
       }
 
-      response.Data = append(response.Data, models.API_ManifestSearchResponse[models.API_ManifestSearchVersion_1_1_0]{
+      // The versions are sorted newest first, so the name and publisher are those of the latest version
+      response.Data = append(response.Data, models.API_ManifestSearchResponse[MSVI]{
         PackageIdentifier: packageId,
         PackageName: packageVersions[0].GetDefaultLocalePackageName(),
         Publisher: packageVersions[0].GetDefaultLocalePublisher(),
@@ -210,3 +340,12 @@ func SearchForPackage(w http.ResponseWriter, r *http.Request) {
   }
 }
 
+func writeInvalidContinuationToken(w http.ResponseWriter, err error) {
+  logging.Logger.Debug().Err(err).Msg("client sent an invalid ContinuationToken")
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(http.StatusBadRequest)
+  json.NewEncoder(w).Encode(models.API_WingetApiError{
+    ErrorCode: 400,
+    ErrorMessage: err.Error(),
+  })
+}
@@ -0,0 +1,68 @@
+package controllers
+
+import (
+    "errors"
+    "net/http"
+    "encoding/base64"
+
+    "rewinged/settings"
+)
+
+var errInvalidContinuationToken = errors.New("the ContinuationToken is invalid")
+
+// Continuation tokens are opaque to clients, but internally they are just the encoded
+// PackageIdentifier of the last package on the previous page. Because all paginated
+// responses are sorted by PackageIdentifier, this keeps pages stable even when manifests
+// are added or removed by live reload in between requests.
+func encodeContinuationToken(lastPackageIdentifier string) string {
+    return base64.RawURLEncoding.EncodeToString([]byte(lastPackageIdentifier))
+}
+
+func decodeContinuationToken(token string) (string, error) {
+    lastPackageIdentifier, err := base64.RawURLEncoding.DecodeString(token)
+    if err != nil || len(lastPackageIdentifier) == 0 {
+        return "", errInvalidContinuationToken
+    }
+    return string(lastPackageIdentifier), nil
+}
+
+// The REST source specification passes the ContinuationToken as a query parameter to
+// GET /packages but as a header to POST /manifestSearch, accept it both ways everywhere.
+func getContinuationToken(r *http.Request) string {
+    if token := r.URL.Query().Get("ContinuationToken"); token != "" {
+        return token
+    }
+    return r.Header.Get("ContinuationToken")
+}
+
+// paginate returns the page of items following the package referenced by the continuation
+// token and the token for the next page, if there is one. items must be sorted by
+// PackageIdentifier. A maximumResults of zero or less means the client did not ask
+// for a limit, the server-side settings.MaximumPageSize always applies.
+func paginate[T any](items []T, packageIdentifier func(T) string, token string, maximumResults int) ([]T, string, error) {
+    start := 0
+    if token != "" {
+        lastPackageIdentifier, err := decodeContinuationToken(token)
+        if err != nil {
+            return nil, "", err
+        }
+        for start < len(items) && packageIdentifier(items[start]) <= lastPackageIdentifier {
+            start++
+        }
+    }
+
+    pageSize := settings.MaximumPageSize
+    if maximumResults > 0 && maximumResults < pageSize {
+        pageSize = maximumResults
+    }
+
+    end := min(start + pageSize, len(items))
+    page := items[start:end]
+
+    var nextToken string
+    if end < len(items) && len(page) > 0 {
+        nextToken = encodeContinuationToken(packageIdentifier(page[len(page)-1]))
+    }
+
+    return page, nextToken, nil
+}
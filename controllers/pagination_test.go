@@ -0,0 +1,152 @@
+package controllers
+
+import (
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "slices"
+    "testing"
+
+    "rewinged/settings"
+)
+
+func TestContinuationToken(t *testing.T) {
+    for _, packageIdentifier := range []string{"Microsoft.VisualStudioCode", "a", "Zoë.App", "Publisher.Package+?&/="} {
+        token := encodeContinuationToken(packageIdentifier)
+        if got, err := decodeContinuationToken(token); err != nil || got != packageIdentifier {
+            t.Errorf("decodeContinuationToken(%q) = %q, %v, want %q", token, got, err, packageIdentifier)
+        }
+    }
+
+    tests := []struct {
+        name string
+        token string
+    }{
+        {"empty", ""},
+        {"not base64", "not a token!"},
+        {"padded", encodeContinuationToken("Microsoft.Edge") + "="},
+        {"standard alphabet", "Pz8/"},
+        {"truncated", "T"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got, err := decodeContinuationToken(tt.token); !errors.Is(err, errInvalidContinuationToken) {
+                t.Errorf("decodeContinuationToken(%q) = %q, %v, want %v", tt.token, got, err, errInvalidContinuationToken)
+            }
+        })
+    }
+}
+
+func TestGetContinuationToken(t *testing.T) {
+    tests := []struct {
+        name string
+        query string
+        header string
+        want string
+    }{
+        {"none", "", "", ""},
+        {"query parameter", "abc", "", "abc"},
+        {"header", "", "def", "def"},
+        {"both", "abc", "def", "abc"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            r := httptest.NewRequest(http.MethodGet, "/packages", nil)
+            if tt.query != "" {
+                r.URL.RawQuery = "ContinuationToken=" + tt.query
+            }
+            if tt.header != "" {
+                r.Header.Set("ContinuationToken", tt.header)
+            }
+            if got := getContinuationToken(r); got != tt.want {
+                t.Errorf("got %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+// Follows the continuation tokens from the first to the last page and returns all pages
+func allPages(t *testing.T, items []string, maximumResults int) [][]string {
+    var pages [][]string
+    token := ""
+    for {
+        page, next, err := paginate(items, func(s string) string { return s }, token, maximumResults)
+        if err != nil {
+            t.Fatal(err)
+        }
+        pages = append(pages, page)
+        if next == "" {
+            return pages
+        }
+        if len(pages) > len(items) {
+            t.Fatalf("pagination does not end: %v", pages)
+        }
+        token = next
+    }
+}
+
+func TestPaginate(t *testing.T) {
+    defer func(pageSize int) { settings.MaximumPageSize = pageSize }(settings.MaximumPageSize)
+    settings.MaximumPageSize = 3
+
+    items := []string{"A.A", "B.B", "C.C", "D.D", "E.E", "F.F", "G.G"}
+    tests := []struct {
+        name string
+        items []string
+        maximumResults int
+        want [][]string
+    }{
+        {"server page size", items, 0, [][]string{{"A.A", "B.B", "C.C"}, {"D.D", "E.E", "F.F"}, {"G.G"}}},
+        {"client page size", items, 2, [][]string{{"A.A", "B.B"}, {"C.C", "D.D"}, {"E.E", "F.F"}, {"G.G"}}},
+        {"client page size above the server's", items, 10, [][]string{{"A.A", "B.B", "C.C"}, {"D.D", "E.E", "F.F"}, {"G.G"}}},
+        {"exactly one page", items[:3], 0, [][]string{{"A.A", "B.B", "C.C"}}},
+        {"no items", nil, 0, [][]string{{}}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := allPages(t, tt.items, tt.maximumResults)
+            if !slices.EqualFunc(got, tt.want, slices.Equal) {
+                t.Errorf("got %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
+
+// Packages added or removed between requests must neither be skipped nor repeated
+func TestPaginateStableAcrossChanges(t *testing.T) {
+    defer func(pageSize int) { settings.MaximumPageSize = pageSize }(settings.MaximumPageSize)
+    settings.MaximumPageSize = 2
+    identity := func(s string) string { return s }
+
+    _, token, err := paginate([]string{"A.A", "B.B", "C.C", "D.D", "E.E"}, identity, "", 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name string
+        items []string
+        want []string
+    }{
+        {"unchanged", []string{"A.A", "B.B", "C.C", "D.D", "E.E"}, []string{"C.C", "D.D"}},
+        {"last package of the page removed", []string{"A.A", "C.C", "D.D", "E.E"}, []string{"C.C", "D.D"}},
+        {"package added before the token", []string{"A.A", "AA.A", "B.B", "C.C", "D.D", "E.E"}, []string{"C.C", "D.D"}},
+        {"package added after the token", []string{"A.A", "B.B", "BB.B", "C.C", "D.D", "E.E"}, []string{"BB.B", "C.C"}},
+        {"all remaining packages removed", []string{"A.A", "B.B"}, []string{}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            page, _, err := paginate(tt.items, identity, token, 0)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !slices.Equal(page, tt.want) {
+                t.Errorf("got %v, want %v", page, tt.want)
+            }
+        })
+    }
+
+    if _, _, err := paginate([]string{"A.A"}, identity, "not a token!", 0); !errors.Is(err, errInvalidContinuationToken) {
+        t.Errorf("got %v for an invalid token, want %v", err, errInvalidContinuationToken)
+    }
+}
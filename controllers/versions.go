@@ -0,0 +1,50 @@
+package controllers
+
+import (
+    "strconv"
+    "strings"
+    "net/http"
+)
+
+// All API schema versions rewinged can respond with, oldest first.
+// New API schema versions have to be included here or winget CLI client won't pick
+// up the features / data fields from newer packages even if they are returned
+var supportedApiVersions = []string{"1.1.0", "1.4.0", "1.5.0", "1.6.0", "1.7.0", "1.9.0", "1.10.0"}
+
+// Returns the newest API version supported by rewinged that is not newer than the one the
+// client requested in the Version header, or an empty string if the client didn't send one.
+func negotiateApiVersion(r *http.Request) string {
+    requested := r.Header.Get("Version")
+    if requested == "" {
+        return ""
+    }
+
+    negotiated := supportedApiVersions[0]
+    for _, supported := range supportedApiVersions {
+        if compareApiVersions(supported, requested) <= 0 {
+            negotiated = supported
+        }
+    }
+    return negotiated
+}
+
+// Compares two dot-separated numeric versions, missing or non-numeric parts count as 0
+func compareApiVersions(a string, b string) int {
+    aParts, bParts := strings.Split(a, "."), strings.Split(b, ".")
+    for i := 0; i < max(len(aParts), len(bParts)); i++ {
+        var aNum, bNum int
+        if i < len(aParts) {
+            aNum, _ = strconv.Atoi(aParts[i])
+        }
+        if i < len(bParts) {
+            bNum, _ = strconv.Atoi(bParts[i])
+        }
+        if aNum != bNum {
+            if aNum < bNum {
+                return -1
+            }
+            return 1
+        }
+    }
+    return 0
+}
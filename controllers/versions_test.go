@@ -0,0 +1,80 @@
+package controllers
+
+import (
+    "strings"
+    "testing"
+    "net/http"
+    "net/http/httptest"
+    "encoding/json"
+
+    "rewinged/models"
+)
+
+func TestNegotiateApiVersion(t *testing.T) {
+    tests := []struct {
+        requested string
+        want string
+    }{
+        {"", ""},
+        {"1.1.0", "1.1.0"},
+        {"1.0.0", "1.1.0"},
+        {"1.4.0", "1.4.0"},
+        {"1.5", "1.5.0"},
+        {"1.8.0", "1.7.0"},
+        {"1.9.2", "1.9.0"},
+        {"1.10.0", "1.10.0"},
+        {"1.12.0", "1.10.0"},
+        {"2.0.0", "1.10.0"},
+        {"latest", "1.1.0"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.requested, func(t *testing.T) {
+            r := httptest.NewRequest(http.MethodGet, "/packageManifests/Contoso.App", nil)
+            if tt.requested != "" {
+                r.Header.Set("Version", tt.requested)
+            }
+            if got := negotiateApiVersion(r); got != tt.want {
+                t.Errorf("got %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+// Search results have the properties added in API 1.4.0 only if the client asks for it
+func TestSearchResponseSchema(t *testing.T) {
+    sourceFile := "/versions_test/Contoso.Schema.yaml"
+    models.Manifests.Set("Contoso.Schema", "1.0.0", models.API_ManifestVersion_1_10_0{PackageVersion: "1.0.0"}, sourceFile)
+    t.Cleanup(func() { models.Manifests.RemoveSourcesBelow("/versions_test") })
+
+    tests := []struct {
+        version string
+        want bool
+    }{
+        {"", false},
+        {"1.1.0", false},
+        {"1.4.0", true},
+        {"1.10.0", true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.version, func(t *testing.T) {
+            r := httptest.NewRequest(http.MethodPost, "/manifestSearch", strings.NewReader(`{"Inclusions": [{"PackageMatchField": "PackageIdentifier", "RequestMatch": {"KeyWord": "Contoso.Schema", "MatchType": "Exact"}}]}`))
+            if tt.version != "" {
+                r.Header.Set("Version", tt.version)
+            }
+            w := httptest.NewRecorder()
+            SearchForPackage(w, r)
+
+            var response struct {
+                Data []struct {
+                    Versions []map[string]any
+                }
+            }
+            if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || len(response.Data) != 1 || len(response.Data[0].Versions) != 1 {
+                t.Fatalf("got %v: %s", err, w.Body.Bytes())
+            }
+            if _, got := response.Data[0].Versions[0]["UpgradeCodes"]; got != tt.want {
+                t.Errorf("got UpgradeCodes in the search result: %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+  "os"
+  "io"
+  "fmt"
+  "sync"
+  "time"
+  "errors"
+  "slices"
+  "context"
+  "strings"
+  "net/http"
+  "path/filepath"
+  "encoding/hex"
+  "crypto/sha256"
+
+  "rewinged/logging"
+  "rewinged/metrics"
+  "rewinged/models"
+)
+
+// Retries of failed installer downloads back off exponentially between these bounds
+var (
+  downloadMaxAttempts = 5
+  downloadInitialBackoff = 5 * time.Second
+  downloadMaxBackoff = 5 * time.Minute
+)
+
+var errInstallerShaMismatch = errors.New("downloaded file does not match the InstallerSha256 of the manifest")
+var errUnexpectedRange = errors.New("server returned an unexpected range of the file")
+
+// Errors that won't go away by trying to download the installer again
+type permanentError struct {
+  error
+}
+
+func (e permanentError) Unwrap() error {
+  return e.error
+}
+
+type httpStatusError struct {
+  StatusCode int
+}
+
+func (e httpStatusError) Error() string {
+  return fmt.Sprintf("http status %d", e.StatusCode)
+}
+
+// Only server errors and rate limiting are likely to go away by trying again
+func (e httpStatusError) retryable() bool {
+  return e.StatusCode >= 500 || e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests
+}
+
+type installerDownload struct {
+  packageIdentifier string
+  packageVersion string
+  installerURL string
+  installerSha256 string
+  destFile string
+}
+
+// The downloadManager downloads installers for auto-internalization with a fixed number
+// of background workers, so a slow or unreachable host never holds up the ingestion of
+// manifests. Package versions are available right away and keep pointing to the original
+// InstallerUrl until their installer has been downloaded and verified.
+type downloadManager struct {
+  client *http.Client
+  stallTimeout time.Duration
+  concurrency int
+  // Ingests a manifest directory again, for the package versions that were loaded before
+  // the metadata of their installer was known
+  reingest func(dir string)
+
+  // Cancelled to abort all downloads when shutting down
+  ctx context.Context
+  cancel context.CancelFunc
+  workers sync.WaitGroup
+
+  mu sync.Mutex
+  cond *sync.Cond
+  queue []installerDownload
+  stopped bool
+  // InstallerSha256s (lowercase) that are queued or being downloaded right now
+  pending map[string]bool
+}
+
+func newDownloadManager(concurrency int, stallTimeout time.Duration, reingest func(dir string)) *downloadManager {
+  // No overall timeout because installers can be huge, instead downloads are aborted
+  // when the server takes too long to respond or no data arrives for too long.
+  transport := http.DefaultTransport.(*http.Transport).Clone()
+  transport.ResponseHeaderTimeout = stallTimeout
+
+  dm := &downloadManager{
+    client: &http.Client{Transport: transport},
+    stallTimeout: stallTimeout,
+    concurrency: concurrency,
+    reingest: reingest,
+    pending: make(map[string]bool),
+  }
+  dm.ctx, dm.cancel = context.WithCancel(context.Background())
+  dm.cond = sync.NewCond(&dm.mu)
+  return dm
+}
+
+// Starts downloading the queued installers, until then they are only queued.
+// Like all methods that may be called when auto-internalization is disabled,
+// it is safe to call on a nil downloadManager.
+func (dm *downloadManager) start() {
+  if dm == nil {
+    return
+  }
+  for w := 1; w <= dm.concurrency; w++ {
+    dm.workers.Add(1)
+    go dm.worker()
+  }
+}
+
+// Aborts all running downloads and waits for the workers to exit. Incomplete downloads
+// are kept as partial files, so they are resumed when rewinged is started again.
+func (dm *downloadManager) stop() {
+  if dm == nil {
+    return
+  }
+  dm.mu.Lock()
+  dm.stopped = true
+  dm.cond.Broadcast()
+  dm.mu.Unlock()
+  dm.cancel()
+  dm.workers.Wait()
+}
+
+// Queues an installer for download unless the same installer is already queued
+func (dm *downloadManager) enqueue(d installerDownload) {
+  dm.mu.Lock()
+  defer dm.mu.Unlock()
+  key := strings.ToLower(d.installerSha256)
+  if dm.pending[key] {
+    logging.Logger.Debug().Str("package", d.packageIdentifier).Str("packageversion", d.packageVersion).Msgf("installer is already queued for download")
+    return
+  }
+  dm.pending[key] = true
+  dm.queue = append(dm.queue, d)
+  dm.cond.Signal()
+  logging.Logger.Debug().Str("package", d.packageIdentifier).Str("packageversion", d.packageVersion).Msgf("queued installer download, %d in queue", len(dm.queue))
+}
+
+// Reports whether an installer is queued or being downloaded right now
+func (dm *downloadManager) isPending(installerSha256 string) bool {
+  if dm == nil {
+    return false
+  }
+  dm.mu.Lock()
+  defer dm.mu.Unlock()
+  return dm.pending[strings.ToLower(installerSha256)]
+}
+
+// Returns the next queued download, or false once the downloadManager is stopped
+func (dm *downloadManager) next() (installerDownload, bool) {
+  dm.mu.Lock()
+  defer dm.mu.Unlock()
+  for len(dm.queue) == 0 && !dm.stopped {
+    dm.cond.Wait()
+  }
+  if dm.stopped {
+    return installerDownload{}, false
+  }
+  d := dm.queue[0]
+  dm.queue = dm.queue[1:]
+  return d, true
+}
+
+func (dm *downloadManager) done(d installerDownload) {
+  dm.mu.Lock()
+  defer dm.mu.Unlock()
+  delete(dm.pending, strings.ToLower(d.installerSha256))
+}
+
+func (dm *downloadManager) worker() {
+  defer dm.workers.Done()
+  for {
+    d, ok := dm.next()
+    if !ok {
+      return
+    }
+    dm.download(d)
+    dm.done(d)
+  }
+}
+
+func (dm *downloadManager) download(d installerDownload) {
+  backoff := downloadInitialBackoff
+  for attempt := 1; ; attempt++ {
+    logging.Logger.Debug().Str("package", d.packageIdentifier).Str("packageversion", d.packageVersion).Msgf("downloading installer (attempt %d)", attempt)
+    n, err := dm.tryDownload(d)
+    if dm.ctx.Err() != nil {
+      logging.Logger.Debug().Str("package", d.packageIdentifier).Str("packageversion", d.packageVersion).Msg("installer download aborted by shutdown, it is resumed on the next start")
+      return
+    }
+    switch {
+    case err == nil:
+      metrics.InstallerDownloads.WithLabelValues(metrics.DownloadSucceeded).Inc()
+    case errors.Is(err, errInstallerShaMismatch):
+      metrics.InstallerDownloads.WithLabelValues(metrics.DownloadRejected).Inc()
+    default:
+      metrics.InstallerDownloads.WithLabelValues(metrics.DownloadFailed).Inc()
+    }
+    if err == nil {
+      logging.Logger.Debug().Str("package", d.packageIdentifier).Str("packageversion", d.packageVersion).Msgf("downloaded installer, %d bytes written", n)
+      // Remember that this installer was internalized successfully so we know we can rewrite its InstallerUrl
+      installer := withMetadata(models.InternalizedInstaller{
+        InstallerSha256: d.installerSha256,
+        Path: d.destFile,
+        Size: n,
+        SourceURL: d.installerURL,
+        DownloadTime: time.Now(),
+      })
+      models.InternalizedInstallers.Set(installer)
+      if installer.Metadata != nil {
+        dm.reingestReferences(installer.InstallerSha256)
+      }
+      return
+    }
+
+    var permanent permanentError
+    if errors.As(err, &permanent) || attempt == downloadMaxAttempts {
+      // Keep what was downloaded so far only if another attempt could resume from it
+      if errors.As(err, &permanent) {
+        os.Remove(partFileOf(d.destFile))
+      }
+      logging.Logger.Error().Err(err).Str("package", d.packageIdentifier).Str("packageversion", d.packageVersion).Msgf("cannot internalize installer %s", d.installerURL)
+      return
+    }
+
+    logging.Logger.Warn().Err(err).Str("package", d.packageIdentifier).Str("packageversion", d.packageVersion).Msgf("installer download failed, retrying in %v", backoff)
+    select {
+    case <-time.After(backoff):
+    case <-dm.ctx.Done():
+      return
+    }
+    backoff = min(backoff * 2, downloadMaxBackoff)
+  }
+}
+
+// Ingests the manifests of all package versions with this installer again, so that the
+// metadata of the installer is filled in where their manifests leave it out
+func (dm *downloadManager) reingestReferences(installerSha256 string) {
+  var dirs []string
+  for _, key := range models.Manifests.GetPackageVersionsByInstallerSha(installerSha256) {
+    for _, file := range models.Manifests.GetSourceFilesOf(key.PackageIdentifier, key.PackageVersion) {
+      if !slices.Contains(dirs, filepath.Dir(file)) {
+        dirs = append(dirs, filepath.Dir(file))
+      }
+    }
+  }
+  for _, dir := range dirs {
+    logging.Logger.Debug().Str("directory", dir).Msgf("reloading manifests with the metadata of installer %v", installerSha256)
+    dm.reingest(dir)
+  }
+}
+
+// stallReader cancels a download through its timer when no data was read for too long
+type stallReader struct {
+  r io.Reader
+  timer *time.Timer
+  timeout time.Duration
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+  n, err := s.r.Read(p)
+  if n > 0 {
+    s.timer.Reset(s.timeout)
+  }
+  return n, err
+}
+
+// Incomplete downloads are hidden files next to where the installer will be stored
+func partFileOf(destFile string) string {
+  return filepath.Join(filepath.Dir(destFile), "." + filepath.Base(destFile) + ".part")
+}
+
+// Downloads an installer into a partial file next to destFile, hashing it on the way, and
+// only moves it to destFile if it matches the InstallerSha256. If a previous attempt left a
+// partial file behind, the download is resumed from where it stopped with an HTTP Range
+// request. Mismatching downloads are deleted so they can never be served under a hash they
+// don't have.
+func (dm *downloadManager) tryDownload(d installerDownload) (int64, error) {
+  partFile := partFileOf(d.destFile)
+  out, err := os.OpenFile(partFile, os.O_RDWR | os.O_CREATE, 0644)
+  if err != nil {
+    return 0, err
+  }
+  defer out.Close()
+
+  // Hashing the partial file also leaves the write offset at its end
+  hash := sha256.New()
+  offset, err := io.Copy(hash, out)
+  if err != nil {
+    return 0, err
+  }
+
+  ctx, cancel := context.WithCancel(dm.ctx)
+  defer cancel()
+  req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.installerURL, nil)
+  if err != nil {
+    return 0, err
+  }
+  if offset > 0 {
+    req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+  }
+
+  resp, err := dm.client.Do(req)
+  if err != nil {
+    return 0, err
+  }
+  defer resp.Body.Close()
+
+  var body io.Reader = resp.Body
+  switch {
+  case offset > 0 && resp.StatusCode == http.StatusPartialContent && strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)):
+    logging.Logger.Debug().Str("package", d.packageIdentifier).Str("packageversion", d.packageVersion).Msgf("resuming installer download at %d bytes", offset)
+  case offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+    // The previous attempt got the whole file but failed before it could be moved into place
+    body = strings.NewReader("")
+  case resp.StatusCode >= 200 && resp.StatusCode < 300 && resp.StatusCode != http.StatusPartialContent:
+    // Either a new download, or the server doesn't support resuming so start over
+    if offset > 0 {
+      if _, err := out.Seek(0, io.SeekStart); err != nil {
+        return 0, err
+      }
+      if err := out.Truncate(0); err != nil {
+        return 0, err
+      }
+      hash.Reset()
+      offset = 0
+    }
+  case resp.StatusCode == http.StatusPartialContent:
+    return 0, errUnexpectedRange
+  default:
+    statusErr := httpStatusError{StatusCode: resp.StatusCode}
+    if !statusErr.retryable() {
+      return 0, permanentError{statusErr}
+    }
+    return 0, statusErr
+  }
+
+  timer := time.AfterFunc(dm.stallTimeout, cancel)
+  defer timer.Stop()
+  n, err := io.Copy(io.MultiWriter(out, hash), &stallReader{r: body, timer: timer, timeout: dm.stallTimeout})
+  metrics.InstallerDownloadBytes.Add(float64(n))
+  if err != nil {
+    // Keep the partial file so the next attempt can resume from it
+    if ctx.Err() != nil {
+      return offset + n, fmt.Errorf("no data received for %v: %w", dm.stallTimeout, err)
+    }
+    return offset + n, err
+  }
+  if err := out.Close(); err != nil {
+    return offset + n, err
+  }
+
+  if actualSha256 := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(actualSha256, d.installerSha256) {
+    os.Remove(partFile)
+    err := fmt.Errorf("%w: expected %s, got %s", errInstallerShaMismatch, strings.ToLower(d.installerSha256), actualSha256)
+    if offset > 0 {
+      // The upstream file may have changed since the partial file was written, so try
+      // once more from the beginning before concluding that the download is bad
+      return offset + n, err
+    }
+    return offset + n, permanentError{err}
+  }
+
+  return offset + n, os.Rename(partFile, d.destFile)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+  "os"
+  "time"
+  "bytes"
+  "errors"
+  "strings"
+  "testing"
+  "net/http"
+  "net/http/httptest"
+  "path/filepath"
+  "sync/atomic"
+
+  "rewinged/models"
+)
+
+const testInstallerContent = "MZ the installer of the Contoso app"
+
+// Serves testInstallerContent with support for Range requests
+func serveInstaller(w http.ResponseWriter, r *http.Request) {
+  http.ServeContent(w, r, "", time.Time{}, strings.NewReader(testInstallerContent))
+}
+
+// A download of the installer served by handler to a temporary directory, with a partial file
+// left behind by a previous attempt if partial isn't empty
+func newTestDownload(t *testing.T, handler http.HandlerFunc, partial string) (installerDownload, *[]string) {
+  var ranges []string
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    ranges = append(ranges, r.Header.Get("Range"))
+    handler(w, r)
+  }))
+  t.Cleanup(server.Close)
+
+  d := installerDownload{
+    packageIdentifier: "Contoso.App",
+    packageVersion: "1.0.0",
+    installerURL: server.URL + "/contoso.exe",
+    installerSha256: sha256Of(testInstallerContent),
+    destFile: filepath.Join(t.TempDir(), sha256Of(testInstallerContent)),
+  }
+  if partial != "" {
+    if err := os.WriteFile(partFileOf(d.destFile), []byte(partial), 0644); err != nil {
+      t.Fatal(err)
+    }
+  }
+  return d, &ranges
+}
+
+func TestTryDownload(t *testing.T) {
+  tests := []struct {
+    name string
+    handler http.HandlerFunc
+    partial string
+    wantRanges []string
+    // The error the download fails with, if it is permanent, and if the partial file is left behind
+    wantErr error
+    wantPermanent bool
+    wantPartial bool
+  }{
+    {"new download", serveInstaller, "", []string{""}, nil, false, false},
+    {"resumed download", serveInstaller, testInstallerContent[:10], []string{"bytes=10-"}, nil, false, false},
+    {"download complete but not moved into place", serveInstaller, testInstallerContent, []string{"bytes=35-"}, nil, false, false},
+    {
+      "server ignores the Range",
+      func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(testInstallerContent)) },
+      "something else entirely",
+      []string{"bytes=23-"},
+      nil, false, false,
+    },
+    {
+      "server returns another range",
+      func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Range", "bytes 0-34/35")
+        w.WriteHeader(http.StatusPartialContent)
+        w.Write([]byte(testInstallerContent))
+      },
+      testInstallerContent[:10],
+      []string{"bytes=10-"},
+      errUnexpectedRange, false, true,
+    },
+    // The upstream file may have changed since the partial file was written
+    {"hash mismatch after resuming", serveInstaller, "MZ the old installer", []string{"bytes=20-"}, errInstallerShaMismatch, false, false},
+    {
+      "hash mismatch of a new download",
+      func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("MZ another installer")) },
+      "",
+      []string{""},
+      errInstallerShaMismatch, true, false,
+    },
+
+    {"not found", http.NotFound, "", []string{""}, httpStatusError{http.StatusNotFound}, true, true},
+    {
+      "forbidden",
+      func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusForbidden) },
+      "", []string{""}, httpStatusError{http.StatusForbidden}, true, true,
+    },
+    {
+      "server error",
+      func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusServiceUnavailable) },
+      testInstallerContent[:10], []string{"bytes=10-"}, httpStatusError{http.StatusServiceUnavailable}, false, true,
+    },
+    {
+      "rate limited",
+      func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTooManyRequests) },
+      "", []string{""}, httpStatusError{http.StatusTooManyRequests}, false, true,
+    },
+    {
+      "request timeout",
+      func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusRequestTimeout) },
+      "", []string{""}, httpStatusError{http.StatusRequestTimeout}, false, true,
+    },
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      dm := newDownloadManager(1, time.Second, func(string) {})
+      d, ranges := newTestDownload(t, tt.handler, tt.partial)
+
+      _, err := dm.tryDownload(d)
+      if tt.wantErr == nil && err != nil {
+        t.Fatalf("got error %v", err)
+      }
+      if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+        t.Fatalf("got error %v, want %v", err, tt.wantErr)
+      }
+      var permanent permanentError
+      if errors.As(err, &permanent) != tt.wantPermanent {
+        t.Errorf("got error %v, want a permanent one: %v", err, tt.wantPermanent)
+      }
+      if strings.Join(*ranges, ",") != strings.Join(tt.wantRanges, ",") {
+        t.Errorf("got requests with Range %q, want %q", *ranges, tt.wantRanges)
+      }
+
+      data, destErr := os.ReadFile(d.destFile)
+      if err == nil && (destErr != nil || string(data) != testInstallerContent) {
+        t.Errorf("got installer file %q, %v", data, destErr)
+      }
+      if err != nil && destErr == nil {
+        t.Errorf("the installer file exists although the download failed")
+      }
+      if _, partErr := os.Stat(partFileOf(d.destFile)); (partErr == nil) != tt.wantPartial {
+        t.Errorf("got partial file error %v, want a partial file: %v", partErr, tt.wantPartial)
+      }
+    })
+  }
+}
+
+func TestDownload(t *testing.T) {
+  defer func(backoff time.Duration) { downloadInitialBackoff = backoff }(downloadInitialBackoff)
+  downloadInitialBackoff = time.Millisecond
+
+  tests := []struct {
+    name string
+    // The responses to the attempts, the last one is repeated
+    statuses []int
+    partial string
+    wantAttempts int32
+    wantInternalized bool
+    // Only kept if another attempt could resume from it
+    wantPartial bool
+  }{
+    {"first attempt", []int{http.StatusOK}, "", 1, true, false},
+    {"retried after server errors", []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK}, "", 3, true, false},
+    {"given up after too many server errors", []int{http.StatusInternalServerError}, testInstallerContent[:10], int32(downloadMaxAttempts), false, true},
+    {"not retried when not found", []int{http.StatusNotFound, http.StatusOK}, testInstallerContent[:10], 1, false, false},
+    {"started over after a hash mismatch of a resumed download", []int{http.StatusOK}, "MZ the old installer", 2, true, false},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      var attempts atomic.Int32
+      d, _ := newTestDownload(t, func(w http.ResponseWriter, r *http.Request) {
+        attempt := int(attempts.Add(1))
+        if status := tt.statuses[min(attempt, len(tt.statuses)) - 1]; status != http.StatusOK {
+          w.WriteHeader(status)
+          return
+        }
+        serveInstaller(w, r)
+      }, tt.partial)
+      t.Cleanup(func() { models.InternalizedInstallers.Remove(d.installerSha256) })
+
+      dm := newDownloadManager(1, time.Second, func(string) {})
+      dm.download(d)
+      if got := attempts.Load(); got != tt.wantAttempts {
+        t.Errorf("got %v attempts, want %v", got, tt.wantAttempts)
+      }
+      installer, ok := models.InternalizedInstallers.Get(d.installerSha256)
+      if ok != tt.wantInternalized {
+        t.Fatalf("got internalized %v, want %v", ok, tt.wantInternalized)
+      }
+      if ok && (installer.Path != d.destFile || installer.Size != int64(len(testInstallerContent)) || installer.SourceURL != d.installerURL) {
+        t.Errorf("got registry entry %+v", installer)
+      }
+      if data, err := os.ReadFile(d.destFile); ok && !bytes.Equal(data, []byte(testInstallerContent)) {
+        t.Errorf("got installer file %q, %v", data, err)
+      }
+      if _, err := os.Stat(partFileOf(d.destFile)); (err == nil) != tt.wantPartial {
+        t.Errorf("got partial file error %v, want a partial file: %v", err, tt.wantPartial)
+      }
+    })
+  }
+}
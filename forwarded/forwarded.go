@@ -0,0 +1,182 @@
+// Package forwarded recovers what the original request of a client looked like when
+// rewinged runs behind one or more reverse proxies. Forwarding headers are only ever
+// honored if they were set by one of the trustedProxies, any client could send them.
+package forwarded
+
+import (
+    "net"
+    "strings"
+    "net/http"
+    "net/netip"
+
+    "rewinged/settings"
+)
+
+// The original request of a client, before any reverse proxies it passed through
+type Request struct {
+    ClientIP string
+    Proto string // http or https
+    Host string
+}
+
+// Resolve determines the client IP, protocol and host of the original request. The
+// RFC 7239 Forwarded header takes precedence over X-Forwarded-For/-Proto/-Host and
+// X-Real-Ip. The chain of proxies is walked from the closest one outwards and stops at
+// the first address that isn't a trusted proxy, which is taken to be the client.
+func Resolve(r *http.Request) Request {
+    resolved := Request{
+        ClientIP: r.RemoteAddr,
+        Proto: "http",
+        Host: r.Host,
+    }
+    if r.TLS != nil {
+        resolved.Proto = "https"
+    }
+
+    peer, err := netip.ParseAddrPort(r.RemoteAddr)
+    if err != nil {
+        return resolved
+    }
+    resolved.ClientIP = peer.Addr().String()
+    if !isTrusted(peer.Addr().String()) {
+        return resolved
+    }
+
+    if forwarded := r.Header.Values("Forwarded"); len(forwarded) > 0 {
+        elements := parseForwarded(forwarded)
+        // Each proxy appends one element describing the request it received
+        for i := len(elements) - 1; i >= 0; i-- {
+            element := elements[i]
+            if element["proto"] != "" {
+                resolved.Proto = strings.ToLower(element["proto"])
+            }
+            if element["host"] != "" {
+                resolved.Host = element["host"]
+            }
+            if element["for"] == "" {
+                break
+            }
+            resolved.ClientIP = element["for"]
+            if !isTrusted(element["for"]) {
+                break
+            }
+        }
+        return resolved
+    }
+
+    if xff := splitList(r.Header.Values("X-Forwarded-For")); len(xff) > 0 {
+        for i := len(xff) - 1; i >= 0; i-- {
+            resolved.ClientIP = stripPort(xff[i])
+            if !isTrusted(resolved.ClientIP) {
+                break
+            }
+        }
+    } else if xrip := r.Header.Get("X-Real-Ip"); xrip != "" {
+        resolved.ClientIP = xrip
+    }
+    // Proxies that append to these instead of replacing them put the value they received
+    // last, anything before it could have been sent by the client
+    if xfp := splitList(r.Header.Values("X-Forwarded-Proto")); len(xfp) > 0 {
+        resolved.Proto = strings.ToLower(xfp[len(xfp) - 1])
+    }
+    if xfh := splitList(r.Header.Values("X-Forwarded-Host")); len(xfh) > 0 {
+        resolved.Host = xfh[len(xfh) - 1]
+    }
+    return resolved
+}
+
+// BaseURL returns the URL under which the client reached rewinged, without a trailing
+// slash. The publicBaseUrl setting, if set, always takes precedence.
+func BaseURL(r *http.Request) string {
+    if settings.PublicBaseURL != "" {
+        return strings.TrimSuffix(settings.PublicBaseURL, "/")
+    }
+    resolved := Resolve(r)
+    return resolved.Proto + "://" + resolved.Host
+}
+
+func isTrusted(ip string) bool {
+    addr, err := netip.ParseAddr(ip)
+    if err != nil {
+        return false
+    }
+    addr = addr.Unmap()
+    for _, proxy := range settings.TrustedProxies {
+        if proxy.Contains(addr) {
+            return true
+        }
+    }
+    return false
+}
+
+// Splits comma-separated header values, also across multiple headers of the same name
+func splitList(values []string) []string {
+    var list []string
+    for _, value := range values {
+        for _, item := range strings.Split(value, ",") {
+            if item = strings.TrimSpace(item); item != "" {
+                list = append(list, item)
+            }
+        }
+    }
+    return list
+}
+
+// Removes the port from addresses like 192.0.2.1:1234 or [2001:db8::1]:1234 and the
+// brackets from [2001:db8::1], as found in the Forwarded header and X-Forwarded-For
+func stripPort(address string) string {
+    if host, _, err := net.SplitHostPort(address); err == nil {
+        return host
+    }
+    return strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+}
+
+// Parses RFC 7239 Forwarded headers into one map of lowercase parameter names to
+// values per forwarded-element, e.g. for=192.0.2.60;proto=https, for="[2001:db8::1]"
+func parseForwarded(values []string) []map[string]string {
+    var elements []map[string]string
+    for _, value := range values {
+        for _, rawElement := range splitOutsideQuotes(value, ',') {
+            element := make(map[string]string)
+            for _, pair := range splitOutsideQuotes(rawElement, ';') {
+                name, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+                if !found {
+                    continue
+                }
+                val = strings.TrimSpace(val)
+                if len(val) >= 2 && strings.HasPrefix(val, `"`) && strings.HasSuffix(val, `"`) {
+                    val = strings.ReplaceAll(val[1:len(val) - 1], `\"`, `"`)
+                }
+                name = strings.ToLower(strings.TrimSpace(name))
+                if name == "for" {
+                    val = stripPort(val)
+                }
+                element[name] = val
+            }
+            elements = append(elements, element)
+        }
+    }
+    return elements
+}
+
+func splitOutsideQuotes(s string, separator rune) []string {
+    var parts []string
+    var current strings.Builder
+    quoted, escaped := false, false
+    for _, c := range s {
+        switch {
+        case escaped:
+            escaped = false
+        case c == '\\' && quoted:
+            escaped = true
+        case c == '"':
+            quoted = !quoted
+        case c == separator && !quoted:
+            parts = append(parts, current.String())
+            current.Reset()
+            continue
+        }
+        current.WriteRune(c)
+    }
+    return append(parts, current.String())
+}
@@ -0,0 +1,215 @@
+package forwarded
+
+import (
+    "crypto/tls"
+    "net/http"
+    "net/http/httptest"
+    "net/netip"
+    "reflect"
+    "testing"
+
+    "rewinged/settings"
+)
+
+func withTrustedProxies(t *testing.T, proxies ...string) {
+    previous := settings.TrustedProxies
+    t.Cleanup(func() { settings.TrustedProxies = previous })
+    settings.TrustedProxies = nil
+    for _, proxy := range proxies {
+        settings.TrustedProxies = append(settings.TrustedProxies, netip.MustParsePrefix(proxy))
+    }
+}
+
+func TestResolve(t *testing.T) {
+    withTrustedProxies(t, "10.0.0.0/8", "2001:db8:1::/48")
+
+    tests := []struct {
+        name string
+        remoteAddr string
+        tls bool
+        headers http.Header
+        want Request
+    }{
+        {
+            "direct",
+            "192.0.2.1:4711", false, nil,
+            Request{ClientIP: "192.0.2.1", Proto: "http", Host: "winget.internal"},
+        },
+        {
+            "direct over TLS",
+            "192.0.2.1:4711", true, nil,
+            Request{ClientIP: "192.0.2.1", Proto: "https", Host: "winget.internal"},
+        },
+        {
+            "headers from an untrusted peer are ignored",
+            "192.0.2.1:4711", false,
+            http.Header{"Forwarded": {"for=198.51.100.7;proto=https;host=winget.example.org"}, "X-Forwarded-For": {"198.51.100.7"}},
+            Request{ClientIP: "192.0.2.1", Proto: "http", Host: "winget.internal"},
+        },
+        {
+            "unparseable peer address",
+            "@", false,
+            http.Header{"Forwarded": {"for=198.51.100.7"}},
+            Request{ClientIP: "@", Proto: "http", Host: "winget.internal"},
+        },
+
+        {
+            "Forwarded from a trusted proxy",
+            "10.0.0.1:4711", false,
+            http.Header{"Forwarded": {"for=198.51.100.7;proto=https;host=winget.example.org"}},
+            Request{ClientIP: "198.51.100.7", Proto: "https", Host: "winget.example.org"},
+        },
+        {
+            "Forwarded through a chain of trusted proxies",
+            "10.0.0.1:4711", false,
+            http.Header{"Forwarded": {"for=198.51.100.7;proto=https;host=winget.example.org, for=10.0.0.2;proto=http"}},
+            Request{ClientIP: "198.51.100.7", Proto: "https", Host: "winget.example.org"},
+        },
+        {
+            "Forwarded spoofed by the client",
+            "10.0.0.1:4711", false,
+            http.Header{"Forwarded": {"for=10.0.0.99;host=evil.example.org, for=198.51.100.7;proto=https;host=winget.example.org"}},
+            Request{ClientIP: "198.51.100.7", Proto: "https", Host: "winget.example.org"},
+        },
+        {
+            "Forwarded in several headers",
+            "10.0.0.1:4711", false,
+            http.Header{"Forwarded": {"for=198.51.100.7;proto=https", "for=10.0.0.2"}},
+            Request{ClientIP: "198.51.100.7", Proto: "https", Host: "winget.internal"},
+        },
+        {
+            "Forwarded with quoted IPv6 addresses and ports",
+            "[2001:db8:1::1]:4711", false,
+            http.Header{"Forwarded": {`for="[2001:db8::7]:4711", for="[2001:db8:1::2]"`}},
+            Request{ClientIP: "2001:db8::7", Proto: "http", Host: "winget.internal"},
+        },
+        {
+            "Forwarded with uppercase names and protocol",
+            "10.0.0.1:4711", false,
+            http.Header{"Forwarded": {"For=198.51.100.7;PROTO=HTTPS;Host=winget.example.org"}},
+            Request{ClientIP: "198.51.100.7", Proto: "https", Host: "winget.example.org"},
+        },
+        {
+            "Forwarded with an obfuscated identifier",
+            "10.0.0.1:4711", false,
+            http.Header{"Forwarded": {"for=_hidden;proto=https"}},
+            Request{ClientIP: "_hidden", Proto: "https", Host: "winget.internal"},
+        },
+        {
+            "Forwarded without for",
+            "10.0.0.1:4711", false,
+            http.Header{"Forwarded": {"for=198.51.100.7, proto=https"}},
+            Request{ClientIP: "10.0.0.1", Proto: "https", Host: "winget.internal"},
+        },
+        {
+            "Forwarded from an IPv4-mapped trusted proxy",
+            "[::ffff:10.0.0.1]:4711", false,
+            http.Header{"Forwarded": {"for=198.51.100.7"}},
+            Request{ClientIP: "198.51.100.7", Proto: "http", Host: "winget.internal"},
+        },
+        {
+            "Forwarded takes precedence",
+            "10.0.0.1:4711", false,
+            http.Header{"Forwarded": {"for=198.51.100.7"}, "X-Forwarded-For": {"203.0.113.9"}, "X-Forwarded-Proto": {"https"}},
+            Request{ClientIP: "198.51.100.7", Proto: "http", Host: "winget.internal"},
+        },
+
+        {
+            "X-Forwarded-For through a chain of trusted proxies",
+            "10.0.0.1:4711", false,
+            http.Header{"X-Forwarded-For": {"203.0.113.9, 198.51.100.7, 10.0.0.3"}},
+            Request{ClientIP: "198.51.100.7", Proto: "http", Host: "winget.internal"},
+        },
+        {
+            "X-Forwarded-For in several headers with ports",
+            "10.0.0.1:4711", false,
+            http.Header{"X-Forwarded-For": {"198.51.100.7:4711", "[2001:db8:1::3]:443"}},
+            Request{ClientIP: "198.51.100.7", Proto: "http", Host: "winget.internal"},
+        },
+        {
+            "X-Forwarded-For only of trusted proxies",
+            "10.0.0.1:4711", false,
+            http.Header{"X-Forwarded-For": {"10.0.0.5, 10.0.0.3"}},
+            Request{ClientIP: "10.0.0.5", Proto: "http", Host: "winget.internal"},
+        },
+        {
+            "X-Forwarded-Proto and -Host appended to",
+            "10.0.0.1:4711", false,
+            http.Header{"X-Forwarded-Proto": {"http, HTTPS"}, "X-Forwarded-Host": {"evil.example.org", "winget.example.org"}},
+            Request{ClientIP: "10.0.0.1", Proto: "https", Host: "winget.example.org"},
+        },
+        {
+            "X-Real-Ip",
+            "10.0.0.1:4711", false,
+            http.Header{"X-Real-Ip": {"198.51.100.7"}},
+            Request{ClientIP: "198.51.100.7", Proto: "http", Host: "winget.internal"},
+        },
+        {
+            "X-Forwarded-For takes precedence over X-Real-Ip",
+            "10.0.0.1:4711", false,
+            http.Header{"X-Forwarded-For": {"198.51.100.7"}, "X-Real-Ip": {"203.0.113.9"}},
+            Request{ClientIP: "198.51.100.7", Proto: "http", Host: "winget.internal"},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            r := httptest.NewRequest(http.MethodGet, "/information", nil)
+            r.RemoteAddr = tt.remoteAddr
+            r.Host = "winget.internal"
+            if tt.tls {
+                r.TLS = &tls.ConnectionState{}
+            }
+            for name, values := range tt.headers {
+                r.Header[name] = values
+            }
+            if got := Resolve(r); got != tt.want {
+                t.Errorf("got %+v, want %+v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestParseForwarded(t *testing.T) {
+    tests := []struct {
+        name string
+        values []string
+        want []map[string]string
+    }{
+        {"single element", []string{"for=192.0.2.60;proto=http;by=203.0.113.43"}, []map[string]string{{"for": "192.0.2.60", "proto": "http", "by": "203.0.113.43"}}},
+        {"several elements", []string{"for=192.0.2.43, for=198.51.100.17"}, []map[string]string{{"for": "192.0.2.43"}, {"for": "198.51.100.17"}}},
+        {"whitespace", []string{" for = 192.0.2.43 ; proto = https "}, []map[string]string{{"for": "192.0.2.43", "proto": "https"}}},
+        {"separators in quotes", []string{`for=192.0.2.43;host="a.example.org,b;c", for=198.51.100.17`}, []map[string]string{{"for": "192.0.2.43", "host": "a.example.org,b;c"}, {"for": "198.51.100.17"}}},
+        {"escaped quotes", []string{`host="win\"get"`}, []map[string]string{{"host": `win"get`}}},
+        {"IPv6 with port", []string{`for="[2001:db8:cafe::17]:4711"`}, []map[string]string{{"for": "2001:db8:cafe::17"}}},
+        {"IPv4 with port", []string{`for="192.0.2.43:4711"`}, []map[string]string{{"for": "192.0.2.43"}}},
+        {"pair without value", []string{"for=192.0.2.43;secret"}, []map[string]string{{"for": "192.0.2.43"}}},
+        {"empty element", []string{"for=192.0.2.43,,"}, []map[string]string{{"for": "192.0.2.43"}, {}, {}}},
+        {"several headers", []string{"for=192.0.2.43", "for=198.51.100.17"}, []map[string]string{{"for": "192.0.2.43"}, {"for": "198.51.100.17"}}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := parseForwarded(tt.values); !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("got %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestBaseURL(t *testing.T) {
+    withTrustedProxies(t, "10.0.0.0/8")
+    r := httptest.NewRequest(http.MethodGet, "/information", nil)
+    r.RemoteAddr = "10.0.0.1:4711"
+    r.Host = "winget.internal"
+    r.Header.Set("Forwarded", "for=198.51.100.7;proto=https;host=winget.example.org")
+
+    if got, want := BaseURL(r), "https://winget.example.org"; got != want {
+        t.Errorf("got %q, want %q", got, want)
+    }
+
+    previous := settings.PublicBaseURL
+    t.Cleanup(func() { settings.PublicBaseURL = previous })
+    settings.PublicBaseURL = "https://packages.example.org/winget/"
+    if got, want := BaseURL(r), "https://packages.example.org/winget"; got != want {
+        t.Errorf("got %q with a publicBaseUrl, want %q", got, want)
+    }
+}
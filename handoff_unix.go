@@ -0,0 +1,149 @@
+//go:build !windows
+
+package main
+
+import (
+  "os"
+  "net"
+  "fmt"
+  "time"
+  "errors"
+  "slices"
+  "strconv"
+  "strings"
+  "syscall"
+  "os/signal"
+)
+
+// A listening socket is passed to rewinged the same way systemd socket activation does it,
+// as file descriptor 3 announced by LISTEN_FDS. When a running rewinged hands its socket over
+// to an upgraded process, it additionally tells the new process its PID so that the new
+// process can ask it to shut down once it has taken over.
+const (
+  listenFdsStart = 3
+  upgradeParentEnv = "REWINGED_UPGRADE_PARENT"
+)
+
+// Returns the listening socket rewinged was started with, or nil if there is none
+func inheritedListener() (net.Listener, error) {
+  fds := os.Getenv("LISTEN_FDS")
+  if fds == "" {
+    return nil, nil
+  }
+  // systemd sets LISTEN_PID, a rewinged handing over its socket doesn't know the PID in advance
+  if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+    return nil, nil
+  }
+  // Don't pass these on to any processes started later
+  os.Unsetenv("LISTEN_FDS")
+  os.Unsetenv("LISTEN_PID")
+  os.Unsetenv("LISTEN_FDNAMES")
+
+  if fds != "1" {
+    return nil, fmt.Errorf("expected exactly one inherited socket, got LISTEN_FDS=%v", fds)
+  }
+  f := os.NewFile(listenFdsStart, "inherited socket")
+  defer f.Close()
+  return net.FileListener(f)
+}
+
+// Returns the PID of the rewinged this process is taking over from, or 0 if it isn't
+func upgradeParent() int {
+  pid, _ := strconv.Atoi(os.Getenv(upgradeParentEnv))
+  os.Unsetenv(upgradeParentEnv)
+  // Only the process that started us can hand its socket over
+  if pid != os.Getppid() {
+    return 0
+  }
+  return pid
+}
+
+// Upgrades are triggered with SIGUSR2, the same signal nginx uses to upgrade its executable
+func notifyUpgradeSignals(c chan<- os.Signal) {
+  signal.Notify(c, syscall.SIGUSR2)
+}
+
+// Starts the current executable, which may have been replaced with a new version in the
+// meantime, as a new process with the same arguments and hands the listening socket to it
+func startUpgrade(listener net.Listener) (*os.Process, error) {
+  // The new process would take over and then stop this one, which ends the container
+  if os.Getpid() == 1 {
+    return nil, errors.New("rewinged runs as PID 1, start it through an init process like tini to upgrade it")
+  }
+  socket, ok := listener.(syscall.Conn)
+  if !ok {
+    return nil, errors.New("the listening socket cannot be handed over")
+  }
+  rawSocket, err := socket.SyscallConn()
+  if err != nil {
+    return nil, err
+  }
+
+  executable, err := os.Executable()
+  if err != nil {
+    return nil, err
+  }
+
+  env := slices.DeleteFunc(os.Environ(), func(v string) bool {
+    return strings.HasPrefix(v, "LISTEN_") || strings.HasPrefix(v, upgradeParentEnv + "=")
+  })
+  env = append(env, "LISTEN_FDS=1", fmt.Sprintf("%v=%v", upgradeParentEnv, os.Getpid()))
+
+  // Not os.StartProcess, it would switch the socket to blocking mode (see os.File.Fd) which
+  // is shared with our listener, so it could no longer be closed if the new process fails
+  var pid int
+  var forkErr error
+  err = rawSocket.Control(func(fd uintptr) {
+    pid, forkErr = syscall.ForkExec(executable, os.Args, &syscall.ProcAttr{
+      Env: env,
+      Files: []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd(), fd},
+    })
+  })
+  if err == nil {
+    err = forkErr
+  }
+  if err != nil {
+    return nil, err
+  }
+  return os.FindProcess(pid)
+}
+
+// Tells systemd (or another service manager implementing sd_notify) that this process is
+// now the main process of the service. Otherwise the service is considered stopped once the
+// previous rewinged exits, and with KillMode=control-group this process is killed with it.
+// Does nothing when rewinged was not started by a service manager listening for notifications.
+func notifyMainPID() error {
+  socket := os.Getenv("NOTIFY_SOCKET")
+  if socket == "" {
+    return nil
+  }
+  // Abstract socket addresses are announced with a leading @
+  if strings.HasPrefix(socket, "@") {
+    socket = "\x00" + socket[1:]
+  }
+  conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+  if err != nil {
+    return fmt.Errorf("notifying the service manager: %w", err)
+  }
+  defer conn.Close()
+  if _, err := conn.Write([]byte(fmt.Sprintf("MAINPID=%v", os.Getpid()))); err != nil {
+    return fmt.Errorf("notifying the service manager: %w", err)
+  }
+  return nil
+}
+
+// Asks the rewinged this process took over from to shut down and returns once it has exited
+func finishUpgrade(parent int) error {
+  if err := notifyMainPID(); err != nil {
+    // Stopping the parent now could take this process down with it
+    return err
+  }
+  if err := syscall.Kill(parent, syscall.SIGTERM); err != nil {
+    return err
+  }
+  // Once the parent has exited, this process is reparented
+  for os.Getppid() == parent {
+    time.Sleep(100 * time.Millisecond)
+  }
+  return nil
+}
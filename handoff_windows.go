@@ -0,0 +1,30 @@
+//go:build windows
+
+package main
+
+import (
+  "os"
+  "net"
+  "errors"
+)
+
+// Windows has no equivalent of inheriting file descriptors that works with net.Listener,
+// so rewinged always opens its own socket and cannot be upgraded without downtime there.
+
+func inheritedListener() (net.Listener, error) {
+  return nil, nil
+}
+
+func upgradeParent() int {
+  return 0
+}
+
+func notifyUpgradeSignals(c chan<- os.Signal) {}
+
+func startUpgrade(listener net.Listener) (*os.Process, error) {
+  return nil, errors.New("upgrading without downtime is not supported on Windows")
+}
+
+func finishUpgrade(parent int) error {
+  return nil
+}
@@ -0,0 +1,241 @@
+package installerinfo
+
+import (
+    "bytes"
+    "errors"
+    "io"
+    "unicode/utf16"
+    "encoding/binary"
+)
+
+// The first bytes of every OLE compound file, the container format of MSI files
+var compoundFileSignature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+var errCorruptCompoundFile = errors.New("corrupt compound file")
+
+const (
+    endOfChain = 0xFFFFFFFE
+    noStream = 0xFFFFFFFF
+    miniSectorSize = 64
+    directoryEntrySize = 128
+    // None of the streams that are read come anywhere near this, but a broken sector chain could
+    maxStreamSize = 64 << 20
+    streamObject = 2
+    rootStorageObject = 5
+)
+
+type directoryEntry struct {
+    name string
+    objectType byte
+    left, right, child uint32
+    startSector uint32
+    size uint64
+}
+
+// compoundFile reads streams from an OLE compound file as specified in [MS-CFB]. Installers
+// come from anywhere, so every sector number and size read from the file is checked before
+// it is used.
+type compoundFile struct {
+    r io.ReaderAt
+    sectorSize int64
+    sectors int64 // the number of sectors the file has room for
+    miniStreamCutoff uint64
+    fat []uint32
+    miniFAT []uint32
+    entries []directoryEntry
+    miniStream []byte
+}
+
+func openCompoundFile(r io.ReaderAt, size int64) (*compoundFile, error) {
+    header := make([]byte, 512)
+    if _, err := r.ReadAt(header, 0); err != nil {
+        return nil, err
+    }
+    if !bytes.Equal(header[:8], compoundFileSignature) {
+        return nil, errCorruptCompoundFile
+    }
+    sectorShift := binary.LittleEndian.Uint16(header[0x1E:])
+    if (sectorShift != 9 && sectorShift != 12) || binary.LittleEndian.Uint16(header[0x20:]) != 6 {
+        return nil, errCorruptCompoundFile
+    }
+
+    cf := &compoundFile{
+        r: r,
+        sectorSize: 1 << sectorShift,
+        miniStreamCutoff: uint64(binary.LittleEndian.Uint32(header[0x38:])),
+    }
+    // The header takes up the first sector, the last one may be cut short
+    cf.sectors = (size - 1) / cf.sectorSize
+
+    // The sectors of the FAT are listed in the header and, for large files, in a chain of DIFAT sectors
+    fatSectors := int64(binary.LittleEndian.Uint32(header[0x2C:]))
+    if fatSectors > cf.sectors {
+        return nil, errCorruptCompoundFile
+    }
+    var fatSectorList []uint32
+    for i := 0; i < 109 && int64(len(fatSectorList)) < fatSectors; i++ {
+        fatSectorList = append(fatSectorList, binary.LittleEndian.Uint32(header[0x4C + i * 4:]))
+    }
+    difatSector := binary.LittleEndian.Uint32(header[0x44:])
+    for visited := int64(0); int64(len(fatSectorList)) < fatSectors; visited++ {
+        if visited >= cf.sectors {
+            return nil, errCorruptCompoundFile
+        }
+        sector, err := cf.readSector(difatSector)
+        if err != nil {
+            return nil, err
+        }
+        entries := len(sector) / 4 - 1
+        for i := 0; i < entries && int64(len(fatSectorList)) < fatSectors; i++ {
+            fatSectorList = append(fatSectorList, binary.LittleEndian.Uint32(sector[i * 4:]))
+        }
+        difatSector = binary.LittleEndian.Uint32(sector[entries * 4:])
+    }
+    for _, s := range fatSectorList {
+        sector, err := cf.readSector(s)
+        if err != nil {
+            return nil, err
+        }
+        cf.fat = appendUint32s(cf.fat, sector)
+    }
+
+    directory, err := cf.readChain(binary.LittleEndian.Uint32(header[0x30:]), cf.fat, cf.readSector)
+    if err != nil {
+        return nil, err
+    }
+    for offset := 0; offset + directoryEntrySize <= len(directory); offset += directoryEntrySize {
+        entry := parseDirectoryEntry(directory[offset:offset + directoryEntrySize])
+        // Version 3 files only use the lower half of the size, some writers leave garbage in the other
+        if cf.sectorSize == 512 {
+            entry.size &= 0xFFFFFFFF
+        }
+        cf.entries = append(cf.entries, entry)
+    }
+    if len(cf.entries) == 0 || cf.entries[0].objectType != rootStorageObject {
+        return nil, errCorruptCompoundFile
+    }
+
+    miniFAT, err := cf.readChain(binary.LittleEndian.Uint32(header[0x3C:]), cf.fat, cf.readSector)
+    if err != nil {
+        return nil, err
+    }
+    cf.miniFAT = appendUint32s(nil, miniFAT)
+
+    // Small streams are stored in the mini stream, which is the stream of the root entry
+    root := cf.entries[0]
+    cf.miniStream, err = cf.readChain(root.startSector, cf.fat, cf.readSector)
+    if err != nil {
+        return nil, err
+    }
+    if root.size > uint64(len(cf.miniStream)) {
+        return nil, errCorruptCompoundFile
+    }
+    cf.miniStream = cf.miniStream[:root.size]
+
+    return cf, nil
+}
+
+func parseDirectoryEntry(data []byte) directoryEntry {
+    nameLength := int(binary.LittleEndian.Uint16(data[0x40:]))
+    nameLength = min(max(nameLength - 2, 0), 62) / 2 // in UTF-16 code units, without the terminating null
+    name := make([]uint16, nameLength)
+    for i := range name {
+        name[i] = binary.LittleEndian.Uint16(data[i * 2:])
+    }
+    return directoryEntry{
+        name: string(utf16.Decode(name)),
+        objectType: data[0x42],
+        left: binary.LittleEndian.Uint32(data[0x44:]),
+        right: binary.LittleEndian.Uint32(data[0x48:]),
+        child: binary.LittleEndian.Uint32(data[0x4C:]),
+        startSector: binary.LittleEndian.Uint32(data[0x74:]),
+        size: binary.LittleEndian.Uint64(data[0x78:]),
+    }
+}
+
+func appendUint32s(values []uint32, data []byte) []uint32 {
+    for i := 0; i + 4 <= len(data); i += 4 {
+        values = append(values, binary.LittleEndian.Uint32(data[i:]))
+    }
+    return values
+}
+
+func (cf *compoundFile) readSector(sector uint32) ([]byte, error) {
+    if int64(sector) >= cf.sectors {
+        return nil, errCorruptCompoundFile
+    }
+    data := make([]byte, cf.sectorSize)
+    // The header takes up the space of sector -1
+    if _, err := cf.r.ReadAt(data, (int64(sector) + 1) * cf.sectorSize); err != nil && !errors.Is(err, io.EOF) {
+        return nil, err
+    }
+    return data, nil
+}
+
+func (cf *compoundFile) readMiniSector(sector uint32) ([]byte, error) {
+    offset := int64(sector) * miniSectorSize
+    if offset + miniSectorSize > int64(len(cf.miniStream)) {
+        return nil, errCorruptCompoundFile
+    }
+    return cf.miniStream[offset:offset + miniSectorSize], nil
+}
+
+// Reads the sectors of a chain in an allocation table one after the other
+func (cf *compoundFile) readChain(start uint32, table []uint32, read func(uint32) ([]byte, error)) ([]byte, error) {
+    var data []byte
+    for sector := start; sector != endOfChain; sector = table[sector] {
+        if int(sector) >= len(table) || len(data) >= maxStreamSize {
+            return nil, errCorruptCompoundFile
+        }
+        b, err := read(sector)
+        if err != nil {
+            return nil, err
+        }
+        data = append(data, b...)
+    }
+    return data, nil
+}
+
+func (cf *compoundFile) readStream(entry directoryEntry) ([]byte, error) {
+    if entry.size == 0 {
+        return nil, nil
+    } else if entry.size > maxStreamSize {
+        return nil, errCorruptCompoundFile
+    }
+    var data []byte
+    var err error
+    if entry.size < cf.miniStreamCutoff {
+        data, err = cf.readChain(entry.startSector, cf.miniFAT, cf.readMiniSector)
+    } else {
+        data, err = cf.readChain(entry.startSector, cf.fat, cf.readSector)
+    }
+    if err != nil {
+        return nil, err
+    }
+    if entry.size > uint64(len(data)) {
+        return nil, errCorruptCompoundFile
+    }
+    return data[:entry.size], nil
+}
+
+// Returns the streams stored directly in the root storage by their names. The entries of a
+// storage form a tree, which is walked without recursion so broken files can't exhaust the stack.
+func (cf *compoundFile) rootStreams() map[string]directoryEntry {
+    streams := make(map[string]directoryEntry)
+    visited := make(map[uint32]bool)
+    pending := []uint32{cf.entries[0].child}
+    for len(pending) > 0 {
+        id := pending[len(pending) - 1]
+        pending = pending[:len(pending) - 1]
+        if id == noStream || int(id) >= len(cf.entries) || visited[id] {
+            continue
+        }
+        visited[id] = true
+        entry := cf.entries[id]
+        if entry.objectType == streamObject {
+            streams[entry.name] = entry
+        }
+        pending = append(pending, entry.left, entry.right)
+    }
+    return streams
+}
@@ -0,0 +1,59 @@
+// Package installerinfo extracts the metadata winget uses to correlate installed programs with
+// packages from installer files, so that manifests can be checked or completed with it.
+package installerinfo
+
+import (
+    "bytes"
+    "errors"
+    "os"
+)
+
+// ErrUnsupportedInstaller is returned for installers of a type metadata cannot be extracted from
+var ErrUnsupportedInstaller = errors.New("unsupported installer type")
+
+// The metadata of an installer, empty where the installer doesn't define it
+type Metadata struct {
+    ProductCode string
+    UpgradeCode string
+    ProductVersion string
+    Manufacturer string
+    ProductName string
+    PackageFamilyName string
+    SignatureSha256 string
+}
+
+// ReadFile extracts the metadata of an installer file. The type of installer is detected from
+// its content; MSI installers and MSIX or APPX packages and bundles are supported.
+func ReadFile(path string) (Metadata, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return Metadata{}, err
+    }
+    defer f.Close()
+    info, err := f.Stat()
+    if err != nil {
+        return Metadata{}, err
+    }
+
+    signature := make([]byte, len(compoundFileSignature))
+    if _, err := f.ReadAt(signature, 0); err != nil {
+        return Metadata{}, ErrUnsupportedInstaller
+    }
+    if bytes.HasPrefix(signature, zipSignature) {
+        return readMSIX(f, info.Size())
+    }
+    if !bytes.Equal(signature, compoundFileSignature) {
+        return Metadata{}, ErrUnsupportedInstaller
+    }
+    properties, err := readMSIProperties(f, info.Size())
+    if err != nil {
+        return Metadata{}, err
+    }
+    return Metadata{
+        ProductCode: properties["ProductCode"],
+        UpgradeCode: properties["UpgradeCode"],
+        ProductVersion: properties["ProductVersion"],
+        Manufacturer: properties["Manufacturer"],
+        ProductName: properties["ProductName"],
+    }, nil
+}
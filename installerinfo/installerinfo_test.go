@@ -0,0 +1,126 @@
+package installerinfo
+
+import (
+    "archive/zip"
+    "bytes"
+    "errors"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func buildZip(t *testing.T, files map[string]string) []byte {
+    var buffer bytes.Buffer
+    archive := zip.NewWriter(&buffer)
+    for name, content := range files {
+        f, err := archive.Create(name)
+        if err != nil {
+            t.Fatal(err)
+        }
+        f.Write([]byte(content))
+    }
+    if err := archive.Close(); err != nil {
+        t.Fatal(err)
+    }
+    return buffer.Bytes()
+}
+
+const terminalManifest = `<?xml version="1.0" encoding="utf-8"?>
+<Package xmlns="http://schemas.microsoft.com/appx/manifest/foundation/windows10">
+  <Identity Name="Microsoft.WindowsTerminal" Publisher="CN=Microsoft Corporation, O=Microsoft Corporation, L=Redmond, S=Washington, C=US" Version="1.21.2361.0" />
+</Package>`
+
+func TestReadFile(t *testing.T) {
+    msi := buildCompoundFile(msiStreams(testProperties, 1252, false))
+    tests := []struct {
+        name string
+        content []byte
+        want Metadata
+        wantErr error
+    }{
+        {
+            "MSI",
+            msi,
+            Metadata{
+                ProductCode: "{11111111-2222-3333-4444-555555555555}",
+                UpgradeCode: "{AAAAAAAA-BBBB-CCCC-DDDD-EEEEEEEEEEEE}",
+                ProductVersion: "2.1.0.5",
+                Manufacturer: "Contosö Ltd",
+                ProductName: "Contoso App",
+            },
+            nil,
+        },
+        {
+            "MSI without any of the properties",
+            buildCompoundFile(msiStreams([][2]string{{"ALLUSERS", "1"}}, 1252, false)),
+            Metadata{},
+            nil,
+        },
+        {
+            "corrupt MSI",
+            msi[:1024],
+            Metadata{},
+            errCorruptCompoundFile,
+        },
+        {
+            "unsigned MSIX",
+            buildZip(t, map[string]string{"AppxManifest.xml": terminalManifest}),
+            Metadata{PackageFamilyName: "Microsoft.WindowsTerminal_8wekyb3d8bbwe"},
+            nil,
+        },
+        {
+            "signed MSIX bundle",
+            buildZip(t, map[string]string{"AppxMetadata/AppxBundleManifest.xml": terminalManifest, "AppxSignature.p7x": "signature"}),
+            Metadata{
+                PackageFamilyName: "Microsoft.WindowsTerminal_8wekyb3d8bbwe",
+                // SHA256 of "signature"
+                SignatureSha256: "1A2FC26DC7EA5A2A4748B7CB2B1EF193D96AB2C99F93092F69E63075B28D1278",
+            },
+            nil,
+        },
+        {
+            "MSIX without an identity",
+            buildZip(t, map[string]string{"AppxManifest.xml": `<Package><Properties /></Package>`}),
+            Metadata{},
+            errCorruptMSIX,
+        },
+        {
+            "other ZIP",
+            buildZip(t, map[string]string{"setup.exe": "MZ"}),
+            Metadata{},
+            ErrUnsupportedInstaller,
+        },
+        {
+            "EXE",
+            append([]byte("MZ"), make([]byte, 1000)...),
+            Metadata{},
+            ErrUnsupportedInstaller,
+        },
+        {
+            "shorter than any signature",
+            []byte("MZ"),
+            Metadata{},
+            ErrUnsupportedInstaller,
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            path := filepath.Join(t.TempDir(), "installer")
+            if err := os.WriteFile(path, tt.content, 0644); err != nil {
+                t.Fatal(err)
+            }
+            got, err := ReadFile(path)
+            if !errors.Is(err, tt.wantErr) {
+                t.Fatalf("got error %v, want %v", err, tt.wantErr)
+            }
+            if got != tt.want {
+                t.Errorf("got %+v, want %+v", got, tt.want)
+            }
+        })
+    }
+
+    if _, err := ReadFile(filepath.Join(t.TempDir(), "missing")); !errors.Is(err, fs.ErrNotExist) {
+        t.Errorf("got error %v for a missing file, want %v", err, fs.ErrNotExist)
+    }
+}
@@ -0,0 +1,134 @@
+package installerinfo
+
+import (
+    "errors"
+    "io"
+    "strings"
+    "unicode/utf8"
+    "encoding/binary"
+)
+
+var errCorruptMSI = errors.New("corrupt MSI database")
+
+// The names of streams in an MSI database are compressed by packing two characters of the
+// alphabet 0-9A-Za-z._ into one UTF-16 code unit. The streams of tables are prefixed with 0x4840.
+func decodeStreamName(name string) string {
+    const alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz._"
+    var decoded strings.Builder
+    for _, r := range strings.TrimPrefix(name, "\u4840") {
+        switch {
+        case r >= 0x3800 && r < 0x4800:
+            r -= 0x3800
+            decoded.WriteByte(alphabet[r & 0x3F])
+            decoded.WriteByte(alphabet[(r >> 6) & 0x3F])
+        case r >= 0x4800 && r < 0x4840:
+            decoded.WriteByte(alphabet[r - 0x4800])
+        default:
+            decoded.WriteRune(r)
+        }
+    }
+    return decoded.String()
+}
+
+// Reads the strings of an MSI database, which are all stored in one string pool and referenced
+// by their index everywhere else. Index 0 is the empty string.
+func readStringPool(pool []byte, data []byte) ([]string, bool, error) {
+    if len(pool) < 4 {
+        return nil, false, errCorruptMSI
+    }
+    codepage := binary.LittleEndian.Uint32(pool)
+    // Databases with too many strings for 16 bit references use 24 bit references instead
+    longReferences := codepage & 0x80000000 != 0
+    codepage &^= 0x80000000
+
+    values := []string{""}
+    offset := 0
+    for i := 4; i + 4 <= len(pool); i += 4 {
+        length := int(binary.LittleEndian.Uint16(pool[i:]))
+        references := binary.LittleEndian.Uint16(pool[i + 2:])
+        if length == 0 && references == 0 {
+            values = append(values, "")
+            continue
+        }
+        // Strings longer than 64k have their length in the next entry
+        if length == 0 {
+            if i + 8 > len(pool) {
+                return nil, false, errCorruptMSI
+            }
+            length = int(binary.LittleEndian.Uint16(pool[i + 4:])) | int(binary.LittleEndian.Uint16(pool[i + 6:])) << 16
+            i += 4
+        }
+        if offset + length > len(data) {
+            return nil, false, errCorruptMSI
+        }
+        values = append(values, decodeString(data[offset:offset + length], codepage))
+        offset += length
+    }
+    return values, longReferences, nil
+}
+
+// Strings are encoded in the codepage of the database. Other than UTF-8, that's a Windows
+// codepage in practice, which is decoded as Latin-1 as that's close enough for the metadata.
+func decodeString(b []byte, codepage uint32) string {
+    if codepage == 65001 || utf8.Valid(b) {
+        return string(b)
+    }
+    runes := make([]rune, len(b))
+    for i, c := range b {
+        runes[i] = rune(c)
+    }
+    return string(runes)
+}
+
+// Reads the Property table of an MSI database, which holds ProductCode, UpgradeCode and the like
+func readMSIProperties(r io.ReaderAt, size int64) (map[string]string, error) {
+    cf, err := openCompoundFile(r, size)
+    if err != nil {
+        return nil, err
+    }
+    streams := make(map[string][]byte)
+    for name, entry := range cf.rootStreams() {
+        switch name := decodeStreamName(name); name {
+        case "_StringPool", "_StringData", "Property":
+            if streams[name], err = cf.readStream(entry); err != nil {
+                return nil, err
+            }
+        }
+    }
+
+    stringPool, longReferences, err := readStringPool(streams["_StringPool"], streams["_StringData"])
+    if err != nil {
+        return nil, err
+    }
+    referenceSize := 2
+    if longReferences {
+        referenceSize = 3
+    }
+    reference := func(b []byte) (string, error) {
+        index := int(b[0]) | int(b[1]) << 8
+        if referenceSize == 3 {
+            index |= int(b[2]) << 16
+        }
+        if index >= len(stringPool) {
+            return "", errCorruptMSI
+        }
+        return stringPool[index], nil
+    }
+
+    // Tables are stored column by column and both columns of the Property table are strings
+    table := streams["Property"]
+    rows := len(table) / (2 * referenceSize)
+    properties := make(map[string]string, rows)
+    for row := 0; row < rows; row++ {
+        name, err := reference(table[row * referenceSize:])
+        if err != nil {
+            return nil, err
+        }
+        value, err := reference(table[(rows + row) * referenceSize:])
+        if err != nil {
+            return nil, err
+        }
+        properties[name] = value
+    }
+    return properties, nil
+}
@@ -0,0 +1,277 @@
+package installerinfo
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "maps"
+    "strings"
+    "testing"
+    "unicode/utf16"
+)
+
+// A stream in the root storage of a compound file built by buildCompoundFile
+type testStream struct {
+    name string
+    data []byte
+}
+
+// The inverse of decodeStreamName for names of tables
+func encodeStreamName(name string) string {
+    const alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz._"
+    encoded := []rune{0x4840}
+    for i := 0; i < len(name); i += 2 {
+        if i + 1 < len(name) {
+            encoded = append(encoded, rune(0x3800 + strings.IndexByte(alphabet, name[i]) + strings.IndexByte(alphabet, name[i + 1]) << 6))
+        } else {
+            encoded = append(encoded, rune(0x4800 + strings.IndexByte(alphabet, name[i])))
+        }
+    }
+    return string(encoded)
+}
+
+func putDirectoryEntry(name string, objectType byte, child uint32, right uint32, startSector uint32, size int) []byte {
+    entry := make([]byte, directoryEntrySize)
+    units := utf16.Encode([]rune(name))
+    for i, unit := range units {
+        binary.LittleEndian.PutUint16(entry[i * 2:], unit)
+    }
+    binary.LittleEndian.PutUint16(entry[0x40:], uint16((len(units) + 1) * 2))
+    entry[0x42] = objectType
+    entry[0x43] = 1 // black
+    binary.LittleEndian.PutUint32(entry[0x44:], noStream)
+    binary.LittleEndian.PutUint32(entry[0x48:], right)
+    binary.LittleEndian.PutUint32(entry[0x4C:], child)
+    binary.LittleEndian.PutUint32(entry[0x74:], startSector)
+    binary.LittleEndian.PutUint64(entry[0x78:], uint64(size))
+    return entry
+}
+
+// Builds a version 3 compound file with 512 byte sectors and a single FAT sector. Streams
+// smaller than 4096 bytes end up in the mini stream, larger ones in regular sectors.
+func buildCompoundFile(streams []testStream) []byte {
+    const sectorSize = 512
+    // Sector 0 holds the FAT
+    sectors := [][]byte{nil}
+    fat := map[int]uint32{0: 0xFFFFFFFD}
+    allocateChain := func(data []byte) uint32 {
+        first := len(sectors)
+        for offset := 0; offset < max(len(data), 1); offset += sectorSize {
+            sector := make([]byte, sectorSize)
+            copy(sector, data[offset:min(offset + sectorSize, len(data))])
+            if len(sectors) > first {
+                fat[len(sectors) - 1] = uint32(len(sectors))
+            }
+            fat[len(sectors)] = endOfChain
+            sectors = append(sectors, sector)
+        }
+        return uint32(first)
+    }
+
+    var miniStream []byte
+    var miniFAT []byte
+    var directory []byte
+    for i, stream := range streams {
+        right := uint32(noStream)
+        if i + 1 < len(streams) {
+            right = uint32(i + 2)
+        }
+        var start uint32
+        if len(stream.data) < 4096 {
+            start = uint32(len(miniStream) / miniSectorSize)
+            count := (len(stream.data) + miniSectorSize - 1) / miniSectorSize
+            for j := range count {
+                next := uint32(endOfChain)
+                if j < count - 1 {
+                    next = start + uint32(j) + 1
+                }
+                miniFAT = binary.LittleEndian.AppendUint32(miniFAT, next)
+            }
+            padded := make([]byte, count * miniSectorSize)
+            copy(padded, stream.data)
+            miniStream = append(miniStream, padded...)
+        } else {
+            start = allocateChain(stream.data)
+        }
+        directory = append(directory, putDirectoryEntry(stream.name, streamObject, noStream, right, start, len(stream.data))...)
+    }
+    miniStreamStart := allocateChain(miniStream)
+    miniFATStart := allocateChain(miniFAT)
+    directory = append(putDirectoryEntry("Root Entry", rootStorageObject, 1, noStream, miniStreamStart, len(miniStream)), directory...)
+    directoryStart := allocateChain(directory)
+
+    sectors[0] = make([]byte, sectorSize)
+    for i := 0; i < sectorSize / 4; i++ {
+        next, ok := fat[i]
+        if !ok {
+            next = noStream
+        }
+        binary.LittleEndian.PutUint32(sectors[0][i * 4:], next)
+    }
+
+    header := make([]byte, 512)
+    copy(header, compoundFileSignature)
+    binary.LittleEndian.PutUint16(header[0x18:], 0x3E)
+    binary.LittleEndian.PutUint16(header[0x1A:], 3)
+    binary.LittleEndian.PutUint16(header[0x1C:], 0xFFFE)
+    binary.LittleEndian.PutUint16(header[0x1E:], 9)
+    binary.LittleEndian.PutUint16(header[0x20:], 6)
+    binary.LittleEndian.PutUint32(header[0x2C:], 1)
+    binary.LittleEndian.PutUint32(header[0x30:], directoryStart)
+    binary.LittleEndian.PutUint32(header[0x38:], 4096)
+    binary.LittleEndian.PutUint32(header[0x3C:], miniFATStart)
+    binary.LittleEndian.PutUint32(header[0x40:], 1)
+    binary.LittleEndian.PutUint32(header[0x44:], endOfChain)
+    for i := 1; i < 109; i++ {
+        binary.LittleEndian.PutUint32(header[0x4C + i * 4:], noStream)
+    }
+    return bytes.Join(append([][]byte{header}, sectors...), nil)
+}
+
+// Returns the streams of an MSI database with a Property table holding the properties
+func msiStreams(properties [][2]string, codepage uint32, longReferences bool) []testStream {
+    var pool, data, table []byte
+    if longReferences {
+        codepage |= 0x80000000
+    }
+    pool = binary.LittleEndian.AppendUint32(pool, codepage)
+    var names, values []byte
+    appendReference := func(column []byte, index int) []byte {
+        column = binary.LittleEndian.AppendUint16(column, uint16(index))
+        if longReferences {
+            column = append(column, byte(index >> 16))
+        }
+        return column
+    }
+    for i, property := range properties {
+        for _, s := range property {
+            encoded := []byte(s)
+            if codepage &^ 0x80000000 != 65001 {
+                encoded = nil
+                for _, r := range s {
+                    encoded = append(encoded, byte(r))
+                }
+            }
+            data = append(data, encoded...)
+            pool = binary.LittleEndian.AppendUint16(pool, uint16(len(encoded)))
+            pool = binary.LittleEndian.AppendUint16(pool, 1)
+        }
+        names = appendReference(names, 1 + i * 2)
+        values = appendReference(values, 2 + i * 2)
+    }
+    table = append(names, values...)
+    return []testStream{
+        {encodeStreamName("_StringPool"), pool},
+        {encodeStreamName("_StringData"), data},
+        {encodeStreamName("Property"), table},
+        {"\x05SummaryInformation", bytes.Repeat([]byte("x"), 100)},
+        // Large enough to be stored in regular sectors instead of the mini stream
+        {encodeStreamName("Binary.big"), bytes.Repeat([]byte("0123456789abcdef"), 640)},
+    }
+}
+
+var testProperties = [][2]string{
+    {"ProductCode", "{11111111-2222-3333-4444-555555555555}"},
+    {"UpgradeCode", "{AAAAAAAA-BBBB-CCCC-DDDD-EEEEEEEEEEEE}"},
+    {"ProductVersion", "2.1.0.5"},
+    {"Manufacturer", "Contosö Ltd"},
+    {"ProductName", "Contoso App"},
+}
+
+func TestDecodeStreamName(t *testing.T) {
+    for _, name := range []string{"_StringPool", "_StringData", "Property", "Binary.big", "a", "File_1"} {
+        if got := decodeStreamName(encodeStreamName(name)); got != name {
+            t.Errorf("decodeStreamName(encodeStreamName(%q)) = %q", name, got)
+        }
+    }
+    if got := decodeStreamName("\x05SummaryInformation"); got != "\x05SummaryInformation" {
+        t.Errorf("decodeStreamName changed an uncompressed name to %q", got)
+    }
+}
+
+func TestReadMSIProperties(t *testing.T) {
+    want := make(map[string]string)
+    for _, property := range testProperties {
+        want[property[0]] = property[1]
+    }
+
+    tests := []struct {
+        name string
+        codepage uint32
+        longReferences bool
+    }{
+        {"Windows-1252", 1252, false},
+        {"UTF-8", 65001, false},
+        {"long string references", 1252, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            file := buildCompoundFile(msiStreams(testProperties, tt.codepage, tt.longReferences))
+            got, err := readMSIProperties(bytes.NewReader(file), int64(len(file)))
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !maps.Equal(got, want) {
+                t.Errorf("got %v, want %v", got, want)
+            }
+        })
+    }
+}
+
+func TestReadMSIPropertiesCorrupt(t *testing.T) {
+    valid := buildCompoundFile(msiStreams(testProperties, 1252, false))
+    patched := func(offset int, value uint32) []byte {
+        file := bytes.Clone(valid)
+        binary.LittleEndian.PutUint32(file[offset:], value)
+        return file
+    }
+    withStream := func(name string, data []byte) []byte {
+        streams := msiStreams(testProperties, 1252, false)
+        for i := range streams {
+            if streams[i].name == encodeStreamName(name) {
+                streams[i].data = data
+            }
+        }
+        return buildCompoundFile(streams)
+    }
+
+    tests := []struct {
+        name string
+        file []byte
+        want error
+    }{
+        {"wrong signature", append([]byte("PK\x03\x04"), valid[4:]...), errCorruptCompoundFile},
+        {"unsupported sector size", patched(0x1C, 0xFFFE | 10 << 16), errCorruptCompoundFile},
+        {"more FAT sectors than the file has", patched(0x2C, 1000), errCorruptCompoundFile},
+        {"FAT sector out of range", patched(0x4C, 1000), errCorruptCompoundFile},
+        {"directory sector out of range", patched(0x30, 1000), errCorruptCompoundFile},
+        {"no root entry", patched(0x30, 1), errCorruptCompoundFile},
+        {"truncated", valid[:len(valid) - 512], errCorruptCompoundFile},
+        {"empty string pool", withStream("_StringPool", []byte{}), errCorruptMSI},
+        {"string data too short", withStream("_StringData", []byte("{1111")), errCorruptMSI},
+        {"string reference out of range", withStream("Property", []byte{0xFF, 0xFF, 0x02, 0x00}), errCorruptMSI},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            properties, err := readMSIProperties(bytes.NewReader(tt.file), int64(len(tt.file)))
+            if !errors.Is(err, tt.want) {
+                t.Errorf("got %v, %v, want %v", properties, err, tt.want)
+            }
+        })
+    }
+}
+
+// Installers come from anywhere, no damage to a file may crash the parser
+func TestReadMSIPropertiesDamaged(t *testing.T) {
+    valid := buildCompoundFile(msiStreams(testProperties, 1252, false))
+    for size := 0; size < len(valid); size += 61 {
+        readMSIProperties(bytes.NewReader(valid[:size]), int64(size))
+    }
+    for offset := 0; offset < len(valid); offset += 7 {
+        for _, value := range []byte{0x00, 0x01, 0x7F, 0xFF} {
+            file := bytes.Clone(valid)
+            file[offset] = value
+            readMSIProperties(bytes.NewReader(file), int64(len(file)))
+        }
+    }
+}
@@ -0,0 +1,106 @@
+package installerinfo
+
+import (
+    "archive/zip"
+    "crypto/sha256"
+    "encoding/binary"
+    "encoding/hex"
+    "encoding/xml"
+    "errors"
+    "io"
+    "strings"
+    "unicode/utf16"
+)
+
+// The first bytes of a ZIP file, the container format of MSIX and APPX packages and bundles
+var zipSignature = []byte("PK\x03\x04")
+
+var errCorruptMSIX = errors.New("corrupt MSIX package")
+
+// Packages and bundles both describe themselves with an Identity element below the root
+type appxIdentity struct {
+    Identity struct {
+        Name string `xml:"Name,attr"`
+        Publisher string `xml:"Publisher,attr"`
+    } `xml:"Identity"`
+}
+
+// The publisher ID is the part of a PackageFamilyName that's derived from the Publisher, the
+// first 64 bits of the SHA256 of its UTF-16 encoding in a base32 alphabet without i, l, o and u
+func publisherID(publisher string) string {
+    const alphabet = "0123456789abcdefghjkmnpqrstvwxyz"
+    encoded := make([]byte, 0, len(publisher) * 2)
+    for _, c := range utf16.Encode([]rune(publisher)) {
+        encoded = binary.LittleEndian.AppendUint16(encoded, c)
+    }
+    hash := sha256.Sum256(encoded)
+    bits := binary.BigEndian.Uint64(hash[:8])
+
+    // 13 characters of 5 bits each, so the 64 bits are padded with a zero bit
+    id := make([]byte, 13)
+    for i := 0; i < 12; i++ {
+        id[i] = alphabet[(bits >> (59 - 5 * i)) & 31]
+    }
+    id[12] = alphabet[(bits << 1) & 31]
+    return string(id)
+}
+
+func readZipFile(file *zip.File) ([]byte, error) {
+    f, err := file.Open()
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    // Don't trust the size in the ZIP, compressed files can expand to far more
+    data, err := io.ReadAll(io.LimitReader(f, maxStreamSize + 1))
+    if err == nil && len(data) > maxStreamSize {
+        err = errCorruptMSIX
+    }
+    return data, err
+}
+
+// Reads the identity and signature of an MSIX or APPX package or bundle
+func readMSIX(r io.ReaderAt, size int64) (Metadata, error) {
+    archive, err := zip.NewReader(r, size)
+    if err != nil {
+        return Metadata{}, err
+    }
+    files := make(map[string]*zip.File)
+    for _, file := range archive.File {
+        files[strings.ToLower(file.Name)] = file
+    }
+
+    manifest := files["appxmanifest.xml"]
+    if manifest == nil {
+        manifest = files["appxmetadata/appxbundlemanifest.xml"]
+    }
+    // Any other ZIP file, e.g. of a zip installer
+    if manifest == nil {
+        return Metadata{}, ErrUnsupportedInstaller
+    }
+    data, err := readZipFile(manifest)
+    if err != nil {
+        return Metadata{}, err
+    }
+    var identity appxIdentity
+    if err := xml.Unmarshal(data, &identity); err != nil {
+        return Metadata{}, err
+    }
+    if identity.Identity.Name == "" || identity.Identity.Publisher == "" {
+        return Metadata{}, errCorruptMSIX
+    }
+    metadata := Metadata{
+        PackageFamilyName: identity.Identity.Name + "_" + publisherID(identity.Identity.Publisher),
+    }
+
+    // Unsigned packages have no signature to hash
+    if signature := files["appxsignature.p7x"]; signature != nil {
+        data, err := readZipFile(signature)
+        if err != nil {
+            return Metadata{}, err
+        }
+        hash := sha256.Sum256(data)
+        metadata.SignatureSha256 = strings.ToUpper(hex.EncodeToString(hash[:]))
+    }
+    return metadata, nil
+}
@@ -7,15 +7,15 @@ import (
     "time"
     "strings"
     "net/http"
-    "net/netip"
 
     // Structured logging
     "github.com/rs/zerolog"
     "github.com/rs/zerolog/hlog"
+
+    "rewinged/forwarded"
 )
 
 var Logger zerolog.Logger
-var TrustedProxies []netip.Prefix = []netip.Prefix{}
 
 func InitLogger(level string, releaseMode bool) {
     zerolog.TimeFieldFormat = time.RFC3339
@@ -53,21 +53,7 @@ func RequestLogger(next http.Handler) http.Handler {
 
     accessHandler := hlog.AccessHandler(
         func(r *http.Request, status, size int, duration time.Duration) {
-            clientIp := r.RemoteAddr
-            clientAddrPort, err := netip.ParseAddrPort(r.RemoteAddr)
-            if err == nil {
-                clientIp = clientAddrPort.Addr().String()
-                for _, proxy := range(TrustedProxies) {
-                    if proxy.Contains(clientAddrPort.Addr()) {
-                        if xffClientIp := r.Header.Get("X-Forwarded-For"); xffClientIp != "" {
-                            clientIp = xffClientIp
-                        } else if xripClientIp := r.Header.Get("X-Real-Ip"); xripClientIp != "" {
-                            clientIp = xripClientIp
-                        }
-                        break
-                    }
-                }
-            }
+            clientIp := forwarded.Resolve(r).ClientIP
             hlog.FromRequest(r).Info().
                 Str("method", r.Method).
                 Stringer("path", r.URL).
@@ -8,10 +8,15 @@ import (
     "flag"
     "sync"
     "time"
+    "net"
+    "context"
     "strings"
+    "syscall"
     "unicode"
+    "net/url"
     "net/http"
     "net/netip"
+    "os/signal"
     "path/filepath"
     // Configuration
     "github.com/peterbourgon/ff/v3"
@@ -20,6 +25,7 @@ import (
 
     "rewinged/settings"
     "rewinged/logging"
+    "rewinged/metrics"
     "rewinged/models"
     "rewinged/controllers"
 )
@@ -32,8 +38,15 @@ var releaseMode = "false"
 
 var wg sync.WaitGroup
 var jobs chan string = make(chan string)
+var installerDownloads *downloadManager
+var packageManagement *packageManager
 
 func main() {
+    // Subcommands are only recognized as the first argument, anything else runs the server
+    if len(os.Args) > 1 && os.Args[1] == "validate" {
+        os.Exit(runValidateCommand(os.Args[2:]))
+    }
+
     fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
     var (
         versionFlagPtr = fs.Bool("version", false, "Print the version information and exit")
@@ -46,11 +59,27 @@ func main() {
         autoInternalizePtr     = fs.Bool("autoInternalize", false, "Turn on the auto-internalization feature")
         autoInternalizePathPtr = fs.String("autoInternalizePath", "./installers", "The directory where auto-internalized installers will be stored")
         autoInternalizeSkipPtr = fs.String("autoInternalizeSkip", "", "List of hostnames excluded from auto-internalization (comma or space to separate)")
-        sourceAuthTypePtr             = fs.String("sourceAuthType", "none", "Require authentication to interact with the REST API: none, microsoftEntraId")
+        autoInternalizeConcurrencyPtr = fs.Int("autoInternalizeConcurrency", 4, "The number of installers that are downloaded at the same time for auto-internalization")
+        autoInternalizeCleanupPtr     = fs.String("autoInternalizeCleanup", "off", "What to do with internalized installers no manifest references anymore: off, dryrun (only report them) or delete")
+        autoInternalizeCleanupGracePtr = fs.Duration("autoInternalizeCleanupGracePeriod", 24 * time.Hour, "How long an internalized installer has to be unreferenced before it is deleted")
+        autoInternalizeTimeoutPtr     = fs.Duration("autoInternalizeTimeout", time.Minute, "How long an installer download may stall without receiving any data before it is retried")
+        sourceAuthTypePtr             = fs.String("sourceAuthType", "none", "Require authentication to interact with the REST API: none, microsoftEntraId, oidc")
         sourceAuthEntraIDResourcePtr  = fs.String("sourceAuthEntraIDResource", "", "ApplicationID of the EntraID App used for authenticating clients")
         sourceAuthEntraIDAuthorityURL = fs.String("sourceAuthEntraIDAuthorityURL", "", "Authority/Issuer URL of the EntraID App used for authenticating clients")
+        sourceAuthOIDCIssuerURLPtr    = fs.String("sourceAuthOIDCIssuerURL", "", "Issuer URL of the OIDC provider used for authenticating clients")
+        sourceAuthOIDCAudiencePtr     = fs.String("sourceAuthOIDCAudience", "", "Audience (aud) that client access tokens must be issued for by the OIDC provider")
+        sourceAuthRequiredClaimsPtr   = fs.String("sourceAuthRequiredClaims", "", "List of claim=value pairs client access tokens must contain (comma or space to separate)")
+        sourceAuthPolicyFilePtr       = fs.String("sourceAuthPolicyFile", "", "Path to a json file with rules which packages clients may access based on their token claims (optional)")
+        sourceAuthClockSkewPtr        = fs.Duration("sourceAuthClockSkew", 5 * time.Minute, "How long after their expiry client access tokens are still accepted, to tolerate clock skew")
         logLevelPtr            = fs.String("logLevel", "info", "Set log verbosity: disable, error, warn, info, debug or trace")
-        trustedProxiesPtr      = fs.String("trustedProxies", "", "List of IPs from which to trust Client-IP headers (comma or space to separate)")
+        publicBaseURLPtr       = fs.String("publicBaseUrl", "", "The URL under which clients reach rewinged, used for internalized InstallerUrls instead of the request or forwarded headers (optional)")
+        trustedProxiesPtr      = fs.String("trustedProxies", "", "List of IPs or networks of reverse proxies whose forwarding headers are trusted (comma or space to separate)")
+        maximumPageSizePtr     = fs.Int("maximumPageSize", 1000, "The maximum number of packages returned per page of package listings or search results")
+        maximumUploadSizePtr   = fs.Int64("maximumUploadSize", 4096, "The maximum size of uploaded installers in MiB")
+        metricsEnablePtr       = fs.Bool("metrics", false, "Expose Prometheus metrics on /metrics")
+        adminTokenPtr          = fs.String("adminToken", "", "Bearer token for the package management and validation endpoints, which are disabled if it is not set")
+        manifestTemplatePathPtr = fs.String("manifestTemplatePath", "", "The directory with the manifest templates for installer uploads, which are disabled if it is not set")
+        shutdownTimeoutPtr     = fs.Duration("shutdownTimeout", 30 * time.Second, "How long to wait for in-flight requests to finish when shutting down")
         _                      = fs.String("configFile", "", "Path to a json configuration file (optional)")
     )
 
@@ -78,8 +107,8 @@ func main() {
 
     logging.InitLogger(*logLevelPtr, releaseMode == "true")
 
-    if *sourceAuthTypePtr != "microsoftEntraId" && *sourceAuthTypePtr != "none" {
-        logging.Logger.Fatal().Msg("sourceAuthType must be either none or microsoftEntraId")
+    if *sourceAuthTypePtr != "microsoftEntraId" && *sourceAuthTypePtr != "oidc" && *sourceAuthTypePtr != "none" {
+        logging.Logger.Fatal().Msg("sourceAuthType must be one of none, microsoftEntraId or oidc")
     }
 
     // sourceAuthEntraIDResource is required if sourceAuthType is "microsoftEntraId"
@@ -92,9 +121,99 @@ func main() {
         logging.Logger.Fatal().Msg("sourceAuthEntraIDAuthorityURL is required when sourceAuthType is set to microsoftEntraId")
     }
 
+    // sourceAuthOIDCIssuerURL is required if sourceAuthType is "oidc"
+    if *sourceAuthTypePtr == "oidc" && *sourceAuthOIDCIssuerURLPtr == "" {
+        logging.Logger.Fatal().Msg("sourceAuthOIDCIssuerURL is required when sourceAuthType is set to oidc")
+    }
+
+    // sourceAuthOIDCAudience is required if sourceAuthType is "oidc"
+    if *sourceAuthTypePtr == "oidc" && *sourceAuthOIDCAudiencePtr == "" {
+        logging.Logger.Fatal().Msg("sourceAuthOIDCAudience is required when sourceAuthType is set to oidc")
+    }
+
+    if *sourceAuthTypePtr == "none" && *sourceAuthPolicyFilePtr != "" {
+        logging.Logger.Fatal().Msg("sourceAuthPolicyFile requires sourceAuthType to be set to microsoftEntraId or oidc")
+    }
+
+    // Uploaded installers are served like internalized ones
+    if *manifestTemplatePathPtr != "" && (*adminTokenPtr == "" || !*autoInternalizePtr) {
+        logging.Logger.Fatal().Msg("manifestTemplatePath requires adminToken to be set and autoInternalize to be turned on")
+    }
+
+    if *sourceAuthClockSkewPtr < 0 {
+        logging.Logger.Fatal().Msg("sourceAuthClockSkew must not be negative")
+    }
+
+    if *autoInternalizeConcurrencyPtr < 1 {
+        logging.Logger.Fatal().Msg("autoInternalizeConcurrency must be at least 1")
+    }
+
+    if *autoInternalizeTimeoutPtr <= 0 {
+        logging.Logger.Fatal().Msg("autoInternalizeTimeout must be greater than 0")
+    }
+
+    if *autoInternalizeCleanupPtr != "off" && *autoInternalizeCleanupPtr != "dryrun" && *autoInternalizeCleanupPtr != "delete" {
+        logging.Logger.Fatal().Msg("autoInternalizeCleanup must be one of off, dryrun or delete")
+    }
+
+    if *autoInternalizeCleanupGracePtr < 0 {
+        logging.Logger.Fatal().Msg("autoInternalizeCleanupGracePeriod must not be negative")
+    }
+
+    if *adminTokenPtr != "" && len(*adminTokenPtr) < 16 {
+        logging.Logger.Fatal().Msg("adminToken must be at least 16 characters long")
+    }
+
+    if *shutdownTimeoutPtr < 0 {
+        logging.Logger.Fatal().Msg("shutdownTimeout must not be negative")
+    }
+
+    if *maximumPageSizePtr < 1 {
+        logging.Logger.Fatal().Msg("maximumPageSize must be at least 1")
+    }
+    settings.MaximumPageSize = *maximumPageSizePtr
+
+    if *maximumUploadSizePtr < 1 {
+        logging.Logger.Fatal().Msg("maximumUploadSize must be at least 1")
+    }
+
     settings.SourceAuthenticationType = *sourceAuthTypePtr
     settings.SourceAuthenticationEntraIDResource = *sourceAuthEntraIDResourcePtr
     settings.SourceAuthenticationEntraIDAuthorityURL = *sourceAuthEntraIDAuthorityURL
+    settings.SourceAuthenticationClockSkew = *sourceAuthClockSkewPtr
+    settings.SourceAuthorizationPolicyFile = *sourceAuthPolicyFilePtr
+
+    // Entra ID is just one particular OIDC provider, client tokens are verified the same way for both
+    switch settings.SourceAuthenticationType {
+    case "microsoftEntraId":
+        settings.SourceAuthenticationIssuerURL = *sourceAuthEntraIDAuthorityURL
+        settings.SourceAuthenticationAudience = *sourceAuthEntraIDResourcePtr
+    case "oidc":
+        settings.SourceAuthenticationIssuerURL = *sourceAuthOIDCIssuerURLPtr
+        settings.SourceAuthenticationAudience = *sourceAuthOIDCAudiencePtr
+    }
+
+    if (*sourceAuthRequiredClaimsPtr != "") {
+        requiredClaims := strings.FieldsFunc(*sourceAuthRequiredClaimsPtr, func(c rune) bool {
+            return unicode.IsSpace(c) || c == ','
+        })
+
+        for _, requiredClaim := range(requiredClaims) {
+            name, value, found := strings.Cut(requiredClaim, "=")
+            if !found || name == "" {
+                logging.Logger.Fatal().Str("claim", requiredClaim).Msg("invalid sourceAuthRequiredClaims, must be claim=value pairs")
+            }
+            settings.SourceAuthenticationRequiredClaims[name] = append(settings.SourceAuthenticationRequiredClaims[name], value)
+        }
+    }
+
+    if *publicBaseURLPtr != "" {
+        publicBaseURL, err := url.Parse(*publicBaseURLPtr)
+        if err != nil || (publicBaseURL.Scheme != "http" && publicBaseURL.Scheme != "https") || publicBaseURL.Host == "" {
+            logging.Logger.Fatal().Err(err).Msg("publicBaseUrl must be an absolute http or https URL")
+        }
+        settings.PublicBaseURL = *publicBaseURLPtr
+    }
 
     // Users can set 0.0.0.0/0 or ::/0 to trust all proxies if need be
     if (*trustedProxiesPtr != "") {
@@ -116,44 +235,210 @@ func main() {
             if err != nil {
                 logging.Logger.Fatal().Err(err).Msg("invalid trustedProxies")
             }
-            logging.TrustedProxies = append(logging.TrustedProxies, prefix)
+            settings.TrustedProxies = append(settings.TrustedProxies, prefix)
         }
     }
 
-    logging.Logger.Debug().Msg("searching for manifests")
+    // Live-reload events from notify always carry absolute paths with all symlinks
+    // resolved, so the manifestPath has to be in the same form for paths of manifest
+    // files to be correlated with the events affecting them.
+    manifestPath, err := filepath.Abs(*packagePathPtr)
+    if err == nil {
+        manifestPath, err = filepath.EvalSymlinks(manifestPath)
+    }
+    if err != nil {
+        logging.Logger.Fatal().Err(err).Msg("invalid manifestPath")
+    }
 
     autoInternalizeSkipHosts := strings.FieldsFunc(*autoInternalizeSkipPtr, func(c rune) bool {
         return unicode.IsSpace(c) || c == ','
     })
 
+    var getPackagesConfig = &controllers.GetPackageHandler{
+        InternalizationEnabled: *autoInternalizePtr,
+    }
+
+    health := &controllers.HealthHandler{}
+
+    router := http.NewServeMux()
+
+    // TODO: Recovery maybe?
+
+    fileServer := http.FileServer(http.Dir(*autoInternalizePathPtr))
+    router.HandleFunc("GET /api/information", controllers.GetInformation)
+    router.HandleFunc("GET /healthz", health.GetHealth)
+    router.HandleFunc("GET /readyz", health.GetReadiness)
+    if *metricsEnablePtr {
+        router.Handle("GET /metrics", metrics.Handler())
+    }
+    if *adminTokenPtr != "" {
+        admin := controllers.NewAdminAuthenticator(*adminTokenPtr)
+        router.Handle("GET /api/admin/validation", admin.Middleware(validationReportHandler(manifestPath)))
+        packageManagement = newPackageManager(manifestPath, func(dir string) {
+            ingestManifestDirectory(dir, *autoInternalizePtr, *autoInternalizePathPtr, autoInternalizeSkipHosts)
+        })
+        packageManagement.registerRoutes(router, admin.Middleware)
+        if *manifestTemplatePathPtr != "" {
+            newInstallerUploader(packageManagement, *autoInternalizePathPtr, *manifestTemplatePathPtr, *maximumUploadSizePtr << 20).registerRoutes(router, admin.Middleware)
+        }
+    }
+
+    switch settings.SourceAuthenticationType {
+    case "none":
+        router.Handle("/installers/", http.StripPrefix("/installers", hideDirectoryListings(fileServer)))
+        router.Handle("GET /api/packages", http.HandlerFunc(controllers.GetPackages))
+        router.Handle("POST /api/manifestSearch", http.HandlerFunc(controllers.SearchForPackage))
+        router.Handle("GET /api/packageManifests/{package_identifier}", http.HandlerFunc(getPackagesConfig.GetPackage))
+    case "microsoftEntraId", "oidc":
+        var policy *controllers.AuthorizationPolicy
+        if settings.SourceAuthorizationPolicyFile != "" {
+            policy, err = controllers.LoadAuthorizationPolicy(settings.SourceAuthorizationPolicyFile)
+            if err != nil {
+                logging.Logger.Fatal().Err(err).Str("file", settings.SourceAuthorizationPolicyFile).Msg("could not load sourceAuthPolicyFile")
+            }
+            logging.Logger.Info().Msgf("loaded authorization policy with %v rules", len(policy.Rules))
+        }
+
+        authenticator, err := controllers.NewJWTAuthenticator(
+            context.Background(),
+            settings.SourceAuthenticationIssuerURL,
+            settings.SourceAuthenticationAudience,
+            settings.SourceAuthenticationRequiredClaims,
+            policy,
+            settings.SourceAuthenticationClockSkew,
+        )
+        if err != nil {
+            logging.Logger.Fatal().Err(err).Str("issuer", settings.SourceAuthenticationIssuerURL).Msg("could not set up authentication")
+        }
+        health.Authenticator = authenticator
+        router.Handle("/installers/", http.StripPrefix("/installers", authenticator.Middleware(controllers.AuthorizeInstallerDownloads(hideDirectoryListings(fileServer)))))
+        router.Handle("GET /api/packages", authenticator.Middleware(http.HandlerFunc(controllers.GetPackages)))
+        router.Handle("POST /api/manifestSearch", authenticator.Middleware(http.HandlerFunc(controllers.SearchForPackage)))
+        router.Handle("GET /api/packageManifests/{package_identifier}", authenticator.Middleware(http.HandlerFunc(getPackagesConfig.GetPackage)))
+    default:
+        logging.Logger.Fatal().Msg("sourceAuthType must be one of none, microsoftEntraId or oidc")
+    }
+
+    logging_router := logging.RequestLogger(metrics.Middleware(router))
+
+    // The listening socket is either inherited from systemd socket activation or from a
+    // previous rewinged process handing it over during an upgrade, or opened by us
+    upgradingFrom := upgradeParent()
+    listener, err := inheritedListener()
+    if err != nil {
+        logging.Logger.Fatal().Err(err).Msg("could not use inherited socket")
+    }
+    if listener == nil {
+        listener, err = net.Listen("tcp", *listenAddrPtr)
+        if err != nil {
+            logging.Logger.Fatal().Err(err).Msg("could not start webserver")
+        }
+    } else {
+        logging.Logger.Info().Msgf("using inherited socket %v instead of listen", listener.Addr())
+    }
+
+    server := &http.Server{Handler: logging_router}
+    serverErrors := make(chan error, 1)
+    serve := func() {
+        go func() {
+            if *tlsEnablePtr {
+                logging.Logger.Info().Msgf("starting server on https://%v", listener.Addr())
+                serverErrors <- server.ServeTLS(listener, *tlsCertificatePtr, *tlsPrivateKeyPtr)
+            } else {
+                logging.Logger.Info().Msgf("starting server on http://%v", listener.Addr())
+                serverErrors <- server.Serve(listener)
+            }
+        }()
+    }
+
+    // Start serving right away so that probes can tell that rewinged is alive while it is
+    // still loading manifests. Until then, /readyz reports that it's not ready yet. During
+    // an upgrade the previous process keeps serving instead until we have all manifests.
+    if upgradingFrom == 0 {
+        serve()
+    }
+
+    shutdownSignals := make(chan os.Signal, 1)
+    signal.Notify(shutdownSignals, os.Interrupt, syscall.SIGTERM)
+    upgradeSignals := make(chan os.Signal, 1)
+    notifyUpgradeSignals(upgradeSignals)
+
+    // Make the channel buffered to try and not miss events. Notify will drop
+    // an event if the receiver is not able to keep up the sending pace.
+    fileEventsBuffer := 100
+    fileEventsChannel := make(chan notify.EventInfo, fileEventsBuffer)
+
+    shutdown := func() {
+        logging.Logger.Info().Msg("shutting down")
+        notify.Stop(fileEventsChannel)
+        installerDownloads.stop()
+
+        // Stop accepting new connections and wait for in-flight requests to finish
+        ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeoutPtr)
+        defer cancel()
+        if err := server.Shutdown(ctx); err != nil {
+            logging.Logger.Warn().Err(err).Msg("not all requests finished in time, closing their connections")
+            server.Close()
+        }
+        logging.Logger.Info().Msg("shutdown complete")
+    }
+
+    logging.Logger.Debug().Msg("searching for manifests")
+
+    if *autoInternalizePtr {
+        installerDownloads = newDownloadManager(*autoInternalizeConcurrencyPtr, *autoInternalizeTimeoutPtr, func(dir string) {
+            ingestManifestDirectory(dir, *autoInternalizePtr, *autoInternalizePathPtr, autoInternalizeSkipHosts)
+        })
+    }
+
     // Start up 6 worker goroutines that can parse in manifest-files from one directory each
     for w := 1; w <= 6; w++ {
         go ingestManifestsWorker(*autoInternalizePtr, *autoInternalizePathPtr, autoInternalizeSkipHosts)
     }
 
-    getManifests(*packagePathPtr)
-    wg.Wait()
+    // A new process taking over only starts downloading once the previous one has
+    // stopped, so that they never write to the same partial download at the same time
+    if upgradingFrom == 0 {
+        installerDownloads.start()
+    }
+
+    getManifests(manifestPath)
+
+    // Shutting down must not have to wait for all manifests to be loaded
+    initialScanDone := make(chan struct{})
+    go func() {
+        wg.Wait()
+        close(initialScanDone)
+    }()
+    select {
+    case <-initialScanDone:
+    case <-shutdownSignals:
+        shutdown()
+        return
+    case err := <-serverErrors:
+        logging.Logger.Fatal().Err(err).Msg("webserver failed")
+    }
 
-    // I don't know whether this is safe.
-    // if manifests is just a reference-copy of manifests2 then it wouldn't be I think?
-    // But *currently* since live-reload isn't implemented yet, manifests2 won't be written
-    // to after this point so it's safe for now - TODO: only access manifests2 in a thread-safe way
     logging.Logger.Info().Msgf("found %v package manifests", models.Manifests.GetManifestCount())
+    health.SetInitialScanDone()
+    packageManagement.setInitialScanDone()
+
+    // Only start looking for orphaned installers once all manifests are known, otherwise every
+    // installer referenced only by manifests that haven't been ingested yet would be one
+    if *autoInternalizePtr && *autoInternalizeCleanupPtr != "off" {
+        cleaner := newInstallerCleaner(*autoInternalizePathPtr, *autoInternalizeCleanupGracePtr, *autoInternalizeCleanupPtr == "dryrun")
+        go cleaner.runPeriodically()
+    }
 
     logging.Logger.Info().Msg("watching manifestPath for changes")
-    // Make the channel buffered to try and not miss events. Notify will drop
-    // an event if the receiver is not able to keep up the sending pace.
-    fileEventsBuffer := 100
-    fileEventsChannel := make(chan notify.EventInfo, fileEventsBuffer)
 
-    // Recursively listen for Create and Write events in the manifestPath.
-    // Currently not watching for remove / delete events because we couldn't
-    // correlate filenames to packages anyway so there's no way to know which
-    // package is affected by the event.
-    if err := notify.Watch(*packagePathPtr + "/...", fileEventsChannel, notify.Create, notify.Write); err != nil {
+    // Recursively listen for Create, Write, Remove and Rename events in the manifestPath.
+    // The ManifestsStore remembers which files every package version was read from, so
+    // re-ingesting the directory an event happened in is enough to pick up new and changed
+    // manifests as well as to evict versions whose files were removed or renamed.
+    if err := notify.Watch(manifestPath + "/...", fileEventsChannel, notify.Create, notify.Write, notify.Remove, notify.Rename); err != nil {
         logging.Logger.Fatal().Err(err)
     }
-    defer notify.Stop(fileEventsChannel)
 
     // If an event is received, push its directory-path to the jobs channel
     go func() {
@@ -166,68 +451,94 @@ func main() {
                 // If the channel is ever full we are missing events as the notify package drops them at this point
                 //log.Println("\x1b[31mfileEventsChannel full - we're missing events - will perform full manifest rescan\x1b[0m")
                 logging.Logger.Info().Msg("fileEventsChannel full - we're missing events - will perform full manifest rescan")
+                metrics.LiveReloadRescans.Inc()
+                health.SetRescanInProgress(true)
                 // Wait out the thundering herd - events have been lost anyway
                 time.Sleep(5 * time.Second)
                 // Drop all events to clear the channel, this also enables new events to stream in again
                 CLEAR_CHANNEL: for { select { case <- fileEventsChannel:; default: break CLEAR_CHANNEL } }
-                getManifests(*packagePathPtr)
+                getManifests(manifestPath)
                 // wait for the synchronous full rescan to finish.
                 // any events accumulated in the meantime will be processed after.
                 wg.Wait()
+                // A full rescan only visits directories that still exist, so removals
+                // of whole directories have to be found by checking the remembered files.
+                removeMissingManifests()
+                health.SetRescanInProgress(false)
             }
 
             ei := <- fileEventsChannel
             logging.Logger.Debug().Msgf("received event (type %T):\n\t%+v\n", ei, ei)
+            metrics.LiveReloadEvents.WithLabelValues(ei.Event().String()).Inc()
+
+            if fi, err := os.Stat(ei.Path()); err == nil && fi.IsDir() {
+                // A directory was created or moved into the manifestPath, possibly with
+                // subdirectories that will not generate events of their own
+                getManifests(ei.Path())
+                continue
+            } else if err != nil && ei.Event() & (notify.Remove | notify.Rename) != 0 {
+                // This could have been a directory, evict everything that was in it.
+                // If it was a file, this does nothing and the re-ingest below handles it.
+                for _, key := range models.Manifests.RemoveSourcesBelow(ei.Path()) {
+                    logging.Logger.Info().Str("package", key.PackageIdentifier).Str("packageversion", key.PackageVersion).Msg("removed manifest")
+                }
+            }
+
             wg.Add(1)
             jobs <- filepath.Dir(ei.Path())
         }
     }()
 
-    var getPackagesConfig = &controllers.GetPackageHandler{
-        TlsEnabled: *tlsEnablePtr,
-        InternalizationEnabled: *autoInternalizePtr,
-    }
-
-    router := http.NewServeMux()
-
-    // TODO: Recovery maybe?
-
-    fileServer := http.FileServer(http.Dir(*autoInternalizePathPtr))
-    router.HandleFunc("GET /api/information", controllers.GetInformation)
-
-    switch settings.SourceAuthenticationType {
-    case "none":
-        router.Handle("/installers/", http.StripPrefix("/installers", hideDirectoryListings(fileServer)))
-        router.Handle("GET /api/packages", http.HandlerFunc(controllers.GetPackages))
-        router.Handle("POST /api/manifestSearch", http.HandlerFunc(controllers.SearchForPackage))
-        router.Handle("GET /api/packageManifests/{package_identifier}", http.HandlerFunc(getPackagesConfig.GetPackage))
-    case "microsoftEntraId":
-        router.Handle("/installers/", http.StripPrefix("/installers", controllers.JWTAuthMiddleware(hideDirectoryListings(fileServer))))
-        router.Handle("GET /api/packages", controllers.JWTAuthMiddleware(http.HandlerFunc(controllers.GetPackages)))
-        router.Handle("POST /api/manifestSearch", controllers.JWTAuthMiddleware(http.HandlerFunc(controllers.SearchForPackage)))
-        router.Handle("GET /api/packageManifests/{package_identifier}", controllers.JWTAuthMiddleware(http.HandlerFunc(getPackagesConfig.GetPackage)))
-    default:
-        logging.Logger.Fatal().Msg("sourceAuthType must be either none or microsoftEntraId")
+    if upgradingFrom != 0 {
+        serve()
+        logging.Logger.Info().Msgf("taking over from previous rewinged process %v", upgradingFrom)
+        go func() {
+            if err := finishUpgrade(upgradingFrom); err != nil {
+                logging.Logger.Error().Err(err).Msg("could not stop previous rewinged process")
+            }
+            installerDownloads.start()
+        }()
     }
 
-    logging_router := logging.RequestLogger(router)
-
-    if *tlsEnablePtr {
-        logging.Logger.Info().Msgf("starting server on https://%v", *listenAddrPtr)
-        if err := http.ListenAndServeTLS(*listenAddrPtr, *tlsCertificatePtr, *tlsPrivateKeyPtr, logging_router); err != nil {
-            logging.Logger.Fatal().Err(err).Msg("could not start webserver")
-        }
-    } else {
-        logging.Logger.Info().Msgf("starting server on http://%v", *listenAddrPtr)
-        if err := http.ListenAndServe(*listenAddrPtr, logging_router); err != nil {
-            logging.Logger.Fatal().Err(err).Msg("could not start webserver")
+    upgradeFailed := make(chan error, 1)
+    upgradeInProgress := false
+    for {
+        select {
+        case <-upgradeSignals:
+            if upgradeInProgress {
+                logging.Logger.Warn().Msg("an upgrade is already in progress")
+                continue
+            }
+            upgrade, err := startUpgrade(listener)
+            if err != nil {
+                logging.Logger.Error().Err(err).Msg("could not start upgraded rewinged process")
+                continue
+            }
+            logging.Logger.Info().Msgf("started upgraded rewinged process %v, serving until it takes over", upgrade.Pid)
+            upgradeInProgress = true
+            go func() {
+                state, err := upgrade.Wait()
+                if err == nil {
+                    err = fmt.Errorf("%v", state)
+                }
+                upgradeFailed <- err
+            }()
+        case err := <-upgradeFailed:
+            logging.Logger.Error().Err(err).Msg("upgraded rewinged process exited before taking over, continuing to serve")
+            upgradeInProgress = false
+        case <-shutdownSignals:
+            shutdown()
+            return
+        case err := <-serverErrors:
+            logging.Logger.Fatal().Err(err).Msg("webserver failed")
         }
     }
 }
 
 func hideDirectoryListings(next http.Handler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        if strings.HasSuffix(r.URL.Path, "/") {
+        // Hidden files are incomplete downloads, they must not be served before they are verified
+        if strings.HasSuffix(r.URL.Path, "/") || strings.HasPrefix(filepath.Base(r.URL.Path), ".") {
             http.NotFound(w, r)
             return
         }
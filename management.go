@@ -0,0 +1,1027 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "sync"
+  "bytes"
+  "errors"
+  "slices"
+  "strings"
+  "net/http"
+  "io/fs"
+  "path/filepath"
+  "sync/atomic"
+  "encoding/json"
+
+  "gopkg.in/yaml.v3"
+
+  "rewinged/logging"
+  "rewinged/models"
+)
+
+// The management API accepts and returns package versions in this API schema, and
+// writes them to the manifestPath as manifests of the same ManifestVersion
+const managementSchemaVersion = "1.10.0"
+
+// Request bodies are at most one package with all its versions, which is nowhere near this
+const maxManagementRequestSize = 4 << 20
+
+// packageManager implements the package management endpoints of the reference winget REST
+// source. Every change is written to the manifestPath as a multi-file manifest, just like one
+// added by hand, and ingested from there, so the manifest files remain the only source of truth.
+// A package version is only a valid manifest with at least one installer, so unlike in the
+// reference, packages and versions cannot be created empty and filled in afterwards.
+type packageManager struct {
+  // Changes are made one at a time so that concurrent requests don't overwrite each other
+  sync.Mutex
+  manifestPath string
+  // Ingests the manifests in a directory synchronously
+  ingest func(dir string)
+  // Until all manifests are loaded, it's unknown where existing package versions are defined
+  initialScanDone atomic.Bool
+}
+
+func newPackageManager(manifestPath string, ingest func(dir string)) *packageManager {
+  return &packageManager{manifestPath: manifestPath, ingest: ingest}
+}
+
+func (pm *packageManager) setInitialScanDone() {
+  if pm == nil {
+    return
+  }
+  pm.initialScanDone.Store(true)
+}
+
+func (pm *packageManager) registerRoutes(router *http.ServeMux, protect func(http.Handler) http.Handler) {
+  routes := map[string]http.HandlerFunc{
+    "POST /api/packages": pm.createPackage,
+    "GET /api/packages/{package_identifier}": pm.getPackage,
+    "DELETE /api/packages/{package_identifier}": pm.deletePackage,
+    "GET /api/packages/{package_identifier}/versions": pm.getVersions,
+    "POST /api/packages/{package_identifier}/versions": pm.createVersion,
+    "GET /api/packages/{package_identifier}/versions/{package_version}": pm.getVersion,
+    "PUT /api/packages/{package_identifier}/versions/{package_version}": pm.updateVersion,
+    "DELETE /api/packages/{package_identifier}/versions/{package_version}": pm.deleteVersion,
+    "GET /api/packages/{package_identifier}/versions/{package_version}/installers": pm.getInstallers,
+    "POST /api/packages/{package_identifier}/versions/{package_version}/installers": pm.createInstaller,
+    "GET /api/packages/{package_identifier}/versions/{package_version}/installers/{installer_identifier}": pm.getInstaller,
+    "PUT /api/packages/{package_identifier}/versions/{package_version}/installers/{installer_identifier}": pm.updateInstaller,
+    "DELETE /api/packages/{package_identifier}/versions/{package_version}/installers/{installer_identifier}": pm.deleteInstaller,
+    "GET /api/packages/{package_identifier}/versions/{package_version}/locales": pm.getLocales,
+    "POST /api/packages/{package_identifier}/versions/{package_version}/locales": pm.createLocale,
+    "GET /api/packages/{package_identifier}/versions/{package_version}/locales/{package_locale}": pm.getLocale,
+    "PUT /api/packages/{package_identifier}/versions/{package_version}/locales/{package_locale}": pm.updateLocale,
+    "DELETE /api/packages/{package_identifier}/versions/{package_version}/locales/{package_locale}": pm.deleteLocale,
+    "POST /api/packageManifests": pm.createPackageManifest,
+    "PUT /api/packageManifests/{package_identifier}": pm.updatePackageManifest,
+    "DELETE /api/packageManifests/{package_identifier}": pm.deletePackage,
+  }
+  for pattern, handler := range routes {
+    router.Handle(pattern, protect(handler))
+  }
+}
+
+// A request that cannot be fulfilled and the HTTP status code to respond with
+type managementError struct {
+  status int
+  message string
+}
+
+func (e managementError) Error() string {
+  return e.message
+}
+
+func newManagementError(status int, format string, a ...any) error {
+  return managementError{status: status, message: fmt.Sprintf(format, a...)}
+}
+
+func writeManagementError(w http.ResponseWriter, err error) {
+  var managementErr managementError
+  if !errors.As(err, &managementErr) {
+    logging.Logger.Error().Err(err).Msg("package management request failed")
+    managementErr = managementError{status: http.StatusInternalServerError, message: http.StatusText(http.StatusInternalServerError)}
+  }
+
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(managementErr.status)
+  json.NewEncoder(w).Encode(models.API_WingetApiError{
+    ErrorCode: managementErr.status,
+    ErrorMessage: managementErr.message,
+  })
+}
+
+func writeManagementData(w http.ResponseWriter, data any) {
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(http.StatusOK)
+  json.NewEncoder(w).Encode(struct{ Data any }{data})
+}
+
+func decodeManagementRequest(w http.ResponseWriter, r *http.Request, v any) error {
+  d := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxManagementRequestSize))
+  d.DisallowUnknownFields() // catch properties that would be lost silently
+  err := d.Decode(v)
+  var tooLarge *http.MaxBytesError
+  if errors.As(err, &tooLarge) {
+    return newManagementError(http.StatusRequestEntityTooLarge, "the request body must not be larger than %v bytes", tooLarge.Limit)
+  } else if err != nil {
+    return newManagementError(http.StatusBadRequest, "invalid request body: %v", err)
+  }
+  return nil
+}
+
+// The PackageManifest schema of the reference, with versions that can be decoded from JSON
+type managedPackageManifest struct {
+  PackageIdentifier string
+  Versions []models.API_ManifestVersion_1_10_0
+}
+
+// Manifests have no InstallerIdentifier, so installers are identified by the properties that
+// usually tell them apart instead, numbered in their order if that isn't enough
+func assignInstallerIdentifiers(installers []models.API_Installer_1_10_0) {
+  seen := make(map[string]int)
+  for i := range installers {
+    var parts []string
+    for _, part := range []string{installers[i].Architecture, installers[i].InstallerType, installers[i].Scope, installers[i].InstallerLocale} {
+      if part != "" {
+        parts = append(parts, strings.ToLower(part))
+      }
+    }
+    identifier := strings.Join(parts, "-")
+    if identifier == "" {
+      identifier = "installer"
+    }
+    seen[identifier]++
+    if seen[identifier] > 1 {
+      identifier = fmt.Sprintf("%v-%d", identifier, seen[identifier])
+    }
+    installers[i].InstallerIdentifier = identifier
+  }
+}
+
+// Returns a package version as it is currently loaded, in the schema of the management API
+func getManagedVersion(packageIdentifier string, packageVersion string) (models.API_ManifestVersion_1_10_0, error) {
+  stored := models.Manifests.Get(packageIdentifier, packageVersion)
+  if stored == nil {
+    return models.API_ManifestVersion_1_10_0{}, newManagementError(http.StatusNotFound, "version %v of package %v was not found", packageVersion, packageIdentifier)
+  }
+  converted, err := models.ConvertManifestVersion(stored, managementSchemaVersion)
+  if err != nil {
+    return models.API_ManifestVersion_1_10_0{}, err
+  }
+  version := converted.(models.API_ManifestVersion_1_10_0)
+  assignInstallerIdentifiers(version.Installers)
+  return version, nil
+}
+
+// Returns a package version as it is defined by its manifest files, in the schema of the management
+// API. The loaded package version has the metadata of its internalized installers filled in, which
+// must not end up in the manifest files when they are rewritten, so changes start from this instead.
+func readManagedVersion(packageIdentifier string, packageVersion string) (models.API_ManifestVersion_1_10_0, error) {
+  notFound := newManagementError(http.StatusNotFound, "version %v of package %v was not found", packageVersion, packageIdentifier)
+  files := models.Manifests.GetSourceFilesOf(packageIdentifier, packageVersion)
+  if len(files) == 0 {
+    return models.API_ManifestVersion_1_10_0{}, notFound
+  }
+  manifests, _, err := parseManifestDirectory(filepath.Dir(files[0]))
+  if err != nil {
+    return models.API_ManifestVersion_1_10_0{}, err
+  }
+  for _, manifest := range manifests {
+    if manifest.packageIdentifier != packageIdentifier || manifest.version.GetPackageVersion() != packageVersion {
+      continue
+    }
+    converted, err := models.ConvertManifestVersion(manifest.version, managementSchemaVersion)
+    if err != nil {
+      return models.API_ManifestVersion_1_10_0{}, err
+    }
+    version := converted.(models.API_ManifestVersion_1_10_0)
+    assignInstallerIdentifiers(version.Installers)
+    return version, nil
+  }
+  // The files were changed since they were loaded, and live-reload hasn't caught up yet
+  return models.API_ManifestVersion_1_10_0{}, notFound
+}
+
+// Returns all versions of a package as they are currently loaded, newest first, in the schema of the management API
+func getManagedVersions(packageIdentifier string) ([]models.API_ManifestVersion_1_10_0, error) {
+  var versions []models.API_ManifestVersion_1_10_0
+  for _, stored := range models.Manifests.GetAllVersions(packageIdentifier) {
+    version, err := getManagedVersion(packageIdentifier, stored.GetPackageVersion())
+    if err != nil {
+      return nil, err
+    }
+    versions = append(versions, version)
+  }
+  if len(versions) == 0 {
+    return nil, newManagementError(http.StatusNotFound, "package %v was not found", packageIdentifier)
+  }
+  return versions, nil
+}
+
+// A manifest file as it will be written to disk
+type manifestFile struct {
+  path string
+  data []byte
+}
+
+// The changes to the manifestPath that write or delete one package version
+type versionChange struct {
+  key models.ManifestKey
+  dir string
+  // The manifest files to write, none if the package version is deleted
+  files []manifestFile
+  // Files that currently define the package version and are not overwritten
+  obsolete []string
+}
+
+// Returns the directory the manifest files of a package version are in, or will be written to
+// if it doesn't exist yet, and the files it is currently defined in.
+func (pm *packageManager) locateVersion(key models.ManifestKey) (string, []string, error) {
+  files := models.Manifests.GetSourceFilesOf(key.PackageIdentifier, key.PackageVersion)
+  if len(files) == 0 {
+    // The same layout as the winget-pkgs repository: m/Microsoft/PowerToys/0.70.0
+    segments := []string{pm.manifestPath, strings.ToLower(string([]rune(key.PackageIdentifier)[0]))}
+    segments = append(segments, strings.Split(key.PackageIdentifier, ".")...)
+    return filepath.Join(append(segments, key.PackageVersion)...), nil, nil
+  }
+
+  // Package versions are only ever defined by the files in one directory
+  for _, file := range files {
+    for _, other := range models.Manifests.GetPackageVersionsFrom(file) {
+      if other != key {
+        return "", nil, newManagementError(
+          http.StatusConflict,
+          "%v also defines version %v of package %v, change it in the manifestPath instead",
+          relativePath(pm.manifestPath, file), other.PackageVersion, other.PackageIdentifier,
+        )
+      }
+    }
+  }
+  return filepath.Dir(files[0]), files, nil
+}
+
+// Renders a package version as manifest files and checks that they are valid before anything is written
+func (pm *packageManager) prepareWrite(packageIdentifier string, version models.API_ManifestVersion_1_10_0) (versionChange, error) {
+  // Both become directory names
+  if len(packageIdentifier) > maxPackageIdentifierLength || !packageIdentifierPattern.MatchString(packageIdentifier) {
+    return versionChange{}, newManagementError(http.StatusBadRequest, "PackageIdentifier %q does not match the pattern Publisher.Package required by the schema", packageIdentifier)
+  }
+  if len(version.PackageVersion) > maxPackageVersionLength || !packageVersionPattern.MatchString(version.PackageVersion) || version.PackageVersion == "." || version.PackageVersion == ".." {
+    return versionChange{}, newManagementError(http.StatusBadRequest, "PackageVersion %q contains characters that are not allowed or is too long", version.PackageVersion)
+  }
+
+  key := models.ManifestKey{PackageIdentifier: packageIdentifier, PackageVersion: version.PackageVersion}
+  dir, existing, err := pm.locateVersion(key)
+  if err != nil {
+    return versionChange{}, err
+  }
+
+  files, err := renderManifestFiles(dir, packageIdentifier, version)
+  if err != nil {
+    return versionChange{}, err
+  }
+  if err := checkManifestFiles(packageIdentifier, files); err != nil {
+    return versionChange{}, err
+  }
+
+  change := versionChange{key: key, dir: dir, files: files}
+  for _, file := range files {
+    if _, err := os.Stat(file.path); err == nil && !slices.Contains(existing, file.path) {
+      return versionChange{}, newManagementError(http.StatusConflict, "%v already exists and does not belong to this package version", relativePath(pm.manifestPath, file.path))
+    }
+  }
+  for _, file := range existing {
+    if !slices.ContainsFunc(files, func(f manifestFile) bool { return f.path == file }) {
+      change.obsolete = append(change.obsolete, file)
+    }
+  }
+  return change, nil
+}
+
+func (pm *packageManager) prepareDelete(key models.ManifestKey) (versionChange, error) {
+  dir, existing, err := pm.locateVersion(key)
+  if err != nil {
+    return versionChange{}, err
+  }
+  return versionChange{key: key, dir: dir, obsolete: existing}, nil
+}
+
+// Writes the changes to the manifestPath and ingests them right away, so that they are
+// visible to the next request. Live-reload picks them up as well, which changes nothing.
+// The files of all changes are staged before any of them is moved into place, so that a
+// change of several package versions either applies completely or, if anything fails
+// while writing, not at all.
+func (pm *packageManager) apply(changes ...versionChange) error {
+  staged := make([][]string, len(changes))
+  for i, change := range changes {
+    var err error
+    if staged[i], err = change.stageFiles(); err != nil {
+      removeStagedFiles(staged[:i])
+      return err
+    }
+  }
+
+  // Only moving files into place and removing others is left, which hardly ever fails
+  var applied []versionChange
+  var err error
+  manifestFilesLock.Lock()
+  for i, change := range changes {
+    if err = change.commitFiles(staged[i]); err != nil {
+      removeStagedFiles(staged[i:])
+      break
+    }
+    applied = append(applied, change)
+  }
+  manifestFilesLock.Unlock()
+
+  for _, change := range applied {
+    pm.ingest(change.dir)
+
+    if len(change.files) > 0 {
+      logging.Logger.Info().Str("package", change.key.PackageIdentifier).Str("packageversion", change.key.PackageVersion).Msgf("wrote manifest to %v", change.dir)
+    } else {
+      logging.Logger.Info().Str("package", change.key.PackageIdentifier).Str("packageversion", change.key.PackageVersion).Msg("deleted manifest")
+      // Don't leave empty directories of deleted packages behind
+      for dir := change.dir; dir != pm.manifestPath && strings.HasPrefix(dir, pm.manifestPath); dir = filepath.Dir(dir) {
+        if os.Remove(dir) != nil {
+          break
+        }
+      }
+    }
+  }
+
+  if err != nil {
+    // Some of its files may have been moved or removed already
+    pm.ingest(changes[len(applied)].dir)
+  }
+  if err != nil && len(applied) > 0 {
+    var versions []string
+    for _, change := range applied {
+      versions = append(versions, change.key.PackageVersion)
+    }
+    logging.Logger.Error().Err(err).Str("package", changes[0].key.PackageIdentifier).Strs("applied", versions).Msg("changes were only partially applied")
+    return newManagementError(http.StatusInternalServerError, "the changes were only partially applied, versions %v of package %v were already changed", strings.Join(versions, ", "), changes[0].key.PackageIdentifier)
+  }
+  return err
+}
+
+// Writes the manifest files of a change next to where they belong and returns their
+// temporary paths. Hidden and without a .yaml extension, they are never ingested.
+func (change versionChange) stageFiles() ([]string, error) {
+  if len(change.files) > 0 {
+    if err := os.MkdirAll(change.dir, 0755); err != nil {
+      return nil, err
+    }
+  }
+  var staged []string
+  for _, file := range change.files {
+    temporaryFile := filepath.Join(change.dir, "." + filepath.Base(file.path) + ".tmp")
+    if err := os.WriteFile(temporaryFile, file.data, 0644); err != nil {
+      os.Remove(temporaryFile)
+      removeStagedFiles([][]string{staged})
+      return nil, err
+    }
+    staged = append(staged, temporaryFile)
+  }
+  return staged, nil
+}
+
+func removeStagedFiles(staged [][]string) {
+  for _, files := range staged {
+    for _, file := range files {
+      os.Remove(file)
+    }
+  }
+}
+
+// Moves the staged files of a change into place and removes the files that are obsolete
+// afterwards. Caller must hold manifestFilesLock.
+func (change versionChange) commitFiles(staged []string) error {
+  for i, temporaryFile := range staged {
+    if err := os.Rename(temporaryFile, change.files[i].path); err != nil {
+      return err
+    }
+  }
+  for _, file := range change.obsolete {
+    if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+      return err
+    }
+  }
+  return nil
+}
+
+// Renders a package version as a multi-file manifest with the same file names as in the
+// winget-pkgs repository. Properties that are empty are left out, as if they were not set.
+func renderManifestFiles(dir string, packageIdentifier string, version models.API_ManifestVersion_1_10_0) ([]manifestFile, error) {
+  var files []manifestFile
+  render := func(name string, manifestType string, properties ...*yaml.Node) error {
+    document := &yaml.Node{
+      Kind: yaml.MappingNode,
+      HeadComment: fmt.Sprintf("yaml-language-server: $schema=https://aka.ms/winget-manifest.%v.%v.schema.json", manifestType, managementSchemaVersion),
+    }
+    appendProperty(document, "PackageIdentifier", scalarNode(packageIdentifier))
+    appendProperty(document, "PackageVersion", scalarNode(version.PackageVersion))
+    for _, p := range properties {
+      document.Content = append(document.Content, p.Content...)
+    }
+    appendProperty(document, "ManifestType", scalarNode(manifestType))
+    appendProperty(document, "ManifestVersion", scalarNode(managementSchemaVersion))
+
+    var buffer bytes.Buffer
+    encoder := yaml.NewEncoder(&buffer)
+    encoder.SetIndent(2)
+    if err := encoder.Encode(document); err != nil {
+      return err
+    }
+    files = append(files, manifestFile{path: filepath.Join(dir, name), data: buffer.Bytes()})
+    return nil
+  }
+
+  versionProperties := &yaml.Node{Kind: yaml.MappingNode}
+  appendProperty(versionProperties, "DefaultLocale", scalarNode(version.DefaultLocale.PackageLocale))
+  if err := render(packageIdentifier + ".yaml", "version", versionProperties); err != nil {
+    return nil, err
+  }
+
+  installerProperties := &yaml.Node{Kind: yaml.MappingNode}
+  if version.Channel != "" {
+    appendProperty(installerProperties, "Channel", scalarNode(version.Channel))
+  }
+  installers := &yaml.Node{Kind: yaml.SequenceNode}
+  for _, installer := range version.Installers {
+    // Neither is part of the manifest schema
+    properties, err := toManifestProperties(installer, "InstallerIdentifier", "MSStoreProductIdentifier")
+    if err != nil {
+      return nil, err
+    }
+    installers.Content = append(installers.Content, properties)
+  }
+  appendProperty(installerProperties, "Installers", installers)
+  if err := render(packageIdentifier + ".installer.yaml", "installer", installerProperties); err != nil {
+    return nil, err
+  }
+
+  defaultLocaleProperties, err := toManifestProperties(version.DefaultLocale)
+  if err != nil {
+    return nil, err
+  }
+  if err := render(packageIdentifier + ".locale." + version.DefaultLocale.PackageLocale + ".yaml", "defaultLocale", defaultLocaleProperties); err != nil {
+    return nil, err
+  }
+
+  for _, locale := range version.Locales {
+    if !localePattern.MatchString(locale.PackageLocale) {
+      return nil, newManagementError(http.StatusBadRequest, "PackageLocale %q is not a valid locale", locale.PackageLocale)
+    }
+    localeProperties, err := toManifestProperties(locale)
+    if err != nil {
+      return nil, err
+    }
+    if err := render(packageIdentifier + ".locale." + locale.PackageLocale + ".yaml", "locale", localeProperties); err != nil {
+      return nil, err
+    }
+  }
+
+  return files, nil
+}
+
+// Parses rendered manifest files exactly like they will be ingested and checks them against the schema
+func checkManifestFiles(packageIdentifier string, files []manifestFile) error {
+  var nodes []models.ManifestNode
+  for _, file := range files {
+    basemanifests, err := decodeBaseManifests(bytes.NewReader(file.data), file.path)
+    if err != nil {
+      return err
+    }
+    for _, basemanifest := range basemanifests {
+      nodes = append(nodes, *basemanifest)
+    }
+  }
+
+  manifest, problems, err := parseMultiFileManifest(nodes...)
+  if err == nil && len(problems) == 0 {
+    problems = checkManifestSchema(parsedManifest{
+      packageIdentifier: packageIdentifier,
+      manifestVersion: managementSchemaVersion,
+      version: manifest.GetVersions()[0],
+      nodes: nodes,
+    })
+  }
+
+  var messages []string
+  for _, problem := range problems {
+    if problem.Error != "" {
+      messages = append(messages, problem.Message + ": " + problem.Error)
+    } else {
+      messages = append(messages, problem.Message)
+    }
+  }
+  if err != nil {
+    messages = append(messages, err.Error())
+  }
+  if len(messages) > 0 {
+    return newManagementError(http.StatusBadRequest, "invalid package version: %v", strings.Join(messages, "; "))
+  }
+  return nil
+}
+
+// Converts a struct of the API schema into a YAML mapping of the same properties without the
+// empty ones. Going through JSON keeps the property names and order of the struct fields.
+func toManifestProperties(v any, leaveOut ...string) (*yaml.Node, error) {
+  data, err := json.Marshal(v)
+  if err != nil {
+    return nil, err
+  }
+  var document yaml.Node
+  if err := yaml.Unmarshal(data, &document); err != nil {
+    return nil, err
+  }
+
+  properties := document.Content[0]
+  removeEmptyProperties(properties)
+  for i := 0; i + 1 < len(properties.Content); i += 2 {
+    if slices.Contains(leaveOut, properties.Content[i].Value) {
+      properties.Content = slices.Delete(properties.Content, i, i + 2)
+      i -= 2
+    }
+  }
+  return properties, nil
+}
+
+// Recursively removes properties that are null, empty or false from YAML mappings and resets
+// the JSON flow style to block style. Returns whether the node itself is empty.
+func removeEmptyProperties(node *yaml.Node) bool {
+  node.Style = 0
+  switch node.Kind {
+  case yaml.MappingNode:
+    var content []*yaml.Node
+    for i := 0; i + 1 < len(node.Content); i += 2 {
+      node.Content[i].Style = 0
+      if !removeEmptyProperties(node.Content[i+1]) {
+        content = append(content, node.Content[i], node.Content[i+1])
+      }
+    }
+    node.Content = content
+    return len(content) == 0
+  case yaml.SequenceNode:
+    for _, item := range node.Content {
+      removeEmptyProperties(item)
+    }
+    return len(node.Content) == 0
+  case yaml.ScalarNode:
+    return node.Tag == "!!null" || (node.Tag == "!!str" && node.Value == "") || (node.Tag == "!!bool" && node.Value == "false")
+  }
+  return false
+}
+
+func scalarNode(value string) *yaml.Node {
+  return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+func appendProperty(mapping *yaml.Node, key string, value *yaml.Node) {
+  mapping.Content = append(mapping.Content, scalarNode(key), value)
+}
+
+// Runs change with the lock held once all manifests are loaded and responds with its error if it fails
+func (pm *packageManager) change(w http.ResponseWriter, change func() error) bool {
+  if !pm.initialScanDone.Load() {
+    writeManagementError(w, newManagementError(http.StatusServiceUnavailable, "manifests are still being loaded, try again later"))
+    return false
+  }
+  pm.Lock()
+  defer pm.Unlock()
+  if err := change(); err != nil {
+    writeManagementError(w, err)
+    return false
+  }
+  return true
+}
+
+// Applies modify to a package version as its manifest files define it and writes the result
+func (pm *packageManager) modifyVersion(w http.ResponseWriter, r *http.Request, modify func(*models.API_ManifestVersion_1_10_0) error) (models.API_ManifestVersion_1_10_0, bool) {
+  packageIdentifier, packageVersion := r.PathValue("package_identifier"), r.PathValue("package_version")
+  var version models.API_ManifestVersion_1_10_0
+  ok := pm.change(w, func() error {
+    var err error
+    if version, err = readManagedVersion(packageIdentifier, packageVersion); err != nil {
+      return err
+    }
+    if err := modify(&version); err != nil {
+      return err
+    }
+    change, err := pm.prepareWrite(packageIdentifier, version)
+    if err != nil {
+      return err
+    }
+    if err := pm.apply(change); err != nil {
+      return err
+    }
+    version, err = getManagedVersion(packageIdentifier, packageVersion)
+    return err
+  })
+  return version, ok
+}
+
+// Packages only exist through their versions
+func (pm *packageManager) createPackage(w http.ResponseWriter, r *http.Request) {
+  var body models.API_Package
+  if err := decodeManagementRequest(w, r, &body); err != nil {
+    writeManagementError(w, err)
+    return
+  }
+  if len(models.Manifests.GetAllVersions(body.PackageIdentifier)) > 0 {
+    writeManagementError(w, newManagementError(http.StatusConflict, "package %v already exists", body.PackageIdentifier))
+    return
+  }
+  writeManagementError(w, newManagementError(
+    http.StatusBadRequest,
+    "packages are created with their first version, POST it to /api/packageManifests or /api/packages/%v/versions",
+    body.PackageIdentifier,
+  ))
+}
+
+func (pm *packageManager) getPackage(w http.ResponseWriter, r *http.Request) {
+  packageIdentifier := r.PathValue("package_identifier")
+  if len(models.Manifests.GetAllVersions(packageIdentifier)) == 0 {
+    writeManagementError(w, newManagementError(http.StatusNotFound, "package %v was not found", packageIdentifier))
+    return
+  }
+  writeManagementData(w, models.API_Package{PackageIdentifier: packageIdentifier})
+}
+
+// Deletes all versions of a package
+func (pm *packageManager) deletePackage(w http.ResponseWriter, r *http.Request) {
+  packageIdentifier := r.PathValue("package_identifier")
+  ok := pm.change(w, func() error {
+    versions := models.Manifests.GetAllVersions(packageIdentifier)
+    if len(versions) == 0 {
+      return newManagementError(http.StatusNotFound, "package %v was not found", packageIdentifier)
+    }
+    var changes []versionChange
+    for _, version := range versions {
+      change, err := pm.prepareDelete(models.ManifestKey{PackageIdentifier: packageIdentifier, PackageVersion: version.GetPackageVersion()})
+      if err != nil {
+        return err
+      }
+      changes = append(changes, change)
+    }
+    return pm.apply(changes...)
+  })
+  if ok {
+    w.WriteHeader(http.StatusNoContent)
+  }
+}
+
+func (pm *packageManager) getVersions(w http.ResponseWriter, r *http.Request) {
+  versions, err := getManagedVersions(r.PathValue("package_identifier"))
+  if err != nil {
+    writeManagementError(w, err)
+    return
+  }
+  writeManagementData(w, versions)
+}
+
+// Creates a package version, and the package if it doesn't exist yet
+func (pm *packageManager) createVersion(w http.ResponseWriter, r *http.Request) {
+  packageIdentifier := r.PathValue("package_identifier")
+  var body models.API_ManifestVersion_1_10_0
+  if err := decodeManagementRequest(w, r, &body); err != nil {
+    writeManagementError(w, err)
+    return
+  }
+  var version models.API_ManifestVersion_1_10_0
+  ok := pm.change(w, func() error {
+    if models.Manifests.Get(packageIdentifier, body.PackageVersion) != nil {
+      return newManagementError(http.StatusConflict, "version %v of package %v already exists", body.PackageVersion, packageIdentifier)
+    }
+    change, err := pm.prepareWrite(packageIdentifier, body)
+    if err != nil {
+      return err
+    }
+    if err := pm.apply(change); err != nil {
+      return err
+    }
+    version, err = getManagedVersion(packageIdentifier, body.PackageVersion)
+    return err
+  })
+  if ok {
+    writeManagementData(w, version)
+  }
+}
+
+func (pm *packageManager) getVersion(w http.ResponseWriter, r *http.Request) {
+  version, err := getManagedVersion(r.PathValue("package_identifier"), r.PathValue("package_version"))
+  if err != nil {
+    writeManagementError(w, err)
+    return
+  }
+  writeManagementData(w, version)
+}
+
+// Replaces the DefaultLocale and Channel of a package version, and its Locales and Installers if they are in the request
+func (pm *packageManager) updateVersion(w http.ResponseWriter, r *http.Request) {
+  var body models.API_ManifestVersion_1_10_0
+  if err := decodeManagementRequest(w, r, &body); err != nil {
+    writeManagementError(w, err)
+    return
+  }
+  version, ok := pm.modifyVersion(w, r, func(version *models.API_ManifestVersion_1_10_0) error {
+    if body.PackageVersion != "" && body.PackageVersion != version.PackageVersion {
+      return newManagementError(http.StatusBadRequest, "the PackageVersion cannot be changed, create a new version instead")
+    }
+    version.DefaultLocale = body.DefaultLocale
+    version.Channel = body.Channel
+    if body.Locales != nil {
+      version.Locales = body.Locales
+    }
+    if body.Installers != nil {
+      version.Installers = body.Installers
+    }
+    return nil
+  })
+  if ok {
+    writeManagementData(w, version)
+  }
+}
+
+func (pm *packageManager) deleteVersion(w http.ResponseWriter, r *http.Request) {
+  key := models.ManifestKey{PackageIdentifier: r.PathValue("package_identifier"), PackageVersion: r.PathValue("package_version")}
+  ok := pm.change(w, func() error {
+    if models.Manifests.Get(key.PackageIdentifier, key.PackageVersion) == nil {
+      return newManagementError(http.StatusNotFound, "version %v of package %v was not found", key.PackageVersion, key.PackageIdentifier)
+    }
+    change, err := pm.prepareDelete(key)
+    if err != nil {
+      return err
+    }
+    return pm.apply(change)
+  })
+  if ok {
+    w.WriteHeader(http.StatusNoContent)
+  }
+}
+
+func (pm *packageManager) getInstallers(w http.ResponseWriter, r *http.Request) {
+  version, err := getManagedVersion(r.PathValue("package_identifier"), r.PathValue("package_version"))
+  if err != nil {
+    writeManagementError(w, err)
+    return
+  }
+  writeManagementData(w, version.Installers)
+}
+
+func findInstaller(version models.API_ManifestVersion_1_10_0, installerIdentifier string) (int, error) {
+  i := slices.IndexFunc(version.Installers, func(installer models.API_Installer_1_10_0) bool {
+    return strings.EqualFold(installer.InstallerIdentifier, installerIdentifier)
+  })
+  if i < 0 {
+    return i, newManagementError(http.StatusNotFound, "installer %v of version %v was not found", installerIdentifier, version.PackageVersion)
+  }
+  return i, nil
+}
+
+func (pm *packageManager) createInstaller(w http.ResponseWriter, r *http.Request) {
+  var body models.API_Installer_1_10_0
+  if err := decodeManagementRequest(w, r, &body); err != nil {
+    writeManagementError(w, err)
+    return
+  }
+  version, ok := pm.modifyVersion(w, r, func(version *models.API_ManifestVersion_1_10_0) error {
+    if _, err := findInstaller(*version, body.InstallerIdentifier); body.InstallerIdentifier != "" && err == nil {
+      return newManagementError(http.StatusConflict, "installer %v already exists", body.InstallerIdentifier)
+    }
+    version.Installers = append(version.Installers, body)
+    return nil
+  })
+  if ok {
+    // Installers keep their order, the new one is always the last
+    writeManagementData(w, version.Installers[len(version.Installers)-1])
+  }
+}
+
+func (pm *packageManager) getInstaller(w http.ResponseWriter, r *http.Request) {
+  version, err := getManagedVersion(r.PathValue("package_identifier"), r.PathValue("package_version"))
+  if err != nil {
+    writeManagementError(w, err)
+    return
+  }
+  i, err := findInstaller(version, r.PathValue("installer_identifier"))
+  if err != nil {
+    writeManagementError(w, err)
+    return
+  }
+  writeManagementData(w, version.Installers[i])
+}
+
+// InstallerIdentifiers are derived from the installers, so they change with them
+func (pm *packageManager) updateInstaller(w http.ResponseWriter, r *http.Request) {
+  var body models.API_Installer_1_10_0
+  if err := decodeManagementRequest(w, r, &body); err != nil {
+    writeManagementError(w, err)
+    return
+  }
+  var i int
+  version, ok := pm.modifyVersion(w, r, func(version *models.API_ManifestVersion_1_10_0) error {
+    var err error
+    if i, err = findInstaller(*version, r.PathValue("installer_identifier")); err != nil {
+      return err
+    }
+    version.Installers[i] = body
+    return nil
+  })
+  if ok {
+    writeManagementData(w, version.Installers[i])
+  }
+}
+
+func (pm *packageManager) deleteInstaller(w http.ResponseWriter, r *http.Request) {
+  _, ok := pm.modifyVersion(w, r, func(version *models.API_ManifestVersion_1_10_0) error {
+    i, err := findInstaller(*version, r.PathValue("installer_identifier"))
+    if err != nil {
+      return err
+    }
+    if len(version.Installers) == 1 {
+      return newManagementError(http.StatusBadRequest, "a package version needs at least one installer, delete the version instead")
+    }
+    version.Installers = slices.Delete(version.Installers, i, i + 1)
+    return nil
+  })
+  if ok {
+    w.WriteHeader(http.StatusNoContent)
+  }
+}
+
+// The DefaultLocale is part of the version, these are only the additional locales
+func (pm *packageManager) getLocales(w http.ResponseWriter, r *http.Request) {
+  version, err := getManagedVersion(r.PathValue("package_identifier"), r.PathValue("package_version"))
+  if err != nil {
+    writeManagementError(w, err)
+    return
+  }
+  writeManagementData(w, version.Locales)
+}
+
+func findLocale(version models.API_ManifestVersion_1_10_0, packageLocale string) (int, error) {
+  i := slices.IndexFunc(version.Locales, func(locale models.API_Locale_1_10_0) bool {
+    return strings.EqualFold(locale.PackageLocale, packageLocale)
+  })
+  if i < 0 {
+    return i, newManagementError(http.StatusNotFound, "locale %v of version %v was not found", packageLocale, version.PackageVersion)
+  }
+  return i, nil
+}
+
+func (pm *packageManager) createLocale(w http.ResponseWriter, r *http.Request) {
+  var body models.API_Locale_1_10_0
+  if err := decodeManagementRequest(w, r, &body); err != nil {
+    writeManagementError(w, err)
+    return
+  }
+  version, ok := pm.modifyVersion(w, r, func(version *models.API_ManifestVersion_1_10_0) error {
+    if _, err := findLocale(*version, body.PackageLocale); err == nil || strings.EqualFold(version.DefaultLocale.PackageLocale, body.PackageLocale) {
+      return newManagementError(http.StatusConflict, "locale %v already exists", body.PackageLocale)
+    }
+    version.Locales = append(version.Locales, body)
+    return nil
+  })
+  if ok {
+    i, _ := findLocale(version, body.PackageLocale)
+    writeManagementData(w, version.Locales[i])
+  }
+}
+
+func (pm *packageManager) getLocale(w http.ResponseWriter, r *http.Request) {
+  version, err := getManagedVersion(r.PathValue("package_identifier"), r.PathValue("package_version"))
+  if err != nil {
+    writeManagementError(w, err)
+    return
+  }
+  i, err := findLocale(version, r.PathValue("package_locale"))
+  if err != nil {
+    writeManagementError(w, err)
+    return
+  }
+  writeManagementData(w, version.Locales[i])
+}
+
+func (pm *packageManager) updateLocale(w http.ResponseWriter, r *http.Request) {
+  var body models.API_Locale_1_10_0
+  if err := decodeManagementRequest(w, r, &body); err != nil {
+    writeManagementError(w, err)
+    return
+  }
+  packageLocale := r.PathValue("package_locale")
+  version, ok := pm.modifyVersion(w, r, func(version *models.API_ManifestVersion_1_10_0) error {
+    i, err := findLocale(*version, packageLocale)
+    if err != nil {
+      return err
+    }
+    if body.PackageLocale != "" && !strings.EqualFold(body.PackageLocale, packageLocale) {
+      return newManagementError(http.StatusBadRequest, "the PackageLocale cannot be changed, create a new locale instead")
+    }
+    body.PackageLocale = version.Locales[i].PackageLocale
+    version.Locales[i] = body
+    return nil
+  })
+  if ok {
+    i, _ := findLocale(version, packageLocale)
+    writeManagementData(w, version.Locales[i])
+  }
+}
+
+func (pm *packageManager) deleteLocale(w http.ResponseWriter, r *http.Request) {
+  _, ok := pm.modifyVersion(w, r, func(version *models.API_ManifestVersion_1_10_0) error {
+    i, err := findLocale(*version, r.PathValue("package_locale"))
+    if err != nil {
+      return err
+    }
+    version.Locales = slices.Delete(version.Locales, i, i + 1)
+    return nil
+  })
+  if ok {
+    w.WriteHeader(http.StatusNoContent)
+  }
+}
+
+// Prepares writing all versions of a package manifest, and deleting all other versions of the package
+func (pm *packageManager) preparePackageManifest(manifest managedPackageManifest) ([]versionChange, error) {
+  if len(manifest.Versions) == 0 {
+    return nil, newManagementError(http.StatusBadRequest, "a package needs at least one version")
+  }
+
+  var changes []versionChange
+  var written []string
+  for _, version := range manifest.Versions {
+    if slices.Contains(written, version.PackageVersion) {
+      return nil, newManagementError(http.StatusBadRequest, "version %v is in the request more than once", version.PackageVersion)
+    }
+    change, err := pm.prepareWrite(manifest.PackageIdentifier, version)
+    if err != nil {
+      return nil, err
+    }
+    changes = append(changes, change)
+    written = append(written, version.PackageVersion)
+  }
+
+  for _, stored := range models.Manifests.GetAllVersions(manifest.PackageIdentifier) {
+    if slices.Contains(written, stored.GetPackageVersion()) {
+      continue
+    }
+    change, err := pm.prepareDelete(models.ManifestKey{PackageIdentifier: manifest.PackageIdentifier, PackageVersion: stored.GetPackageVersion()})
+    if err != nil {
+      return nil, err
+    }
+    changes = append(changes, change)
+  }
+  return changes, nil
+}
+
+func (pm *packageManager) writePackageManifest(w http.ResponseWriter, body managedPackageManifest, create bool) {
+  var manifest managedPackageManifest
+  ok := pm.change(w, func() error {
+    if create && len(models.Manifests.GetAllVersions(body.PackageIdentifier)) > 0 {
+      return newManagementError(http.StatusConflict, "package %v already exists", body.PackageIdentifier)
+    }
+    if !create && len(models.Manifests.GetAllVersions(body.PackageIdentifier)) == 0 {
+      return newManagementError(http.StatusNotFound, "package %v was not found", body.PackageIdentifier)
+    }
+    changes, err := pm.preparePackageManifest(body)
+    if err != nil {
+      return err
+    }
+    if err := pm.apply(changes...); err != nil {
+      return err
+    }
+    manifest.PackageIdentifier = body.PackageIdentifier
+    manifest.Versions, err = getManagedVersions(body.PackageIdentifier)
+    return err
+  })
+  if ok {
+    writeManagementData(w, manifest)
+  }
+}
+
+func (pm *packageManager) createPackageManifest(w http.ResponseWriter, r *http.Request) {
+  var body managedPackageManifest
+  if err := decodeManagementRequest(w, r, &body); err != nil {
+    writeManagementError(w, err)
+    return
+  }
+  pm.writePackageManifest(w, body, true)
+}
+
+// Replaces all versions of a package with the ones in the request
+func (pm *packageManager) updatePackageManifest(w http.ResponseWriter, r *http.Request) {
+  var body managedPackageManifest
+  if err := decodeManagementRequest(w, r, &body); err != nil {
+    writeManagementError(w, err)
+    return
+  }
+  if body.PackageIdentifier != "" && body.PackageIdentifier != r.PathValue("package_identifier") {
+    writeManagementError(w, newManagementError(http.StatusBadRequest, "the PackageIdentifier cannot be changed, create a new package instead"))
+    return
+  }
+  body.PackageIdentifier = r.PathValue("package_identifier")
+  pm.writePackageManifest(w, body, false)
+}
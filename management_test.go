@@ -0,0 +1,384 @@
+package main
+
+import (
+  "os"
+  "io"
+  "bytes"
+  "strings"
+  "testing"
+  "net/http"
+  "net/http/httptest"
+  "path/filepath"
+  "encoding/json"
+
+  "rewinged/installerinfo"
+  "rewinged/models"
+)
+
+// A package manager for a temporary manifestPath, with its routes served by a test server
+type managementTest struct {
+  server *httptest.Server
+  router *http.ServeMux
+  packages *packageManager
+  manifestPath string
+  installerPath string
+}
+
+func newManagementTest(t *testing.T, autoInternalize bool) *managementTest {
+  test := &managementTest{manifestPath: t.TempDir(), installerPath: t.TempDir()}
+  test.packages = newPackageManager(test.manifestPath, func(dir string) {
+    ingestManifestDirectory(dir, autoInternalize, test.installerPath, nil)
+  })
+  test.packages.setInitialScanDone()
+  test.router = http.NewServeMux()
+  test.packages.registerRoutes(test.router, func(h http.Handler) http.Handler { return h })
+  test.server = httptest.NewServer(test.router)
+  t.Cleanup(func() {
+    test.server.Close()
+    // The ManifestsStore is shared by all tests
+    models.Manifests.RemoveSourcesBelow(test.manifestPath)
+  })
+  return test
+}
+
+// Sends a request with body encoded as JSON, unless it already is a string, and returns the status code and response body
+func (test *managementTest) request(t *testing.T, method string, path string, body any) (int, []byte) {
+  var reader io.Reader
+  switch body := body.(type) {
+  case nil:
+  case string:
+    reader = strings.NewReader(body)
+  default:
+    data, err := json.Marshal(body)
+    if err != nil {
+      t.Fatal(err)
+    }
+    reader = bytes.NewReader(data)
+  }
+  r, err := http.NewRequest(method, test.server.URL + path, reader)
+  if err != nil {
+    t.Fatal(err)
+  }
+  resp, err := http.DefaultClient.Do(r)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer resp.Body.Close()
+  data, err := io.ReadAll(resp.Body)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return resp.StatusCode, data
+}
+
+// Sends a request and decodes the Data of the response into v, failing unless the response has the status code
+func (test *managementTest) expect(t *testing.T, method string, path string, body any, status int, v any) {
+  t.Helper()
+  got, data := test.request(t, method, path, body)
+  if got != status {
+    t.Fatalf("%v %v: got status %v, want %v: %s", method, path, got, status, data)
+  }
+  if v != nil {
+    if err := json.Unmarshal(data, &struct{ Data any }{v}); err != nil {
+      t.Fatalf("%v %v: %v: %s", method, path, err, data)
+    }
+  }
+}
+
+func (test *managementTest) versionDir(packageIdentifier string, packageVersion string) string {
+  segments := []string{test.manifestPath, strings.ToLower(packageIdentifier[:1])}
+  segments = append(segments, strings.Split(packageIdentifier, ".")...)
+  return filepath.Join(append(segments, packageVersion)...)
+}
+
+func readFile(t *testing.T, path string) string {
+  t.Helper()
+  data, err := os.ReadFile(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return string(data)
+}
+
+func testVersion(packageVersion string, installerSha256 string) models.API_ManifestVersion_1_10_0 {
+  var version models.API_ManifestVersion_1_10_0
+  version.PackageVersion = packageVersion
+  version.DefaultLocale.PackageLocale = "en-US"
+  version.DefaultLocale.Publisher = "Contoso"
+  version.DefaultLocale.PackageName = "Contoso App"
+  version.DefaultLocale.License = "MIT"
+  version.DefaultLocale.ShortDescription = "The Contoso app"
+  version.Installers = []models.API_Installer_1_10_0{{
+    Architecture: "x64",
+    InstallerType: "exe",
+    InstallerUrl: "https://example.org/contoso.exe",
+    InstallerSha256: installerSha256,
+  }}
+  return version
+}
+
+// The metadata of internalized installers is filled into the loaded package versions, but it
+// must not be written to the manifest files when a package version is changed
+func TestUpdateLocaleKeepsInstallerManifest(t *testing.T) {
+  test := newManagementTest(t, true)
+  installerSha256 := strings.Repeat("ab", 32)
+  installerFile := filepath.Join(test.installerPath, installerSha256)
+  if err := os.WriteFile(installerFile, []byte("MZ"), 0644); err != nil {
+    t.Fatal(err)
+  }
+  models.InternalizedInstallers.Set(models.InternalizedInstaller{
+    InstallerSha256: installerSha256,
+    Path: installerFile,
+    Metadata: &installerinfo.Metadata{ProductCode: "{11111111-2222-3333-4444-555555555555}", UpgradeCode: "{AAAAAAAA-BBBB-CCCC-DDDD-EEEEEEEEEEEE}"},
+  })
+  t.Cleanup(func() { models.InternalizedInstallers.Remove(installerSha256) })
+
+  version := testVersion("1.0.0", installerSha256)
+  version.Locales = []models.API_Locale_1_10_0{{PackageLocale: "de-DE", ShortDescription: "Die Contoso App"}}
+  test.expect(t, "POST", "/api/packages/Contoso.Metadata/versions", version, http.StatusOK, nil)
+  installerManifest := filepath.Join(test.versionDir("Contoso.Metadata", "1.0.0"), "Contoso.Metadata.installer.yaml")
+  written := readFile(t, installerManifest)
+
+  var installer models.API_Installer_1_10_0
+  test.expect(t, "GET", "/api/packages/Contoso.Metadata/versions/1.0.0/installers/x64-exe", nil, http.StatusOK, &installer)
+  if installer.ProductCode != "{11111111-2222-3333-4444-555555555555}" || len(installer.AppsAndFeaturesEntries) != 1 {
+    t.Fatalf("the metadata of the internalized installer wasn't filled in: %+v", installer)
+  }
+
+  var locale models.API_Locale_1_10_0
+  test.expect(t, "PUT", "/api/packages/Contoso.Metadata/versions/1.0.0/locales/de-DE", models.API_Locale_1_10_0{ShortDescription: "Die neue Contoso App"}, http.StatusOK, &locale)
+  if locale.ShortDescription != "Die neue Contoso App" {
+    t.Errorf("got locale %+v", locale)
+  }
+  if got := readFile(t, installerManifest); got != written {
+    t.Errorf("the installer manifest changed from\n%v\nto\n%v", written, got)
+  }
+}
+
+func TestManagePackage(t *testing.T) {
+  test := newManagementTest(t, false)
+  dir := test.versionDir("Contoso.Lifecycle", "1.0.0")
+
+  var manifest managedPackageManifest
+  test.expect(t, "POST", "/api/packageManifests", managedPackageManifest{
+    PackageIdentifier: "Contoso.Lifecycle",
+    Versions: []models.API_ManifestVersion_1_10_0{testVersion("1.0.0", strings.Repeat("1", 64))},
+  }, http.StatusOK, &manifest)
+  if len(manifest.Versions) != 1 || manifest.Versions[0].Installers[0].InstallerIdentifier != "x64-exe" {
+    t.Fatalf("got %+v", manifest)
+  }
+  for _, name := range []string{"Contoso.Lifecycle.yaml", "Contoso.Lifecycle.installer.yaml", "Contoso.Lifecycle.locale.en-US.yaml"} {
+    if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+      t.Errorf("%v was not written: %v", name, err)
+    }
+  }
+
+  // Everything but the PackageVersion can be changed
+  update := testVersion("", "")
+  update.DefaultLocale.ShortDescription = "The new Contoso app"
+  update.Installers = nil
+  var version models.API_ManifestVersion_1_10_0
+  test.expect(t, "PUT", "/api/packages/Contoso.Lifecycle/versions/1.0.0", update, http.StatusOK, &version)
+  if version.DefaultLocale.ShortDescription != "The new Contoso app" || len(version.Installers) != 1 {
+    t.Errorf("got %+v", version)
+  }
+  if !strings.Contains(readFile(t, filepath.Join(dir, "Contoso.Lifecycle.locale.en-US.yaml")), "ShortDescription: The new Contoso app") {
+    t.Errorf("the default locale manifest wasn't updated")
+  }
+
+  var installer models.API_Installer_1_10_0
+  test.expect(t, "POST", "/api/packages/Contoso.Lifecycle/versions/1.0.0/installers", models.API_Installer_1_10_0{
+    Architecture: "arm64",
+    InstallerType: "exe",
+    InstallerUrl: "https://example.org/contoso-arm64.exe",
+    InstallerSha256: strings.Repeat("2", 64),
+  }, http.StatusOK, &installer)
+  if installer.InstallerIdentifier != "arm64-exe" {
+    t.Errorf("got installer %+v", installer)
+  }
+  test.expect(t, "DELETE", "/api/packages/Contoso.Lifecycle/versions/1.0.0/installers/x64-exe", nil, http.StatusNoContent, nil)
+  var installers []models.API_Installer_1_10_0
+  test.expect(t, "GET", "/api/packages/Contoso.Lifecycle/versions/1.0.0/installers", nil, http.StatusOK, &installers)
+  if len(installers) != 1 || installers[0].Architecture != "arm64" {
+    t.Errorf("got installers %+v", installers)
+  }
+
+  test.expect(t, "POST", "/api/packages/Contoso.Lifecycle/versions", testVersion("2.0.0", strings.Repeat("3", 64)), http.StatusOK, nil)
+  var versions []models.API_ManifestVersion_1_10_0
+  test.expect(t, "GET", "/api/packages/Contoso.Lifecycle/versions", nil, http.StatusOK, &versions)
+  if len(versions) != 2 || versions[0].PackageVersion != "2.0.0" {
+    t.Errorf("got versions %+v", versions)
+  }
+
+  test.expect(t, "DELETE", "/api/packages/Contoso.Lifecycle/versions/1.0.0", nil, http.StatusNoContent, nil)
+  if _, err := os.Stat(dir); !os.IsNotExist(err) {
+    t.Errorf("the directory of the deleted version is still there: %v", err)
+  }
+  test.expect(t, "DELETE", "/api/packageManifests/Contoso.Lifecycle", nil, http.StatusNoContent, nil)
+  test.expect(t, "GET", "/api/packages/Contoso.Lifecycle", nil, http.StatusNotFound, nil)
+  // Not even the directories of the package are left behind
+  if entries, err := os.ReadDir(test.manifestPath); err != nil || len(entries) != 0 {
+    t.Errorf("the manifestPath isn't empty: %v, %v", entries, err)
+  }
+}
+
+// Replacing a package manifest writes the versions in the request and deletes all others
+func TestUpdatePackageManifest(t *testing.T) {
+  test := newManagementTest(t, false)
+  test.expect(t, "POST", "/api/packageManifests", managedPackageManifest{
+    PackageIdentifier: "Contoso.Replace",
+    Versions: []models.API_ManifestVersion_1_10_0{testVersion("1.0.0", strings.Repeat("1", 64)), testVersion("2.0.0", strings.Repeat("2", 64))},
+  }, http.StatusOK, nil)
+
+  var manifest managedPackageManifest
+  test.expect(t, "PUT", "/api/packageManifests/Contoso.Replace", managedPackageManifest{
+    Versions: []models.API_ManifestVersion_1_10_0{testVersion("2.0.0", strings.Repeat("4", 64)), testVersion("3.0.0", strings.Repeat("3", 64))},
+  }, http.StatusOK, &manifest)
+  var got []string
+  for _, version := range manifest.Versions {
+    got = append(got, version.PackageVersion + ":" + version.Installers[0].InstallerSha256)
+  }
+  if want := []string{"3.0.0:" + strings.Repeat("3", 64), "2.0.0:" + strings.Repeat("4", 64)}; strings.Join(got, " ") != strings.Join(want, " ") {
+    t.Errorf("got versions %v, want %v", got, want)
+  }
+  if _, err := os.Stat(test.versionDir("Contoso.Replace", "1.0.0")); !os.IsNotExist(err) {
+    t.Errorf("version 1.0.0 wasn't deleted: %v", err)
+  }
+}
+
+// If any of the versions cannot be written, none of them is changed
+func TestUpdatePackageManifestRollsBack(t *testing.T) {
+  test := newManagementTest(t, false)
+  test.expect(t, "POST", "/api/packageManifests", managedPackageManifest{
+    PackageIdentifier: "Contoso.Rollback",
+    Versions: []models.API_ManifestVersion_1_10_0{testVersion("1.0.0", strings.Repeat("1", 64))},
+  }, http.StatusOK, nil)
+  dir := test.versionDir("Contoso.Rollback", "1.0.0")
+  before := readFile(t, filepath.Join(dir, "Contoso.Rollback.installer.yaml"))
+
+  // The directory of version 2.0.0 cannot be created where a file is in the way
+  if err := os.WriteFile(test.versionDir("Contoso.Rollback", "2.0.0"), nil, 0644); err != nil {
+    t.Fatal(err)
+  }
+  test.expect(t, "PUT", "/api/packageManifests/Contoso.Rollback", managedPackageManifest{
+    Versions: []models.API_ManifestVersion_1_10_0{testVersion("1.0.0", strings.Repeat("5", 64)), testVersion("2.0.0", strings.Repeat("2", 64))},
+  }, http.StatusInternalServerError, nil)
+
+  if after := readFile(t, filepath.Join(dir, "Contoso.Rollback.installer.yaml")); after != before {
+    t.Errorf("version 1.0.0 was changed to\n%v", after)
+  }
+  entries, err := os.ReadDir(dir)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(entries) != 3 {
+    t.Errorf("staged files were left behind: %v", entries)
+  }
+  var version models.API_ManifestVersion_1_10_0
+  test.expect(t, "GET", "/api/packages/Contoso.Rollback/versions/1.0.0", nil, http.StatusOK, &version)
+  if version.Installers[0].InstallerSha256 != strings.Repeat("1", 64) {
+    t.Errorf("the loaded version changed to %+v", version)
+  }
+}
+
+func TestManagementErrors(t *testing.T) {
+  test := newManagementTest(t, false)
+  test.expect(t, "POST", "/api/packageManifests", managedPackageManifest{
+    PackageIdentifier: "Contoso.Errors",
+    Versions: []models.API_ManifestVersion_1_10_0{testVersion("1.0.0", strings.Repeat("1", 64))},
+  }, http.StatusOK, nil)
+
+  // A hand-written file that defines two versions cannot be changed through the API
+  shared := filepath.Join(test.manifestPath, "shared", "Contoso.Shared.yaml")
+  if err := os.MkdirAll(filepath.Dir(shared), 0755); err != nil {
+    t.Fatal(err)
+  }
+  var documents []string
+  for _, packageVersion := range []string{"1.0.0", "2.0.0"} {
+    documents = append(documents, `PackageIdentifier: Contoso.Shared
+PackageVersion: ` + packageVersion + `
+PackageLocale: en-US
+Publisher: Contoso
+PackageName: Contoso Shared
+License: MIT
+ShortDescription: Two versions in one file
+Installers:
+  - Architecture: x64
+    InstallerType: exe
+    InstallerUrl: https://example.org/shared.exe
+    InstallerSha256: ` + strings.Repeat("6", 64) + `
+ManifestType: singleton
+ManifestVersion: 1.10.0
+`)
+  }
+  if err := os.WriteFile(shared, []byte(strings.Join(documents, "---\n")), 0644); err != nil {
+    t.Fatal(err)
+  }
+  ingestManifestDirectory(filepath.Dir(shared), false, "", nil)
+
+  tooLarge := `{"PackageIdentifier": "Contoso.Large", "Versions": [], "Padding": "` + strings.Repeat("x", maxManagementRequestSize) + `"}`
+  tests := []struct {
+    name string
+    method string
+    path string
+    body any
+    want int
+  }{
+    {"unknown package", "GET", "/api/packages/Contoso.Missing", nil, http.StatusNotFound},
+    {"unknown version", "GET", "/api/packages/Contoso.Errors/versions/9.9.9", nil, http.StatusNotFound},
+    {"update of an unknown version", "PUT", "/api/packages/Contoso.Errors/versions/9.9.9", testVersion("", ""), http.StatusNotFound},
+    {"unknown installer", "DELETE", "/api/packages/Contoso.Errors/versions/1.0.0/installers/arm64-msi", nil, http.StatusNotFound},
+    {"unknown locale", "PUT", "/api/packages/Contoso.Errors/versions/1.0.0/locales/fr-FR", models.API_Locale_1_10_0{}, http.StatusNotFound},
+    {"replacement of an unknown package", "PUT", "/api/packageManifests/Contoso.Missing", managedPackageManifest{Versions: []models.API_ManifestVersion_1_10_0{testVersion("1.0.0", strings.Repeat("1", 64))}}, http.StatusNotFound},
+    {"deletion of an unknown package", "DELETE", "/api/packageManifests/Contoso.Missing", nil, http.StatusNotFound},
+
+    {"existing package", "POST", "/api/packageManifests", managedPackageManifest{PackageIdentifier: "Contoso.Errors", Versions: []models.API_ManifestVersion_1_10_0{testVersion("2.0.0", strings.Repeat("2", 64))}}, http.StatusConflict},
+    {"existing version", "POST", "/api/packages/Contoso.Errors/versions", testVersion("1.0.0", strings.Repeat("2", 64)), http.StatusConflict},
+    {"existing installer", "POST", "/api/packages/Contoso.Errors/versions/1.0.0/installers", models.API_Installer_1_10_0{InstallerIdentifier: "x64-exe"}, http.StatusConflict},
+    {"existing locale", "POST", "/api/packages/Contoso.Errors/versions/1.0.0/locales", models.API_Locale_1_10_0{PackageLocale: "en-US"}, http.StatusConflict},
+    {"version defined together with another one", "DELETE", "/api/packages/Contoso.Shared/versions/1.0.0", nil, http.StatusConflict},
+
+    {"unknown property", "POST", "/api/packages/Contoso.Errors/versions", `{"PackageVersion": "3.0.0", "Unknown": true}`, http.StatusBadRequest},
+    {"invalid manifest", "POST", "/api/packages/Contoso.Errors/versions", testVersion("3.0.0", "not a hash"), http.StatusBadRequest},
+    {"changed PackageVersion", "PUT", "/api/packages/Contoso.Errors/versions/1.0.0", testVersion("3.0.0", ""), http.StatusBadRequest},
+    {"deletion of the last installer", "DELETE", "/api/packages/Contoso.Errors/versions/1.0.0/installers/x64-exe", nil, http.StatusBadRequest},
+    {"PackageIdentifier that isn't a directory name", "POST", "/api/packages/Contoso.Errors..%2F/versions", testVersion("3.0.0", strings.Repeat("3", 64)), http.StatusBadRequest},
+    {"PackageVersion that isn't a directory name", "POST", "/api/packages/Contoso.Errors/versions", testVersion("..", strings.Repeat("3", 64)), http.StatusBadRequest},
+    {"package without versions", "POST", "/api/packages", models.API_Package{PackageIdentifier: "Contoso.Empty"}, http.StatusBadRequest},
+    {"request body too large", "POST", "/api/packageManifests", tooLarge, http.StatusRequestEntityTooLarge},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      test.expect(t, tt.method, tt.path, tt.body, tt.want, nil)
+    })
+  }
+
+  // None of it changed anything
+  var versions []models.API_ManifestVersion_1_10_0
+  test.expect(t, "GET", "/api/packages/Contoso.Errors/versions", nil, http.StatusOK, &versions)
+  if len(versions) != 1 || len(versions[0].Installers) != 1 || len(versions[0].Locales) != 0 {
+    t.Errorf("got versions %+v", versions)
+  }
+  if _, err := os.Stat(shared); err != nil {
+    t.Errorf("the shared manifest file was changed: %v", err)
+  }
+}
+
+func TestAssignInstallerIdentifiers(t *testing.T) {
+  installers := []models.API_Installer_1_10_0{
+    {Architecture: "x64", InstallerType: "msi"},
+    {Architecture: "x64", InstallerType: "msi", Scope: "machine"},
+    {Architecture: "x64", InstallerType: "msi", Scope: "machine", InstallerLocale: "de-DE"},
+    {Architecture: "x64", InstallerType: "msi"},
+    {Architecture: "X86", InstallerType: "EXE"},
+    {},
+    {},
+  }
+  want := []string{"x64-msi", "x64-msi-machine", "x64-msi-machine-de-de", "x64-msi-2", "x86-exe", "installer", "installer-2"}
+  assignInstallerIdentifiers(installers)
+  for i, installer := range installers {
+    if installer.InstallerIdentifier != want[i] {
+      t.Errorf("installer %v got InstallerIdentifier %q, want %q", i, installer.InstallerIdentifier, want[i])
+    }
+  }
+}
@@ -2,138 +2,283 @@ package main
 
 import (
   "os"
+  "fmt"
   "errors"
   "slices"
+  "sync"
   "strings"
   "io"
   "io/fs"
   "net/url"
-  "net/http"
   "path/filepath"
+  "crypto/sha256"
+  "encoding/hex"
 
   "gopkg.in/yaml.v3"
 
   "rewinged/logging"
+  "rewinged/metrics"
   "rewinged/models"
 )
 
 func ingestManifestsWorker(autoInternalize bool, autoInternalizePath string, autoInternalizeSkipHosts []string) error {
   for path := range jobs {
-    files, err := os.ReadDir(path)
+    ingestManifestDirectory(path, autoInternalize, autoInternalizePath, autoInternalizeSkipHosts)
+    wg.Done()
+  }
+
+  return nil
+}
+
+// Held for writing while manifest files are changed through the management API, so
+// that live-reload never ingests a package version whose files are half-written
+var manifestFilesLock sync.RWMutex
+
+// Parses the manifest files directly in a directory and brings the ManifestsStore up to date with them
+func ingestManifestDirectory(path string, autoInternalize bool, autoInternalizePath string, autoInternalizeSkipHosts []string) {
+  manifestFilesLock.RLock()
+  manifests, problems, err := parseManifestDirectory(path)
+  manifestFilesLock.RUnlock()
+  if err != nil {
+    if errors.Is(err, fs.ErrNotExist) {
+      // The directory was removed or renamed since the job was queued
+      for _, key := range models.Manifests.RemoveSourcesBelow(path) {
+        logging.Logger.Info().Str("package", key.PackageIdentifier).Str("packageversion", key.PackageVersion).Msg("removed manifest")
+      }
+    } else {
+      logging.Logger.Error().Err(err).Str("directory", path).Msg("cannot read manifest directory")
+      metrics.ManifestIngestErrors.Inc()
+    }
+    return
+  }
+
+  for _, problem := range problems {
+    problem.log()
+    metrics.ManifestIngestErrors.Inc()
+  }
+
+  // all package versions that were (re-)ingested from this directory
+  var ingested = make(map[models.ManifestKey]bool)
+
+  for _, manifest := range manifests {
+    var version = manifest.version
+    var packageVersion = version.GetPackageVersion()
+
+    // Internalization logic
+    if (autoInternalize) {
+      // The manifest keeps its InstallerUrls, they are only rewritten when it is served
+      internalizeInstallers(manifest.packageIdentifier, packageVersion, version.GetInstallers(), autoInternalizePath, autoInternalizeSkipHosts)
+      for _, problem := range checkInstallerMetadata(manifest, internalizedMetadata) {
+        problem.log()
+      }
+      version = applyInstallerMetadata(version)
+    }
+    // End internalization logic
+
+    // Replace the existing PkgId + PkgVersion entry with this one
+    models.Manifests.Set(manifest.packageIdentifier, packageVersion, version, sourceFiles(manifest.nodes)...)
+    ingested[models.ManifestKey{PackageIdentifier: manifest.packageIdentifier, PackageVersion: packageVersion}] = true
+  }
+
+  // Anything that was previously ingested from this directory but wasn't found in it
+  // this time had its manifest file(s) deleted, renamed or changed to a different package.
+  for _, key := range models.Manifests.PruneDirectory(path, ingested) {
+    logging.Logger.Info().Str("package", key.PackageIdentifier).Str("packageversion", key.PackageVersion).Msg("removed manifest")
+  }
+}
+
+// One package version parsed from the manifest file(s) in a directory
+type parsedManifest struct {
+  packageIdentifier string
+  manifestVersion string
+  version models.API_ManifestVersionInterface
+  // The YAML documents the package version was parsed from
+  nodes []models.ManifestNode
+}
+
+// A manifestProblem is a reason why a manifest file or package version cannot be served, or,
+// as a warning, something that rewinged serves regardless but that violates the manifest schema.
+type manifestProblem struct {
+  Severity string `json:"severity"`
+  File string `json:"file"`
+  Line int `json:"line,omitempty"`
+  PackageIdentifier string `json:"packageIdentifier,omitempty"`
+  PackageVersion string `json:"packageVersion,omitempty"`
+  Message string `json:"message"`
+  Error string `json:"error,omitempty"`
+}
+
+const (
+  severityError = "error"
+  severityWarning = "warning"
+)
+
+func (p manifestProblem) log() {
+  event := logging.Logger.Error()
+  if p.Severity == severityWarning {
+    event = logging.Logger.Warn()
+  }
+  if p.Error != "" {
+    event = event.Str("error", p.Error)
+  }
+  if p.PackageIdentifier != "" {
+    event = event.Str("package", p.PackageIdentifier).Str("packageversion", p.PackageVersion)
+  }
+  if p.Line > 0 {
+    event = event.Int("line", p.Line)
+  }
+  event.Str("file", p.File).Msg(p.Message)
+}
+
+// Parses all manifest files directly in a directory (not its subdirectories) into package
+// versions. Files and package versions that cannot be parsed are returned as problems.
+func parseManifestDirectory(path string) ([]parsedManifest, []manifestProblem, error) {
+  files, err := os.ReadDir(path)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  var manifests []parsedManifest
+  var problems []manifestProblem
+  // temporary map collecting all files belonging to a particular package
+  var nonSingletonsMap = make(map[models.MultiFileManifest][]models.ManifestNode)
+
+  for _, file := range files {
+    if file.IsDir() || !(caseInsensitiveHasSuffix(file.Name(), ".yml") || caseInsensitiveHasSuffix(file.Name(), ".yaml")) {
+      continue
+    }
+    var filePath = filepath.Join(path, file.Name())
+    var basemanifests, err = parseFileAsBaseManifests(filePath)
     if err != nil {
-      logging.Logger.Error().Err(err).Msg("ingestManifestsWorker error")
-      wg.Done()
+      problems = append(problems, manifestProblem{
+        Severity: severityError,
+        File: filePath,
+        Message: "cannot unmarshal YAML file as BaseManifest",
+        Error: err.Error(),
+      })
       continue
     }
 
-    // temporary map collecting all files belonging to a particular package
-    var nonSingletonsMap = make(map[models.MultiFileManifest][]models.ManifestNode)
-
-    for _, file := range files {
-      if !file.IsDir() {
-        if caseInsensitiveHasSuffix(file.Name(), ".yml") || caseInsensitiveHasSuffix(file.Name(), ".yaml") {
-          var basemanifests, err = parseFileAsBaseManifests(filepath.Join(path, file.Name()))
-          if err != nil {
-            logging.Logger.Error().Err(err).Str("file", filepath.Join(path, file.Name())).Msgf("cannot unmarshal YAML file as BaseManifest")
-            continue
-          }
-
-          for _, basemanifest := range basemanifests {
-            // There could be other, non winget-manifest YAML files/documents in the manifestPath as well. Skip them.
-            // All valid manifests must have all basemanifest fields set as they are required by the schema
-            if basemanifest.PackageIdentifier != "" && basemanifest.PackageVersion != "" &&
-              basemanifest.ManifestType != "" && basemanifest.ManifestVersion != "" {
-              if basemanifest.ManifestType == "singleton" {
-                logging.Logger.Debug().Str("package", basemanifest.PackageIdentifier).Str("packageversion", basemanifest.PackageVersion).Msgf("found singleton manifest")
-                manifest, err := parseNodeAsSingletonManifest(basemanifest.ManifestVersion, basemanifest.Node)
-                if err != nil {
-                  logging.Logger.Error().Err(err).Str("package", basemanifest.PackageIdentifier).Str("packageversion", basemanifest.PackageVersion).Msg("could not parse singleton manifest")
-                } else {
-                  // Singleton manifests can only contain version of a package each
-                  var version = manifest.GetVersions()[0]
-
-                  // Internalization logic
-                  if (autoInternalize) {
-                    var installers []models.API_InstallerInterface = version.GetInstallers()
-
-                    internalizeInstallers(basemanifest.PackageIdentifier, basemanifest.PackageVersion, installers, autoInternalizePath, autoInternalizeSkipHosts)
-
-                    // Recreate manifest object, but with overwritten values (InstallerUrl(s))
-                    manifest, err = newAPIManifest(
-                      basemanifest.ManifestVersion,
-                      basemanifest.PackageIdentifier,
-                      basemanifest.PackageVersion,
-                      version.GetDefaultLocale(),
-                      version.GetLocales(),
-                      installers,
-                    )
-
-                    if err != nil {
-                      logging.Logger.Error().Str("package", basemanifest.PackageIdentifier).Str("packageversion", basemanifest.PackageVersion).Msgf("error reconstructing package after overwrite")
-                    }
-
-                    version = manifest.GetVersions()[0]
-                  }
-                  // End internalization logic
-
-                  models.Manifests.Set(manifest.GetPackageIdentifier(), basemanifest.PackageVersion, version)
-                }
-              } else if basemanifest.ManifestType == "merged" {
-                logging.Logger.Error().Str("package", basemanifest.PackageIdentifier).Str("packageversion", basemanifest.PackageVersion).Msgf("merged manifests are not yet supported")
-              } else {
-                nonSingletonsMap[basemanifest.ToMultiFileManifest()] = append(nonSingletonsMap[basemanifest.ToMultiFileManifest()], *basemanifest)
-              }
-            } else {
-              logging.Logger.Debug().Err(err).Str("file", filepath.Join(path, file.Name())).Int("line", basemanifest.Node.Line).Msg("YAML document is not a package manifest")
-            }
-          }
-        }
+    for _, basemanifest := range basemanifests {
+      // There could be other, non winget-manifest YAML files/documents in the manifestPath as well. Skip them.
+      // All valid manifests must have all basemanifest fields set as they are required by the schema,
+      // so documents with only some of them are broken manifests rather than something else.
+      missingFields := missingBaseManifestFields(basemanifest.BaseManifest)
+      if len(missingFields) == 4 {
+        logging.Logger.Debug().Str("file", filePath).Int("line", basemanifest.Node.Line).Msg("YAML document is not a package manifest")
+        continue
+      }
+      if len(missingFields) > 0 {
+        problems = append(problems, manifestProblem{
+          Severity: severityError,
+          File: filePath,
+          Line: basemanifest.Node.Line,
+          PackageIdentifier: basemanifest.PackageIdentifier,
+          PackageVersion: basemanifest.PackageVersion,
+          Message: "manifest is missing required fields " + strings.Join(missingFields, ", "),
+        })
+        continue
       }
-    }
 
-    if len(nonSingletonsMap) > 0 {
-      for key, value := range nonSingletonsMap {
-        logging.Logger.Debug().Str("package", key.PackageIdentifier).Str("packageversion", key.PackageVersion).Msgf("found multi-file manifest")
-        var mergedManifest, err = parseMultiFileManifest(value...)
-        if err != nil {
-          logging.Logger.Error().Err(err).Str("package", key.PackageIdentifier).Str("packageversion", key.PackageVersion).Msgf("could not parse all manifest files for this package")
+      switch basemanifest.ManifestType {
+      case "singleton", "merged":
+        logging.Logger.Debug().Str("package", basemanifest.PackageIdentifier).Str("packageversion", basemanifest.PackageVersion).Msgf("found %s manifest", basemanifest.ManifestType)
+        var manifest models.API_ManifestInterface
+        var err error
+        if basemanifest.ManifestType == "singleton" {
+          manifest, err = parseNodeAsSingletonManifest(basemanifest.ManifestVersion, basemanifest.Node)
         } else {
-          for _, version := range mergedManifest.GetVersions() {
-            // Internalization logic
-            if (autoInternalize) {
-              var installers []models.API_InstallerInterface = version.GetInstallers()
-
-              internalizeInstallers(key.PackageIdentifier, key.PackageVersion, installers, autoInternalizePath, autoInternalizeSkipHosts)
-
-              // Recreate manifest object, but with overwritten values (InstallerUrl(s))
-              overwrittenMergedManifest, err := newAPIManifest(
-                key.ManifestVersion,
-                key.PackageIdentifier,
-                key.PackageVersion,
-                version.GetDefaultLocale(),
-                version.GetLocales(),
-                installers,
-              )
-
-              if err != nil {
-                logging.Logger.Error().Str("package", key.PackageIdentifier).Str("packageversion", key.PackageVersion).Msgf("error reconstructing package after overwrite")
-              }
-
-              version = overwrittenMergedManifest.GetVersions()[0]
-            }
-            // End internalization logic
-
-            // Replace the existing PkgId + PkgVersion entry with this one
-            models.Manifests.Set(mergedManifest.GetPackageIdentifier(), version.GetPackageVersion(), version)
-          }
+          manifest, err = parseNodeAsMergedManifest(basemanifest.BaseManifest, basemanifest.Node)
+        }
+        if err != nil {
+          problems = append(problems, manifestProblem{
+            Severity: severityError,
+            File: filePath,
+            Line: basemanifest.Node.Line,
+            PackageIdentifier: basemanifest.PackageIdentifier,
+            PackageVersion: basemanifest.PackageVersion,
+            Message: fmt.Sprintf("could not parse %s manifest", basemanifest.ManifestType),
+            Error: err.Error(),
+          })
+          continue
         }
+        // Singleton and merged manifests can only contain one version of a package each
+        manifests = append(manifests, parsedManifest{
+          packageIdentifier: manifest.GetPackageIdentifier(),
+          manifestVersion: basemanifest.ManifestVersion,
+          version: manifest.GetVersions()[0],
+          nodes: []models.ManifestNode{*basemanifest},
+        })
+      case "version", "installer", "locale", "defaultLocale":
+        nonSingletonsMap[basemanifest.ToMultiFileManifest()] = append(nonSingletonsMap[basemanifest.ToMultiFileManifest()], *basemanifest)
+      default:
+        problems = append(problems, manifestProblem{
+          Severity: severityError,
+          File: filePath,
+          Line: basemanifest.Node.Line,
+          PackageIdentifier: basemanifest.PackageIdentifier,
+          PackageVersion: basemanifest.PackageVersion,
+          Message: "unknown ManifestType " + basemanifest.ManifestType,
+        })
       }
     }
+  }
 
-    wg.Done()
+  for key, value := range nonSingletonsMap {
+    logging.Logger.Debug().Str("package", key.PackageIdentifier).Str("packageversion", key.PackageVersion).Msgf("found multi-file manifest")
+    var mergedManifest, fileProblems, err = parseMultiFileManifest(value...)
+    problems = append(problems, fileProblems...)
+    if err != nil {
+      problems = append(problems, manifestProblem{
+        Severity: severityError,
+        File: path,
+        PackageIdentifier: key.PackageIdentifier,
+        PackageVersion: key.PackageVersion,
+        Message: "could not parse all manifest files for this package",
+        Error: err.Error(),
+      })
+      continue
+    }
+    for _, version := range mergedManifest.GetVersions() {
+      manifests = append(manifests, parsedManifest{
+        packageIdentifier: mergedManifest.GetPackageIdentifier(),
+        manifestVersion: key.ManifestVersion,
+        version: version,
+        nodes: value,
+      })
+    }
   }
 
-  return nil
+  return manifests, problems, nil
+}
+
+// Returns which of the fields required in every manifest type are not set
+func missingBaseManifestFields(basemanifest models.BaseManifest) []string {
+  var missing []string
+  if basemanifest.PackageIdentifier == "" {
+    missing = append(missing, "PackageIdentifier")
+  }
+  if basemanifest.PackageVersion == "" {
+    missing = append(missing, "PackageVersion")
+  }
+  if basemanifest.ManifestType == "" {
+    missing = append(missing, "ManifestType")
+  }
+  if basemanifest.ManifestVersion == "" {
+    missing = append(missing, "ManifestVersion")
+  }
+  return missing
+}
+
+// Returns the distinct files a set of manifest documents were read from
+func sourceFiles(nodes []models.ManifestNode) []string {
+  var files []string
+  for _, node := range nodes {
+    if !slices.Contains(files, node.SourceFile) {
+      files = append(files, node.SourceFile)
+    }
+  }
+  return files
 }
 
 func internalizeInstallers(
@@ -150,51 +295,84 @@ func internalizeInstallers(
       logging.Logger.Error().Err(err).Str("package", packageIdentifier).Str("packageversion", packageVersion).Msgf("cannot parse InstallerUrl %s", originalInstallerURL)
       continue
     }
-    if slices.Contains(autoInternalizeSkipHosts, u.Hostname()) {
+    // Uploaded installers point to where rewinged serves them, which may well be a skipped host,
+    // but they have to be registered as internalized to get their InstallerUrl rewritten
+    uploaded := strings.HasSuffix(u.Path, "/installers/" + strings.ToLower(installer.GetInstallerSha()))
+    if slices.Contains(autoInternalizeSkipHosts, u.Hostname()) && !uploaded {
       logging.Logger.Debug().Str("package", packageIdentifier).Str("packageversion", packageVersion).Msgf("not internalizing %s", originalInstallerURL)
       continue
     }
 
     var destFile string = filepath.Join(autoInternalizePath, strings.ToLower(installer.GetInstallerSha()))
-    // Why os.OpenFile instead of os.Create:
-    // https://stackoverflow.com/a/22483001
-    out, err := os.OpenFile(destFile, os.O_RDWR | os.O_CREATE | os.O_EXCL, 0666)
-    if err != nil {
-      if errors.Is(err, fs.ErrExist) {
-        logging.Logger.Debug().Str("package", packageIdentifier).Str("packageversion", packageVersion).Msgf("file already exists, not redownloading %s", destFile)
-      } else {
-        logging.Logger.Error().Err(err).Str("package", packageIdentifier).Str("packageversion", packageVersion).Msgf("cannot create file %s", destFile)
-        continue
+    if info, err := statInternalizedInstaller(destFile, installer.GetInstallerSha()); err == nil {
+      logging.Logger.Debug().Str("package", packageIdentifier).Str("packageversion", packageVersion).Msgf("file already exists, not redownloading %s", destFile)
+      // Remember that this installer was internalized successfully so we know we can rewrite its InstallerUrl later.
+      if !models.InternalizedInstallers.IsInternalized(installer.GetInstallerSha()) {
+        models.InternalizedInstallers.Set(withMetadata(models.InternalizedInstaller{
+          InstallerSha256: installer.GetInstallerSha(),
+          Path: destFile,
+          Size: info.Size(),
+          SourceURL: originalInstallerURL,
+          DownloadTime: info.ModTime(),
+        }))
       }
+    } else if !errors.Is(err, fs.ErrNotExist) {
+      logging.Logger.Error().Err(err).Str("package", packageIdentifier).Str("packageversion", packageVersion).Msgf("cannot access file %s", destFile)
     } else {
-      defer out.Close()
-      // No error, we could open the file for writing and it does not exist yet - so download it
-      logging.Logger.Debug().Str("package", packageIdentifier).Str("packageversion", packageVersion).Msgf("downloading installer")
+      // The InstallerUrl is rewritten once the download manager has finished downloading it
+      installerDownloads.enqueue(installerDownload{
+        packageIdentifier: packageIdentifier,
+        packageVersion: packageVersion,
+        installerURL: originalInstallerURL,
+        installerSha256: installer.GetInstallerSha(),
+        destFile: destFile,
+      })
+    }
+  }
+}
 
-      resp, err := http.Get(originalInstallerURL)
-      if err != nil {
-        logging.Logger.Error().Err(err).Str("package", packageIdentifier).Str("packageversion", packageVersion).Msgf("cannot download file %s", originalInstallerURL)
-        continue
-      }
-      defer resp.Body.Close()
-      if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-        logging.Logger.Error().Err(err).Str("package", packageIdentifier).Str("packageversion", packageVersion).Msgf("cannot download file %s (http status %d)", originalInstallerURL, resp.StatusCode)
-        continue
-      }
+// Returns the file of an internalized installer if it exists. Downloads are only ever moved
+// there after their hash was verified, but the file may have been damaged or replaced since,
+// e.g. when the autoInternalizePath was copied from somewhere else. So until the installer is
+// registered as internalized, the file is hashed again, and if it doesn't match it's deleted
+// and reported as not existing, so that it is downloaded again.
+func statInternalizedInstaller(destFile string, installerSha256 string) (fs.FileInfo, error) {
+  info, err := os.Stat(destFile)
+  if err != nil || models.InternalizedInstallers.IsInternalized(installerSha256) {
+    return info, err
+  }
 
-      n, err := io.Copy(out, resp.Body)
-      if err != nil {
-        logging.Logger.Error().Err(err).Str("package", packageIdentifier).Str("packageversion", packageVersion).Msgf("cannot save to file %s", destFile)
-        continue
-      } else {
-        logging.Logger.Debug().Str("package", packageIdentifier).Str("packageversion", packageVersion).Msgf("downloaded installer, %d bytes written", n)
-      }
+  f, err := os.Open(destFile)
+  if err != nil {
+    return nil, err
+  }
+  hash := sha256.New()
+  _, err = io.Copy(hash, f)
+  f.Close()
+  if err != nil {
+    return nil, err
+  }
+  if actualSha256 := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(actualSha256, installerSha256) {
+    logging.Logger.Error().Str("sha256", actualSha256).Msgf("existing file %s does not match its InstallerSha256, deleting it", destFile)
+    if err := os.Remove(destFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+      return nil, err
     }
+    return nil, fs.ErrNotExist
+  }
+  return info, nil
+}
 
-    logging.Logger.Debug().Str("package", packageIdentifier).Str("packageversion", packageVersion).Msgf("prepared internaliziation")
-    // Remember that this installer was internalized successfully (could be or already was downloaded)
-    // so we know we can rewrite its InstallerUrl later.
-    models.InternalizedInstallers[installer.GetInstallerSha()] = true
+// Evicts all package versions whose manifest files are in directories that no longer
+// exist. Removals within existing directories are already handled on ingest, but
+// deleted directories are never visited by a rescan so they have to be checked for.
+func removeMissingManifests() {
+  for _, file := range models.Manifests.GetSourceFiles() {
+    dir := filepath.Dir(file)
+    if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+      for _, key := range models.Manifests.RemoveSourcesBelow(dir) {
+        logging.Logger.Info().Str("package", key.PackageIdentifier).Str("packageversion", key.PackageVersion).Msg("removed manifest")
+      }
+    }
   }
 }
 
@@ -351,59 +529,66 @@ func unmarshalDefaultLocaleManifest (manifestVersion string, node yaml.Node) (mo
     return defaultlocale, nil
 }
 
-func parseMultiFileManifest (nodes ...models.ManifestNode) (models.API_ManifestInterface, error) {
+// Parses the files of a multi-file manifest. Files that cannot be parsed are returned as problems and
+// left out, which only makes parsing the whole package version fail if it's missing a required file then.
+func parseMultiFileManifest (nodes ...models.ManifestNode) (models.API_ManifestInterface, []manifestProblem, error) {
   if len(nodes) <= 0 {
-    return nil, errors.New("you must provide at least one ManifestNode for reading values")
+    return nil, nil, errors.New("you must provide at least one ManifestNode for reading values")
   }
 
   versions   := []models.Manifest_VersionManifestInterface{}
   installers := []models.Manifest_InstallerManifestInterface{}
   locales    := []models.Manifest_LocaleManifestInterface{}
   var defaultlocale models.Manifest_DefaultLocaleManifestInterface
+  var problems []manifestProblem
 
   for _, node := range nodes {
     var err error
     switch node.ManifestType {
       case "version":
         var version models.Manifest_VersionManifestInterface
-        version, err = unmarshalVersionManifest(node.ManifestVersion, node.Node)
-        if err != nil {
-          logging.Logger.Error().Err(err).Msg("cannot unmarshal version manifest")
-          continue
+        if version, err = unmarshalVersionManifest(node.ManifestVersion, node.Node); err == nil {
+          versions = append(versions, version)
         }
-        versions = append(versions, version)
       case "installer":
         var installer models.Manifest_InstallerManifestInterface
-        installer, err = unmarshalInstallerManifest(node.ManifestVersion, node.Node)
-        if err != nil {
-          logging.Logger.Error().Err(err).Msg("cannot unmarshal installer manifest")
-          continue
+        if installer, err = unmarshalInstallerManifest(node.ManifestVersion, node.Node); err == nil {
+          installers = append(installers, installer)
         }
-        installers = append(installers, installer)
       case "locale":
         var locale models.Manifest_LocaleManifestInterface
-        locale, err = unmarshalLocaleManifest(node.ManifestVersion, node.Node)
-        if err != nil {
-          logging.Logger.Error().Err(err).Msg("cannot unmarshal locale manifest")
-          continue
+        if locale, err = unmarshalLocaleManifest(node.ManifestVersion, node.Node); err == nil {
+          locales = append(locales, locale)
         }
-        locales = append(locales, locale)
       case "defaultLocale":
-        defaultlocale, err = unmarshalDefaultLocaleManifest(node.ManifestVersion, node.Node)
-        if err != nil {
-          logging.Logger.Error().Err(err).Msg("cannot unmarshal defaultLocale manifest")
-          continue
+        var parsed models.Manifest_DefaultLocaleManifestInterface
+        if parsed, err = unmarshalDefaultLocaleManifest(node.ManifestVersion, node.Node); err == nil {
+          defaultlocale = parsed
         }
       default:
     }
+    if err != nil {
+      problems = append(problems, manifestProblem{
+        Severity: severityError,
+        File: node.SourceFile,
+        Line: node.Node.Line,
+        PackageIdentifier: node.PackageIdentifier,
+        PackageVersion: node.PackageVersion,
+        Message: fmt.Sprintf("cannot unmarshal %s manifest", node.ManifestType),
+        Error: err.Error(),
+      })
+    }
   }
 
   // It's possible there were no installer or locale manifests or parsing them failed
   if len(installers) == 0 {
-    return nil, errors.New("no (valid) installer manifest")
+    return nil, problems, errors.New("no (valid) installer manifest")
   }
   if len(versions) == 0 {
-    return nil, errors.New("no (valid) locale manifest")
+    return nil, problems, errors.New("no (valid) version manifest")
+  }
+  if defaultlocale == nil {
+    return nil, problems, errors.New("no (valid) defaultLocale manifest")
   }
 
   // This transforms the manifest data into the format the API will return.
@@ -429,18 +614,20 @@ func parseMultiFileManifest (nodes ...models.ManifestNode) (models.API_ManifestI
     nodes[0].ManifestVersion,
     nodes[0].PackageIdentifier,
     nodes[0].PackageVersion,
+    installers[0].GetChannel(),
     defaultlocale.ToApiDefaultLocale(),
     apiLocales,
     apiInstallers,
   )
 
-  return manifest, err
+  return manifest, problems, err
 }
 
 func newAPIManifest (
   ManifestVersion string,
   PackageIdentifier string,
   pv string,
+  channel string,
   dl models.API_DefaultLocaleInterface,
   l []models.API_LocaleInterface,
   inst []models.API_InstallerInterface,
@@ -465,7 +652,7 @@ func newAPIManifest (
     apiMvi = models.API_ManifestVersion_1_1_0{
       PackageVersion: pv,
       DefaultLocale: dl.(models.API_DefaultLocale_1_1_0),
-      Channel: "",
+      Channel: channel,
       Locales: apiLocales,
       Installers: apiInstallers,
     }
@@ -490,7 +677,7 @@ func newAPIManifest (
     apiMvi = models.API_ManifestVersion_1_4_0{
       PackageVersion: pv,
       DefaultLocale: dl.(models.API_DefaultLocale_1_4_0),
-      Channel: "",
+      Channel: channel,
       Locales: apiLocales,
       Installers: apiInstallers,
     }
@@ -513,7 +700,7 @@ func newAPIManifest (
     apiMvi = models.API_ManifestVersion_1_5_0{
       PackageVersion: pv,
       DefaultLocale: dl.(models.API_DefaultLocale_1_5_0),
-      Channel: "",
+      Channel: channel,
       Locales: apiLocales,
       Installers: apiInstallers,
     }
@@ -536,7 +723,7 @@ func newAPIManifest (
     apiMvi = models.API_ManifestVersion_1_6_0{
       PackageVersion: pv,
       DefaultLocale: dl.(models.API_DefaultLocale_1_6_0),
-      Channel: "",
+      Channel: channel,
       Locales: apiLocales,
       Installers: apiInstallers,
     }
@@ -559,7 +746,7 @@ func newAPIManifest (
     apiMvi = models.API_ManifestVersion_1_7_0{
       PackageVersion: pv,
       DefaultLocale: dl.(models.API_DefaultLocale_1_7_0),
-      Channel: "",
+      Channel: channel,
       Locales: apiLocales,
       Installers: apiInstallers,
     }
@@ -582,7 +769,7 @@ func newAPIManifest (
     apiMvi = models.API_ManifestVersion_1_9_0{
       PackageVersion: pv,
       DefaultLocale: dl.(models.API_DefaultLocale_1_9_0),
-      Channel: "",
+      Channel: channel,
       Locales: apiLocales,
       Installers: apiInstallers,
     }
@@ -605,7 +792,7 @@ func newAPIManifest (
     apiMvi = models.API_ManifestVersion_1_10_0{
       PackageVersion: pv,
       DefaultLocale: dl.(models.API_DefaultLocale_1_10_0),
-      Channel: "",
+      Channel: channel,
       Locales: apiLocales,
       Installers: apiInstallers,
     }
@@ -624,18 +811,24 @@ func newAPIManifest (
 
 // One file could contain multiple manifests, using YAML document separators ("---")
 func parseFileAsBaseManifests (path string) ([]*models.ManifestNode, error) {
-  manifests := []*models.ManifestNode{}
-
   yamlFile, err := os.Open(path)
   if err != nil {
-    return manifests, err
+    return []*models.ManifestNode{}, err
   }
   defer yamlFile.Close()
 
+  return decodeBaseManifests(yamlFile, path)
+}
+
+// Decodes all YAML documents of a manifest file that will be, or was, read from path
+func decodeBaseManifests (r io.Reader, path string) ([]*models.ManifestNode, error) {
+  manifests := []*models.ManifestNode{}
+  var err error
+
   // Decode all YAML documents in the YAML file.
   // This allows for multiple manifests, or non-manifest
   // metadata to be in one file, separated by "---".
-  fileDecoder := yaml.NewDecoder(yamlFile)
+  fileDecoder := yaml.NewDecoder(r)
   for {
     var node yaml.Node
     if err := fileDecoder.Decode(&node); err == io.EOF {
@@ -649,6 +842,7 @@ func parseFileAsBaseManifests (path string) ([]*models.ManifestNode, error) {
       return manifests, err
     }
     manifest.Node = node
+    manifest.SourceFile = path
 
     manifests = append(manifests, manifest)
   }
@@ -668,6 +862,67 @@ func parseNodeAsSingletonManifest (manifestVersion string, node yaml.Node) (mode
   return manifest, err
 }
 
+// A merged manifest is the combination of all files of a multi-file manifest in one
+// YAML document: the installer and defaultLocale properties are at the root and all
+// additional locales are listed under the Localization key. Because of that it can be
+// parsed with the same structs used for the individual files of multi-file manifests.
+func parseNodeAsMergedManifest (basemanifest models.BaseManifest, node yaml.Node) (models.API_ManifestInterface, error) {
+  installer, err := unmarshalInstallerManifest(basemanifest.ManifestVersion, node)
+  if err != nil {
+    return nil, err
+  }
+
+  defaultlocale, err := unmarshalDefaultLocaleManifest(basemanifest.ManifestVersion, node)
+  if err != nil {
+    return nil, err
+  }
+
+  var apiLocales []models.API_LocaleInterface
+  if localizations := findMappingValue(node, "Localization"); localizations != nil {
+    if localizations.Kind != yaml.SequenceNode {
+      return nil, errors.New("Localization must be a list of locales")
+    }
+    for _, localization := range localizations.Content {
+      locale, err := unmarshalLocaleManifest(basemanifest.ManifestVersion, *localization)
+      if err != nil {
+        return nil, err
+      }
+      apiLocales = append(apiLocales, locale.ToApiLocale())
+    }
+  }
+
+  installers := installer.ToApiInstallers()
+  if len(installers) == 0 {
+    return nil, errors.New("no installers in merged manifest")
+  }
+
+  return newAPIManifest(
+    basemanifest.ManifestVersion,
+    basemanifest.PackageIdentifier,
+    basemanifest.PackageVersion,
+    installer.GetChannel(),
+    defaultlocale.ToApiDefaultLocale(),
+    apiLocales,
+    installers,
+  )
+}
+
+// Returns the value of a key in a YAML mapping (or document containing a mapping), or nil
+func findMappingValue (node yaml.Node, key string) *yaml.Node {
+  if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+    node = *node.Content[0]
+  }
+  if node.Kind != yaml.MappingNode {
+    return nil
+  }
+  for i := 0; i + 1 < len(node.Content); i += 2 {
+    if node.Content[i].Value == key {
+      return node.Content[i+1]
+    }
+  }
+  return nil
+}
+
 func unmarshalSingletonManifest (manifestVersion string, node yaml.Node) (models.Manifest_SingletonManifestInterface, error) {
     var smanifest models.Manifest_SingletonManifestInterface
 
@@ -0,0 +1,198 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+  "slices"
+  "strings"
+  "sync"
+  "path/filepath"
+
+  "rewinged/installerinfo"
+  "rewinged/logging"
+  "rewinged/models"
+)
+
+// Extracts the metadata of an internalized installer once its file is in place
+func withMetadata(installer models.InternalizedInstaller) models.InternalizedInstaller {
+  metadata, err := installerinfo.ReadFile(installer.Path)
+  if err != nil {
+    if !errors.Is(err, installerinfo.ErrUnsupportedInstaller) {
+      logging.Logger.Warn().Err(err).Str("file", installer.Path).Msg("cannot read installer metadata")
+    }
+    return installer
+  }
+  installer.Metadata = &metadata
+  return installer
+}
+
+// Returns the metadata of the installer with an InstallerSha256, or nil if it is unknown
+type installerMetadataLookup func(installerSha256 string) *installerinfo.Metadata
+
+// Looks up the metadata of the installers this rewinged has internalized
+func internalizedMetadata(installerSha256 string) *installerinfo.Metadata {
+  if internalized, ok := models.InternalizedInstallers.Get(installerSha256); ok {
+    return internalized.Metadata
+  }
+  return nil
+}
+
+// Reads the metadata of internalized installers from their files, for when there is no running
+// rewinged that knows them, e.g. in the validate command. Each file is only read once.
+func installerFileMetadata(autoInternalizePath string) installerMetadataLookup {
+  var mu sync.Mutex
+  known := make(map[string]*installerinfo.Metadata)
+  return func(installerSha256 string) *installerinfo.Metadata {
+    // The InstallerSha256 becomes a file name
+    if !installerShaPattern.MatchString(installerSha256) {
+      return nil
+    }
+    installerSha256 = strings.ToLower(installerSha256)
+    mu.Lock()
+    defer mu.Unlock()
+    if metadata, ok := known[installerSha256]; ok {
+      return metadata
+    }
+    var metadata *installerinfo.Metadata
+    if m, err := installerinfo.ReadFile(filepath.Join(autoInternalizePath, installerSha256)); err == nil {
+      metadata = &m
+    }
+    known[installerSha256] = metadata
+    return metadata
+  }
+}
+
+// Compares what a package version claims about its installers with the metadata of the installers
+// themselves, which winget relies on to correlate installed programs with packages. Only internalized
+// installers can be checked, because only their files are at hand.
+func checkInstallerMetadata(manifest parsedManifest, lookup installerMetadataLookup) []manifestProblem {
+  if !hasInstallerMetadata(manifest.version, lookup) {
+    return nil
+  }
+  converted, err := models.ConvertManifestVersion(manifest.version, managementSchemaVersion)
+  if err != nil {
+    return nil
+  }
+  version := converted.(models.API_ManifestVersion_1_10_0)
+  assignInstallerIdentifiers(version.Installers)
+
+  // Problems are reported where the installers are defined
+  file, line := manifest.nodes[0].SourceFile, manifest.nodes[0].Node.Line
+  for _, node := range manifest.nodes {
+    if node.ManifestType != "version" && node.ManifestType != "locale" && node.ManifestType != "defaultLocale" {
+      file, line = node.SourceFile, node.Node.Line
+      break
+    }
+  }
+
+  var problems []manifestProblem
+  for _, installer := range version.Installers {
+    found := lookup(installer.InstallerSha256)
+    if found == nil {
+      continue
+    }
+    metadata := *found
+    problem := func(format string, a ...any) {
+      problems = append(problems, manifestProblem{
+        Severity: severityWarning,
+        File: file,
+        Line: line,
+        PackageIdentifier: manifest.packageIdentifier,
+        PackageVersion: version.PackageVersion,
+        Message: fmt.Sprintf(format, a...),
+      })
+    }
+    compare := func(property string, claimed []string, actual string) {
+      claimed = slices.DeleteFunc(claimed, func(c string) bool { return c == "" })
+      if actual == "" || len(claimed) == 0 || slices.ContainsFunc(claimed, func(c string) bool { return strings.EqualFold(c, actual) }) {
+        return
+      }
+      problem("%v %v of installer %v does not match %v of the installer file", property, strings.Join(claimed, ", "), installer.InstallerIdentifier, actual)
+    }
+
+    productCodes := []string{installer.ProductCode}
+    var upgradeCodes, displayVersions, publishers, displayNames []string
+    for _, entry := range installer.AppsAndFeaturesEntries {
+      productCodes = append(productCodes, entry.ProductCode)
+      upgradeCodes = append(upgradeCodes, entry.UpgradeCode)
+      displayVersions = append(displayVersions, entry.DisplayVersion)
+      publishers = append(publishers, entry.Publisher)
+      displayNames = append(displayNames, entry.DisplayName)
+    }
+    // Without any ProductCode, winget cannot tell whether the package is installed at all
+    if metadata.ProductCode != "" && !slices.ContainsFunc(productCodes, func(c string) bool { return c != "" }) {
+      problem("installer %v has no ProductCode, the one of the installer file is %v", installer.InstallerIdentifier, metadata.ProductCode)
+    }
+    compare("ProductCode", productCodes, metadata.ProductCode)
+    compare("UpgradeCode", upgradeCodes, metadata.UpgradeCode)
+    compare("DisplayVersion", displayVersions, metadata.ProductVersion)
+    compare("Publisher", publishers, metadata.Manufacturer)
+    compare("DisplayName", displayNames, metadata.ProductName)
+
+    // Without the PackageFamilyName, winget cannot find the package of an installed MSIX app by searching for it.
+    // It is filled in when the package version is loaded, but only as long as rewinged serves the installer itself.
+    if metadata.PackageFamilyName != "" && installer.PackageFamilyName == "" {
+      problem("installer %v has no PackageFamilyName, the one of the installer file is %v", installer.InstallerIdentifier, metadata.PackageFamilyName)
+    }
+    compare("PackageFamilyName", []string{installer.PackageFamilyName}, metadata.PackageFamilyName)
+    compare("SignatureSha256", []string{installer.SignatureSha256}, metadata.SignatureSha256)
+  }
+  return problems
+}
+
+func hasInstallerMetadata(version models.API_ManifestVersionInterface, lookup installerMetadataLookup) bool {
+  return slices.ContainsFunc(version.GetInstallers(), func(installer models.API_InstallerInterface) bool {
+    return lookup(installer.GetInstallerSha()) != nil
+  })
+}
+
+// Returns a package version with the metadata of its internalized installers filled in where
+// the manifest doesn't set it, so that the search and the correlation of installed programs
+// see it too. The package version is returned as it is if there is nothing to fill in.
+func applyInstallerMetadata(version models.API_ManifestVersionInterface) models.API_ManifestVersionInterface {
+  if !hasInstallerMetadata(version, internalizedMetadata) {
+    return version
+  }
+  converted, err := models.ConvertManifestVersion(version, managementSchemaVersion)
+  if err != nil {
+    return version
+  }
+  filled := converted.(models.API_ManifestVersion_1_10_0)
+  for i := range filled.Installers {
+    if metadata := internalizedMetadata(filled.Installers[i].InstallerSha256); metadata != nil {
+      fillInstallerMetadata(&filled.Installers[i], *metadata, filled.PackageVersion)
+    }
+  }
+  // Back to the schema of its manifest, like every other package version
+  result, err := models.ConvertManifestVersionLike(filled, version)
+  if err != nil {
+    return version
+  }
+  return result
+}
+
+// Fills in what winget needs to correlate an installed program with the package version, for
+// properties the manifest doesn't set. An AppsAndFeaturesEntry is only added if it tells winget
+// more than the installer itself does: the UpgradeCode, or a version that differs from the PackageVersion.
+func fillInstallerMetadata(installer *models.API_Installer_1_10_0, metadata installerinfo.Metadata, packageVersion string) {
+  if installer.ProductCode == "" {
+    installer.ProductCode = metadata.ProductCode
+  }
+  if installer.PackageFamilyName == "" {
+    installer.PackageFamilyName = metadata.PackageFamilyName
+  }
+  if installer.SignatureSha256 == "" {
+    installer.SignatureSha256 = metadata.SignatureSha256
+  }
+  displayVersion := metadata.ProductVersion
+  if displayVersion == packageVersion {
+    displayVersion = ""
+  }
+  if len(installer.AppsAndFeaturesEntries) > 0 || (metadata.UpgradeCode == "" && displayVersion == "") {
+    return
+  }
+  // The type of the entries is anonymous, so one can only be added by growing the slice
+  installer.AppsAndFeaturesEntries = slices.Grow(installer.AppsAndFeaturesEntries, 1)[:1]
+  installer.AppsAndFeaturesEntries[0].UpgradeCode = metadata.UpgradeCode
+  installer.AppsAndFeaturesEntries[0].DisplayVersion = displayVersion
+}
@@ -0,0 +1,145 @@
+// Package metrics defines the Prometheus metrics rewinged exposes on /metrics
+package metrics
+
+import (
+    "time"
+    "slices"
+    "strconv"
+    "net/http"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/promauto"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+
+    "rewinged/models"
+)
+
+var (
+    HttpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+        Name: "rewinged_http_requests_total",
+        Help: "Number of HTTP requests handled, by route and status code",
+    }, []string{"route", "method", "status_code"})
+
+    HttpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+        Name: "rewinged_http_request_duration_seconds",
+        Help: "Time taken to handle HTTP requests, by route",
+        Buckets: prometheus.DefBuckets,
+    }, []string{"route", "method"})
+
+    Searches = promauto.NewCounterVec(prometheus.CounterOpts{
+        Name: "rewinged_searches_total",
+        Help: "Number of search criteria in manifestSearch requests, by PackageMatchField (Query for keyword searches) and MatchType",
+    }, []string{"match_field", "match_type"})
+
+    ManifestIngestErrors = promauto.NewCounter(prometheus.CounterOpts{
+        Name: "rewinged_manifest_ingest_errors_total",
+        Help: "Number of manifest files or package versions that could not be ingested",
+    })
+
+    LiveReloadEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+        Name: "rewinged_live_reload_events_total",
+        Help: "Number of file system events received for the manifestPath, by event type",
+    }, []string{"event"})
+
+    LiveReloadRescans = promauto.NewCounter(prometheus.CounterOpts{
+        Name: "rewinged_live_reload_overflow_rescans_total",
+        Help: "Number of full manifest rescans because file system events were lost",
+    })
+
+    InstallerDownloads = promauto.NewCounterVec(prometheus.CounterOpts{
+        Name: "rewinged_installer_downloads_total",
+        Help: "Number of installer download attempts for auto-internalization, by result",
+    }, []string{"result"})
+
+    InstallerDownloadBytes = promauto.NewCounter(prometheus.CounterOpts{
+        Name: "rewinged_installer_download_bytes_total",
+        Help: "Number of bytes downloaded for auto-internalization, including failed attempts",
+    })
+
+    AuthFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+        Name: "rewinged_auth_failures_total",
+        Help: "Number of requests rejected by source authentication, by reason",
+    }, []string{"reason"})
+
+    _ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+        Name: "rewinged_manifests",
+        Help: "Number of package versions currently loaded",
+    }, func() float64 {
+        return float64(models.Manifests.GetPackageVersionCount())
+    })
+
+    _ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+        Name: "rewinged_internalized_installers",
+        Help: "Number of installers currently internalized",
+    }, func() float64 {
+        return float64(models.InternalizedInstallers.Count())
+    })
+)
+
+// Result labels of InstallerDownloads, every attempt is counted
+const (
+    DownloadSucceeded = "success"
+    DownloadFailed = "failure"
+    DownloadRejected = "sha256_mismatch"
+)
+
+// Reason labels of AuthFailures
+const (
+    AuthMissingToken = "missing_token"
+    AuthInvalidToken = "invalid_token"
+    AuthMissingClaims = "missing_claims"
+)
+
+// The PackageMatchFields and MatchTypes defined by the REST source specification. Search
+// requests are counted with them as labels, anything else a client sends is counted as
+// "other" to keep the number of label values bounded.
+var (
+    knownMatchFields = []string{"Query", "PackageIdentifier", "PackageName", "Moniker", "Command", "Tag", "PackageFamilyName", "ProductCode", "UpgradeCode", "NormalizedPackageNameAndPublisher", "Market"}
+    knownMatchTypes = []string{"Exact", "CaseInsensitive", "StartsWith", "Substring", "Wildcard", "Fuzzy", "FuzzySubstring"}
+)
+
+func CountSearch(matchField string, matchType string) {
+    if !slices.Contains(knownMatchFields, matchField) {
+        matchField = "other"
+    }
+    if !slices.Contains(knownMatchTypes, matchType) {
+        matchType = "other"
+    }
+    Searches.WithLabelValues(matchField, matchType).Inc()
+}
+
+func Handler() http.Handler {
+    return promhttp.Handler()
+}
+
+type statusRecorder struct {
+    http.ResponseWriter
+    status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+    s.status = status
+    s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+    return s.ResponseWriter
+}
+
+// Middleware records the HttpRequests and HttpRequestDuration of all requests. It has
+// to wrap the router, the route label is the pattern the router matched the request to
+// so that it can't grow unboundedly with e.g. every PackageIdentifier requested.
+func Middleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        start := time.Now()
+        recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+        next.ServeHTTP(recorder, r)
+
+        route := r.Pattern
+        if route == "" {
+            route = "unmatched"
+        }
+        HttpRequests.WithLabelValues(route, r.Method, strconv.Itoa(recorder.status)).Inc()
+        HttpRequestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+    })
+}
@@ -35,16 +35,73 @@ func (ver API_ManifestVersion_1_6_0) GetPackageVersion() string {
     return ver.PackageVersion
 }
 
+// Returns the distinct ProductCodes of all installers and their AppsAndFeaturesEntries
 func (ver API_ManifestVersion_1_6_0) GetInstallerProductCodes() []string {
-    var productCodes []string
+    productCodes := []string{}
 
     for _, installer := range ver.Installers {
-      productCodes = append(productCodes, installer.ProductCode)
+      productCodes = appendUnique(productCodes, installer.ProductCode)
+      for _, entry := range installer.AppsAndFeaturesEntries {
+        productCodes = appendUnique(productCodes, entry.ProductCode)
+      }
     }
 
     return productCodes
 }
 
+func (ver API_ManifestVersion_1_6_0) GetInstallerPackageFamilyNames() []string {
+    packageFamilyNames := []string{}
+
+    for _, installer := range ver.Installers {
+      packageFamilyNames = appendUnique(packageFamilyNames, installer.PackageFamilyName)
+    }
+
+    return packageFamilyNames
+}
+
+func (ver API_ManifestVersion_1_6_0) GetInstallerUpgradeCodes() []string {
+    upgradeCodes := []string{}
+
+    for _, installer := range ver.Installers {
+      for _, entry := range installer.AppsAndFeaturesEntries {
+        upgradeCodes = appendUnique(upgradeCodes, entry.UpgradeCode)
+      }
+    }
+
+    return upgradeCodes
+}
+
+// Returns the distinct DisplayVersions of all AppsAndFeaturesEntries of all installers
+func (ver API_ManifestVersion_1_6_0) GetAppsAndFeaturesEntryVersions() []string {
+    displayVersions := []string{}
+
+    for _, installer := range ver.Installers {
+      for _, entry := range installer.AppsAndFeaturesEntries {
+        displayVersions = appendUnique(displayVersions, entry.DisplayVersion)
+      }
+    }
+
+    return displayVersions
+}
+
+func (ver API_ManifestVersion_1_6_0) GetChannel() string {
+    return ver.Channel
+}
+
+// Returns a copy of this version with only the installers for which keep returns true
+func (ver API_ManifestVersion_1_6_0) FilterInstallers(keep func(API_InstallerInterface) bool) API_ManifestVersionInterface {
+    var installers []API_Installer_1_6_0
+
+    for i := 0; i < len(ver.Installers); i++ {
+        if keep(&ver.Installers[i]) {
+            installers = append(installers, ver.Installers[i])
+        }
+    }
+
+    ver.Installers = installers
+    return ver
+}
+
 func (ver API_ManifestVersion_1_6_0) GetDefaultLocale() API_DefaultLocaleInterface {
     return ver.DefaultLocale
 }
@@ -154,6 +211,10 @@ func (in *API_Installer_1_6_0) SetInstallerUrl(newUrl string) {
     in.InstallerUrl = newUrl
 }
 
+func (in *API_Installer_1_6_0) GetMarkets() (allowedMarkets []string, excludedMarkets []string) {
+    return in.Markets.AllowedMarkets, in.Markets.ExcludedMarkets
+}
+
 // API Locale schema
 // https://github.com/microsoft/winget-cli-restsource/blob/main/documentation/WinGet-1.6.0.yaml
 type API_Locale_1_6_0 struct {
@@ -220,10 +281,10 @@ func (in API_DefaultLocale_1_6_0) dummyFunc() bool {
 
 type API_ManifestSearchVersion_1_6_0 struct {
     PackageVersion string
-    Channel string //maxlength: 16, unused
+    Channel string //maxlength: 16
     PackageFamilyNames []string
     ProductCodes []string
-    AppsAndFeaturesEntryVersions string
+    AppsAndFeaturesEntryVersions []string
     UpgradeCodes []string
 }
 
@@ -27,16 +27,22 @@ type API_ManifestVersionInterface interface {
     GetDefaultLocalePublisher() string
     GetDefaultLocaleShortDescription() string
     GetPackageVersion() string
+    GetChannel() string
     GetDefaultLocale() API_DefaultLocaleInterface
     GetLocales() []API_LocaleInterface
     GetInstallers() []API_InstallerInterface
     GetInstallerProductCodes() []string
+    GetInstallerPackageFamilyNames() []string
+    GetInstallerUpgradeCodes() []string
+    GetAppsAndFeaturesEntryVersions() []string
+    FilterInstallers(keep func(API_InstallerInterface) bool) API_ManifestVersionInterface
 }
 
 type API_InstallerInterface interface {
     GetInstallerSha() string
     GetInstallerUrl() string
     SetInstallerUrl(newUrl string)
+    GetMarkets() (allowedMarkets []string, excludedMarkets []string)
     dummyFunc() bool
 }
 
@@ -75,5 +81,6 @@ type API_ManifestSearchResult[MSVI API_ManifestSearchVersionInterface] struct {
     Data []API_ManifestSearchResponse[MSVI]
     RequiredPackageMatchFields []string
     UnsupportedPackageMatchFields []string
+    ContinuationToken string `json:",omitempty"`
 }
 
@@ -0,0 +1,77 @@
+package models
+
+import (
+    "errors"
+    "slices"
+    "reflect"
+    "encoding/json"
+)
+
+// ConvertManifestVersion returns a deep copy of a package version in the schema of
+// the given API version. The API schemas of all versions share their property names,
+// so the conversion is done by serializing the package version and deserializing it
+// into the target schema: properties that do not exist in the target schema are dropped
+// and properties that did not exist in the source schema are left empty.
+// An empty apiVersion keeps the schema the package version is already in.
+func ConvertManifestVersion(mv API_ManifestVersionInterface, apiVersion string) (API_ManifestVersionInterface, error) {
+    var target reflect.Type
+
+    switch apiVersion {
+        case "":
+            target = reflect.Indirect(reflect.ValueOf(mv)).Type()
+        case "1.1.0":
+            target = reflect.TypeFor[API_ManifestVersion_1_1_0]()
+        case "1.4.0":
+            target = reflect.TypeFor[API_ManifestVersion_1_4_0]()
+        case "1.5.0":
+            target = reflect.TypeFor[API_ManifestVersion_1_5_0]()
+        case "1.6.0":
+            target = reflect.TypeFor[API_ManifestVersion_1_6_0]()
+        case "1.7.0":
+            target = reflect.TypeFor[API_ManifestVersion_1_7_0]()
+        case "1.9.0":
+            target = reflect.TypeFor[API_ManifestVersion_1_9_0]()
+        case "1.10.0":
+            target = reflect.TypeFor[API_ManifestVersion_1_10_0]()
+        default:
+            return nil, errors.New("unsupported API version " + apiVersion)
+    }
+
+    converted, err := convertTo(mv, target)
+    if err != nil {
+        return nil, err
+    }
+
+    // Installers that depend on features added in API 1.4.0 cannot be used by older clients
+    if v, ok := converted.Interface().(*API_ManifestVersion_1_1_0); ok {
+        v.Installers = slices.DeleteFunc(v.Installers, func(installer API_Installer_1_1_0) bool {
+            return installer.InstallerType == "portable" || installer.InstallerType == "zip"
+        })
+    }
+
+    return converted.Elem().Interface().(API_ManifestVersionInterface), nil
+}
+
+// ConvertManifestVersionLike returns a deep copy of a package version in the same schema as
+// another one, e.g. to convert a package version back after it was changed in another schema.
+// Unlike ConvertManifestVersion, it never drops any installers.
+func ConvertManifestVersionLike(mv API_ManifestVersionInterface, like API_ManifestVersionInterface) (API_ManifestVersionInterface, error) {
+    converted, err := convertTo(mv, reflect.Indirect(reflect.ValueOf(like)).Type())
+    if err != nil {
+        return nil, err
+    }
+    return converted.Elem().Interface().(API_ManifestVersionInterface), nil
+}
+
+// Returns a pointer to the converted package version
+func convertTo(mv API_ManifestVersionInterface, target reflect.Type) (reflect.Value, error) {
+    data, err := json.Marshal(mv)
+    if err != nil {
+        return reflect.Value{}, err
+    }
+    converted := reflect.New(target)
+    if err := json.Unmarshal(data, converted.Interface()); err != nil {
+        return reflect.Value{}, err
+    }
+    return converted, nil
+}
@@ -0,0 +1,231 @@
+package models
+
+import (
+    "maps"
+    "slices"
+    "sort"
+    "strings"
+    "sync"
+)
+
+// The package versions that have a certain value in a PackageMatchField
+type postings map[ManifestKey]bool
+
+// The index of the values of one PackageMatchField
+type fieldIndex struct {
+    // Maps lowercased values to the original values (there can be several
+    // that only differ in case) and their postings
+    values map[string]map[string]postings
+    // Maps the values as the Fuzzy MatchTypes compare them to the lowercased values
+    fuzzy map[string]map[string]bool
+
+    // The lowercased values in sorted order, so that the ones starting with a prefix can be
+    // found with a binary search. Sorting them on every change would make loading thousands
+    // of manifests slow, so they are only sorted again by the next search that needs them.
+    // Searches only hold the read lock of the ManifestsStore, hence the separate mutex.
+    sortedMu sync.Mutex
+    sorted []string
+    sortedValid bool
+}
+
+func newFieldIndex() *fieldIndex {
+    return &fieldIndex{
+        values: make(map[string]map[string]postings),
+        fuzzy: make(map[string]map[string]bool),
+    }
+}
+
+func (fi *fieldIndex) add(value string, key ManifestKey) {
+    lower := strings.ToLower(value)
+    if fi.values[lower] == nil {
+        fi.values[lower] = make(map[string]postings)
+        fi.sortedValid = false
+        normalized := fuzzyNormalize(lower)
+        if fi.fuzzy[normalized] == nil {
+            fi.fuzzy[normalized] = make(map[string]bool)
+        }
+        fi.fuzzy[normalized][lower] = true
+    }
+    if fi.values[lower][value] == nil {
+        fi.values[lower][value] = make(postings)
+    }
+    fi.values[lower][value][key] = true
+}
+
+func (fi *fieldIndex) remove(value string, key ManifestKey) {
+    lower := strings.ToLower(value)
+    delete(fi.values[lower][value], key)
+    if len(fi.values[lower][value]) == 0 {
+        delete(fi.values[lower], value)
+    }
+    if len(fi.values[lower]) == 0 {
+        delete(fi.values, lower)
+        fi.sortedValid = false
+        normalized := fuzzyNormalize(lower)
+        delete(fi.fuzzy[normalized], lower)
+        if len(fi.fuzzy[normalized]) == 0 {
+            delete(fi.fuzzy, normalized)
+        }
+    }
+}
+
+// Returns the lowercased values that start with a lowercased prefix
+func (fi *fieldIndex) withPrefix(prefix string) []string {
+    fi.sortedMu.Lock()
+    if !fi.sortedValid {
+        fi.sorted = slices.Sorted(maps.Keys(fi.values))
+        fi.sortedValid = true
+    }
+    sorted := fi.sorted
+    fi.sortedMu.Unlock()
+
+    start, _ := slices.BinarySearch(sorted, prefix)
+    end := start + sort.Search(len(sorted) - start, func(i int) bool {
+        return !strings.HasPrefix(sorted[start + i], prefix)
+    })
+    return sorted[start:end]
+}
+
+// Returns all package versions with a value that fulfills the RequestMatch
+func (fi *fieldIndex) search(requestMatch API_SearchRequestMatch_1_1_0) postings {
+    result := make(postings)
+    addMatches := func(lower string) {
+        for original, keys := range fi.values[lower] {
+            if requestMatches(original, requestMatch) {
+                for key := range keys {
+                    result[key] = true
+                }
+            }
+        }
+    }
+
+    lowerKeyWord := strings.ToLower(requestMatch.KeyWord)
+    switch requestMatch.MatchType {
+    case "Exact", "CaseInsensitive":
+        // Only values that are equal when lowercased can match, look them up directly
+        addMatches(lowerKeyWord)
+    case "StartsWith":
+        // Values that start with the keyword still do when both are lowercased
+        for _, lower := range fi.withPrefix(lowerKeyWord) {
+            addMatches(lower)
+        }
+    case "Wildcard":
+        // Only values that start with the part of the pattern before its first wildcard can match
+        prefix := lowerKeyWord
+        if i := strings.IndexAny(prefix, "*?"); i >= 0 {
+            prefix = prefix[:i]
+        }
+        for _, lower := range fi.withPrefix(prefix) {
+            addMatches(lower)
+        }
+    case "Substring":
+        // The keys are already lowercased, skip the ones that cannot contain the keyword
+        for lower := range fi.values {
+            if strings.Contains(lower, lowerKeyWord) {
+                addMatches(lower)
+            }
+        }
+    case "Fuzzy":
+        for lower := range fi.fuzzy[fuzzyNormalize(requestMatch.KeyWord)] {
+            addMatches(lower)
+        }
+    case "FuzzySubstring":
+        normalizedKeyWord := fuzzyNormalize(requestMatch.KeyWord)
+        for normalized, lowers := range fi.fuzzy {
+            if strings.Contains(normalized, normalizedKeyWord) {
+                for lower := range lowers {
+                    addMatches(lower)
+                }
+            }
+        }
+    }
+    return result
+}
+
+// Keyword searches look at the PackageName and ShortDescription of the default locale, they
+// are indexed under this name which is not a PackageMatchField clients could search by
+const keywordField = "Query"
+
+// Inverted index of all searchable PackageMatchFields. It is kept up to date by the
+// ManifestsStore whenever a package version is set or deleted, so that searches only
+// have to look at the distinct values of the requested fields instead of inspecting
+// every version of every package.
+type searchIndex struct {
+    fields map[string]*fieldIndex
+    keywords *fieldIndex
+    // The values every package version was indexed under, so it can be removed again
+    indexed map[ManifestKey]map[string][]string
+}
+
+func newSearchIndex() *searchIndex {
+    si := &searchIndex{
+        fields: make(map[string]*fieldIndex),
+        keywords: newFieldIndex(),
+        indexed: make(map[ManifestKey]map[string][]string),
+    }
+    si.fields["PackageIdentifier"] = newFieldIndex()
+    si.fields["NormalizedPackageNameAndPublisher"] = newFieldIndex()
+    for packageMatchField := range packageMatchFieldStructFields {
+        si.fields[packageMatchField] = newFieldIndex()
+    }
+    return si
+}
+
+func (si *searchIndex) supports(packageMatchField string) bool {
+    _, ok := si.fields[packageMatchField]
+    return ok
+}
+
+// Returns the index of a PackageMatchField or of the keywords
+func (si *searchIndex) field(name string) *fieldIndex {
+    if name == keywordField {
+        return si.keywords
+    }
+    return si.fields[name]
+}
+
+// Indexes a package version, replacing any previously indexed values of it
+func (si *searchIndex) add(key ManifestKey, version API_ManifestVersionInterface) {
+    si.remove(key)
+
+    values := make(map[string][]string)
+    for packageMatchField := range si.fields {
+        values[packageMatchField], _ = packageMatchFieldValues(key.PackageIdentifier, version, packageMatchField)
+    }
+    for _, keyword := range []string{version.GetDefaultLocalePackageName(), version.GetDefaultLocaleShortDescription()} {
+        if keyword != "" {
+            values[keywordField] = append(values[keywordField], keyword)
+        }
+    }
+
+    for name, fieldValues := range values {
+        for _, value := range fieldValues {
+            si.field(name).add(value, key)
+        }
+    }
+    si.indexed[key] = values
+}
+
+func (si *searchIndex) remove(key ManifestKey) {
+    for name, fieldValues := range si.indexed[key] {
+        for _, value := range fieldValues {
+            si.field(name).remove(value, key)
+        }
+    }
+    delete(si.indexed, key)
+}
+
+// Returns all package versions with a value in packageMatchField that fulfills the
+// RequestMatch. The boolean is false if the PackageMatchField is not supported.
+func (si *searchIndex) search(packageMatchField string, requestMatch API_SearchRequestMatch_1_1_0) (postings, bool) {
+    fi, ok := si.fields[packageMatchField]
+    if !ok {
+        return nil, false
+    }
+    return fi.search(requestMatch), true
+}
+
+// Returns all package versions whose default locale's PackageName or ShortDescription contains the keyword
+func (si *searchIndex) searchKeyword(keyword string) postings {
+    return si.keywords.search(API_SearchRequestMatch_1_1_0{KeyWord: keyword, MatchType: "Substring"})
+}
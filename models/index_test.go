@@ -0,0 +1,171 @@
+package models
+
+import (
+    "maps"
+    "slices"
+    "testing"
+)
+
+type testPackageVersion struct {
+    packageIdentifier string
+    packageVersion string
+    packageName string
+    shortDescription string
+    tags []string
+}
+
+var testPackageVersions = []testPackageVersion{
+    {"Microsoft.VisualStudioCode", "1.95.0", "Microsoft Visual Studio Code", "Code editing. Redefined.", []string{"editor", "IDE", "developer-tools"}},
+    {"Microsoft.VisualStudioCode", "1.96.0", "Microsoft Visual Studio Code", "Code editing. Redefined.", []string{"editor", "IDE", "developer-tools"}},
+    {"Microsoft.VisualStudio.2022.Community", "17.12.0", "Visual Studio Community 2022", "The IDE for .NET and C++ developers", []string{"ide", "csharp"}},
+    {"Notepad++.Notepad++", "8.7", "Notepad++", "A free source code editor", []string{"Editor", "text"}},
+    {"Lapce.Lapce", "0.4.2", "Lapce", "Lightning-fast and powerful code editor written in Rust", []string{"editor", "rust"}},
+    {"Git.Git", "2.47.0", "Git", "A free and open source distributed version control system", []string{"vcs", "git"}},
+    {"GitHub.cli", "2.62.0", "GitHub CLI", "Take GitHub to the command line", []string{"git", "github", "cli"}},
+    {"Zig.Zig", "0.13.0", "Zig", "", nil},
+}
+
+func (p testPackageVersion) version() API_ManifestVersionInterface {
+    version := API_ManifestVersion_1_10_0{PackageVersion: p.packageVersion}
+    version.DefaultLocale.PackageName = p.packageName
+    version.DefaultLocale.ShortDescription = p.shortDescription
+    version.DefaultLocale.Tags = p.tags
+    return version
+}
+
+func newTestStore(packageVersions []testPackageVersion) *ManifestsStore {
+    ms := &ManifestsStore{
+        internal: make(map[string]map[string]API_ManifestVersionInterface),
+        origins: make(map[ManifestKey][]string),
+        sources: make(map[string]map[ManifestKey]bool),
+        index: newSearchIndex(),
+        installers: make(map[string]map[ManifestKey]bool),
+        latest: make(map[string]string),
+    }
+    for _, p := range packageVersions {
+        ms.Set(p.packageIdentifier, p.packageVersion, p.version())
+    }
+    return ms
+}
+
+// Returns the sorted PackageIdentifier/PackageVersion pairs of search results
+func resultKeys(results map[string][]API_ManifestVersionInterface) []string {
+    var keys []string
+    for packageIdentifier, versions := range results {
+        for _, version := range versions {
+            keys = append(keys, packageIdentifier + "/" + version.GetPackageVersion())
+        }
+    }
+    slices.Sort(keys)
+    return keys
+}
+
+func TestGetByMatchFilter(t *testing.T) {
+    ms := newTestStore(testPackageVersions)
+    tests := []struct {
+        field string
+        keyword string
+        matchType string
+        want []string
+    }{
+        {"PackageIdentifier", "Git.Git", "Exact", []string{"Git.Git/2.47.0"}},
+        {"PackageIdentifier", "git.git", "Exact", nil},
+        {"PackageIdentifier", "git.git", "CaseInsensitive", []string{"Git.Git/2.47.0"}},
+        {"PackageIdentifier", "Microsoft.Visual", "StartsWith", []string{"Microsoft.VisualStudio.2022.Community/17.12.0", "Microsoft.VisualStudioCode/1.95.0", "Microsoft.VisualStudioCode/1.96.0"}},
+        {"PackageIdentifier", "microsoft.visual", "StartsWith", nil},
+        {"PackageIdentifier", "Git", "StartsWith", []string{"Git.Git/2.47.0", "GitHub.cli/2.62.0"}},
+        {"PackageName", "code", "Substring", []string{"Microsoft.VisualStudioCode/1.95.0", "Microsoft.VisualStudioCode/1.96.0"}},
+        {"PackageName", "git*", "Wildcard", []string{"Git.Git/2.47.0", "GitHub.cli/2.62.0"}},
+        {"PackageName", "*studio*20??", "Wildcard", []string{"Microsoft.VisualStudio.2022.Community/17.12.0"}},
+        {"PackageName", "?it", "Wildcard", []string{"Git.Git/2.47.0"}},
+        {"PackageName", "notepad", "Wildcard", nil},
+        {"PackageName", "notepad", "Fuzzy", []string{"Notepad++.Notepad++/8.7"}},
+        {"PackageName", "Notepad ++", "Fuzzy", []string{"Notepad++.Notepad++/8.7"}},
+        {"PackageName", "github-cli", "Fuzzy", []string{"GitHub.cli/2.62.0"}},
+        {"PackageName", "visual studio", "FuzzySubstring", []string{"Microsoft.VisualStudio.2022.Community/17.12.0", "Microsoft.VisualStudioCode/1.95.0", "Microsoft.VisualStudioCode/1.96.0"}},
+        {"Tag", "editor", "Exact", []string{"Lapce.Lapce/0.4.2", "Microsoft.VisualStudioCode/1.95.0", "Microsoft.VisualStudioCode/1.96.0"}},
+        {"Tag", "editor", "CaseInsensitive", []string{"Lapce.Lapce/0.4.2", "Microsoft.VisualStudioCode/1.95.0", "Microsoft.VisualStudioCode/1.96.0", "Notepad++.Notepad++/8.7"}},
+        {"Tag", "developertools", "Fuzzy", []string{"Microsoft.VisualStudioCode/1.95.0", "Microsoft.VisualStudioCode/1.96.0"}},
+        {"Tag", "", "StartsWith", []string{"Git.Git/2.47.0", "GitHub.cli/2.62.0", "Lapce.Lapce/0.4.2", "Microsoft.VisualStudio.2022.Community/17.12.0", "Microsoft.VisualStudioCode/1.95.0", "Microsoft.VisualStudioCode/1.96.0", "Notepad++.Notepad++/8.7"}},
+        {"Tag", "editor", "Unknown", nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.field + " " + tt.matchType + " " + tt.keyword, func(t *testing.T) {
+            results, _ := ms.GetByMatchFilter(nil, []API_SearchRequestPackageMatchFilter_1_1_0{
+                {PackageMatchField: tt.field, RequestMatch: API_SearchRequestMatch_1_1_0{KeyWord: tt.keyword, MatchType: tt.matchType}},
+            })
+            if got := resultKeys(results); !slices.Equal(got, tt.want) {
+                t.Errorf("got %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestGetByKeyword(t *testing.T) {
+    ms := newTestStore(testPackageVersions)
+    tests := []struct {
+        keyword string
+        want []string
+    }{
+        {"lapce", []string{"Lapce.Lapce/0.4.2"}},
+        // Found in the ShortDescription
+        {"EDITOR", []string{"Lapce.Lapce/0.4.2", "Notepad++.Notepad++/8.7"}},
+        {"free", []string{"Git.Git/2.47.0", "Notepad++.Notepad++/8.7"}},
+        {"studio", []string{"Microsoft.VisualStudio.2022.Community/17.12.0", "Microsoft.VisualStudioCode/1.95.0", "Microsoft.VisualStudioCode/1.96.0"}},
+        // Tags are not searched by keyword
+        {"rust", []string{"Lapce.Lapce/0.4.2"}},
+        {"vcs", nil},
+        {"nothing like this", nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.keyword, func(t *testing.T) {
+            if got := resultKeys(ms.GetByKeyword(tt.keyword)); !slices.Equal(got, tt.want) {
+                t.Errorf("got %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
+
+// The index must find exactly what comparing every value of every package version finds,
+// also after package versions were replaced and removed
+func TestSearchIndexMatchesLinearScan(t *testing.T) {
+    ms := newTestStore(testPackageVersions)
+    linearScan := func(field string, requestMatch API_SearchRequestMatch_1_1_0) postings {
+        result := make(postings)
+        for packageIdentifier, versions := range ms.internal {
+            for _, version := range versions {
+                values, _ := packageMatchFieldValues(packageIdentifier, version, field)
+                if slices.ContainsFunc(values, func(value string) bool { return requestMatches(value, requestMatch) }) {
+                    result[ManifestKey{PackageIdentifier: packageIdentifier, PackageVersion: version.GetPackageVersion()}] = true
+                }
+            }
+        }
+        return result
+    }
+    check := func(t *testing.T) {
+        keywords := []string{"", "g", "G", "Git", "git", "Microsoft.", "microsoft.v", "code", "Code", "*", "*e", "?i*", "g*t*", "visualstudiocode", "Visual-Studio Code", "editor", "Editor", "ide", "++", "Z"}
+        matchTypes := []string{"Exact", "CaseInsensitive", "StartsWith", "Substring", "Wildcard", "Fuzzy", "FuzzySubstring"}
+        for field := range ms.index.fields {
+            for _, keyword := range keywords {
+                for _, matchType := range matchTypes {
+                    requestMatch := API_SearchRequestMatch_1_1_0{KeyWord: keyword, MatchType: matchType}
+                    got, _ := ms.index.search(field, requestMatch)
+                    if want := linearScan(field, requestMatch); !maps.Equal(got, want) {
+                        t.Errorf("%v %v %q: got %v, want %v", field, matchType, keyword, got, want)
+                    }
+                }
+            }
+        }
+    }
+
+    check(t)
+    renamed := testPackageVersions[3]
+    renamed.packageName = "Notepad Plus Plus"
+    renamed.tags = []string{"text"}
+    ms.Set(renamed.packageIdentifier, renamed.packageVersion, renamed.version())
+    ms.Lock()
+    ms.delete(ManifestKey{PackageIdentifier: "Git.Git", PackageVersion: "2.47.0"})
+    ms.delete(ManifestKey{PackageIdentifier: "Microsoft.VisualStudioCode", PackageVersion: "1.95.0"})
+    ms.Unlock()
+    check(t)
+}
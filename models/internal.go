@@ -2,8 +2,10 @@ package models
 
 import (
     "sync"
+    "slices"
     "strings"
     "reflect"
+    "path/filepath"
 
     "rewinged/logging"
 
@@ -15,6 +17,14 @@ import (
 type ManifestNode struct {
     BaseManifest `yaml:",inline"`
     Node yaml.Node `yaml:"-"`
+    // The file on disk this YAML document was read from
+    SourceFile string `yaml:"-"`
+}
+
+// Uniquely identifies one version of a package in the ManifestsStore
+type ManifestKey struct {
+    PackageIdentifier string
+    PackageVersion string
 }
 
 func getMapValues[M ~map[K]V, K comparable, V any](m M) []V {
@@ -25,13 +35,42 @@ func getMapValues[M ~map[K]V, K comparable, V any](m M) []V {
     return r
 }
 
+// Returns the package versions newest first, the order winget expects them in
+func getSortedVersions(versions map[string]API_ManifestVersionInterface) []API_ManifestVersionInterface {
+    r := getMapValues(versions)
+    SortVersionsNewestFirst(r)
+    return r
+}
+
+func newestVersion(versions map[string]API_ManifestVersionInterface) string {
+    var newest string
+    for version := range versions {
+        if newest == "" || compareNewestFirst(version, newest) < 0 {
+            newest = version
+        }
+    }
+    return newest
+}
+
+// Appends the values that are not empty and not in the slice yet
+func appendUnique(s []string, values ...string) []string {
+    for _, value := range values {
+        if value != "" && !slices.Contains(s, value) {
+            s = append(s, value)
+        }
+    }
+    return s
+}
+
 func caseInsensitiveContains(s, substr string) bool {
   s, substr = strings.ToUpper(s), strings.ToUpper(substr)
   return strings.Contains(s, substr)
 }
 
+// Returns all (nested) struct fields with the given name
 // Modified from: https://stackoverflow.com/a/38407429
-func findField(v interface{}, name string) reflect.Value {
+func findFields(v interface{}, name string) []reflect.Value {
+  var found []reflect.Value
   // create queue of values to search. Start with the function arg.
   queue := []reflect.Value{reflect.ValueOf(v)}
   for len(queue) > 0 {
@@ -43,56 +82,210 @@ func findField(v interface{}, name string) reflect.Value {
     }
     // check all elements in slices
     if v.Kind() == reflect.Slice {
-      if v.Len() > 0 {
-        for i := 0; i < v.Len(); i++ {
-          queue = append(queue, v.Index(i))
-        }
-      } else {
-        //fmt.Println("CONTINUE (EMPTY SLICE)")
-        continue
+      for i := 0; i < v.Len(); i++ {
+        queue = append(queue, v.Index(i))
       }
+      continue
     }
     // ignore if this is not a struct
     if v.Kind() != reflect.Struct {
-        //fmt.Println("CONTINUE (NOT STRUCT)")
         continue
     }
     // iterate through fields looking for match on name
     t := v.Type()
     for i := 0; i < v.NumField(); i++ {
-        //fmt.Println("TESTING FIELD", t.Field(i).Name)
         if t.Field(i).Name == name {
-            // found it!
-            //fmt.Println("FOUND THE FIELD", name, "!")
-            return v.Field(i)
+            found = append(found, v.Field(i))
+            continue
         }
         // push field to queue
         queue = append(queue, v.Field(i))
     }
   }
-  return reflect.Value{}
+  return found
 }
 
 // Internal in-memory data store of all manifest data
 type ManifestsStore struct {
     sync.RWMutex
     internal map[string]map[string]API_ManifestVersionInterface
+    // Remembers which manifest file(s) on disk produced each package version
+    // and the reverse, so that removed or renamed files can be correlated to
+    // the exact package versions they affect.
+    origins map[ManifestKey][]string
+    sources map[string]map[ManifestKey]bool
+    index *searchIndex
+    // The package versions with an installer of each InstallerSha256 (lowercase), so that
+    // requests for internalized installers don't have to look through every package version
+    installers map[string]map[ManifestKey]bool
+    // The newest PackageVersion of each package, so it doesn't have to be searched for
+    latest map[string]string
 }
 
-func (ms *ManifestsStore) Set(packageidentifier string, packageversion string, value API_ManifestVersionInterface) {
+// Set adds or replaces a package version. sourceFiles are the manifest files the
+// version was parsed from, any files that previously produced it are forgotten.
+func (ms *ManifestsStore) Set(packageidentifier string, packageversion string, value API_ManifestVersionInterface, sourceFiles ...string) {
     ms.Lock()
     vmap, ok := ms.internal[packageidentifier]
     if !ok {
         vmap = make(map[string]API_ManifestVersionInterface)
         ms.internal[packageidentifier] = vmap
     }
+    key := ManifestKey{PackageIdentifier: packageidentifier, PackageVersion: packageversion}
+    ms.forgetInstallers(key, vmap[packageversion])
     vmap[packageversion] = value
+    for _, installer := range value.GetInstallers() {
+        sha := strings.ToLower(installer.GetInstallerSha())
+        if ms.installers[sha] == nil {
+            ms.installers[sha] = make(map[ManifestKey]bool)
+        }
+        ms.installers[sha][key] = true
+    }
+    if latest, ok := ms.latest[packageidentifier]; !ok || compareNewestFirst(packageversion, latest) < 0 {
+        ms.latest[packageidentifier] = packageversion
+    }
+
+    ms.index.add(key, value)
+    ms.forgetOrigins(key)
+    for _, file := range sourceFiles {
+        if ms.sources[file] == nil {
+            ms.sources[file] = make(map[ManifestKey]bool)
+        }
+        ms.sources[file][key] = true
+        ms.origins[key] = append(ms.origins[key], file)
+    }
+    ms.Unlock()
+}
+
+// Removes a package version and its file associations. Caller must hold the write lock.
+func (ms *ManifestsStore) delete(key ManifestKey) {
+    ms.forgetInstallers(key, ms.internal[key.PackageIdentifier][key.PackageVersion])
+    delete(ms.internal[key.PackageIdentifier], key.PackageVersion)
+    if len(ms.internal[key.PackageIdentifier]) == 0 {
+        delete(ms.internal, key.PackageIdentifier)
+        delete(ms.latest, key.PackageIdentifier)
+    } else if ms.latest[key.PackageIdentifier] == key.PackageVersion {
+        ms.latest[key.PackageIdentifier] = newestVersion(ms.internal[key.PackageIdentifier])
+    }
+    ms.index.remove(key)
+    ms.forgetOrigins(key)
+}
+
+// Removes a package version from the installer index. Caller must hold the write lock.
+func (ms *ManifestsStore) forgetInstallers(key ManifestKey, version API_ManifestVersionInterface) {
+    if version == nil {
+        return
+    }
+    for _, installer := range version.GetInstallers() {
+        sha := strings.ToLower(installer.GetInstallerSha())
+        delete(ms.installers[sha], key)
+        if len(ms.installers[sha]) == 0 {
+            delete(ms.installers, sha)
+        }
+    }
+}
+
+// Caller must hold the write lock.
+func (ms *ManifestsStore) forgetOrigins(key ManifestKey) {
+    for _, file := range ms.origins[key] {
+        delete(ms.sources[file], key)
+        if len(ms.sources[file]) == 0 {
+            delete(ms.sources, file)
+        }
+    }
+    delete(ms.origins, key)
+}
+
+// PruneDirectory evicts all package versions that were produced from manifest files
+// directly inside dir, except for those in keep. This is used after a directory was
+// (re-)ingested to drop versions whose files were deleted, renamed or edited to
+// describe a different PackageIdentifier or PackageVersion. Returns the evicted versions.
+func (ms *ManifestsStore) PruneDirectory(dir string, keep map[ManifestKey]bool) []ManifestKey {
+    var evicted []ManifestKey
+    ms.Lock()
+    for file, keys := range ms.sources {
+        if filepath.Dir(file) != dir {
+            continue
+        }
+        for key := range keys {
+            if !keep[key] && !slices.Contains(evicted, key) {
+                evicted = append(evicted, key)
+            }
+        }
+    }
+    for _, key := range evicted {
+        ms.delete(key)
+    }
     ms.Unlock()
+    return evicted
+}
+
+// RemoveSourcesBelow evicts all package versions that were produced from manifest
+// files anywhere below the directory dir, for when a whole directory disappears.
+// Returns the evicted versions.
+func (ms *ManifestsStore) RemoveSourcesBelow(dir string) []ManifestKey {
+    var evicted []ManifestKey
+    prefix := filepath.Clean(dir) + string(filepath.Separator)
+    ms.Lock()
+    for file, keys := range ms.sources {
+        if !strings.HasPrefix(file, prefix) {
+            continue
+        }
+        for key := range keys {
+            if !slices.Contains(evicted, key) {
+                evicted = append(evicted, key)
+            }
+        }
+    }
+    for _, key := range evicted {
+        ms.delete(key)
+    }
+    ms.Unlock()
+    return evicted
+}
+
+// GetSourceFiles returns the paths of all manifest files that currently contribute
+// at least one package version to the store.
+func (ms *ManifestsStore) GetSourceFiles() []string {
+    ms.RLock()
+    files := make([]string, 0, len(ms.sources))
+    for file := range ms.sources {
+        files = append(files, file)
+    }
+    ms.RUnlock()
+    return files
+}
+
+// GetSourceFilesOf returns the manifest files a package version was parsed from.
+func (ms *ManifestsStore) GetSourceFilesOf(packageidentifier string, packageversion string) []string {
+    ms.RLock()
+    files := append([]string{}, ms.origins[ManifestKey{PackageIdentifier: packageidentifier, PackageVersion: packageversion}]...)
+    ms.RUnlock()
+    return files
+}
+
+// GetPackageVersionsFrom returns the package versions a manifest file contributes to.
+func (ms *ManifestsStore) GetPackageVersionsFrom(file string) []ManifestKey {
+    ms.RLock()
+    keys := make([]ManifestKey, 0, len(ms.sources[file]))
+    for key := range ms.sources[file] {
+        keys = append(keys, key)
+    }
+    ms.RUnlock()
+    return keys
 }
 
 func (ms *ManifestsStore) GetAllVersions(packageidentifier string) (value []API_ManifestVersionInterface) {
     ms.RLock()
-    result := getMapValues(ms.internal[packageidentifier])
+    result := getSortedVersions(ms.internal[packageidentifier])
+    ms.RUnlock()
+    return result
+}
+
+// GetLatestVersion returns the newest version of a package, or nil if there is no such package
+func (ms *ManifestsStore) GetLatestVersion(packageidentifier string) (value API_ManifestVersionInterface) {
+    ms.RLock()
+    result := ms.internal[packageidentifier][ms.latest[packageidentifier]]
     ms.RUnlock()
     return result
 }
@@ -108,12 +301,45 @@ func (ms *ManifestsStore) GetAll() (value map[string][]API_ManifestVersionInterf
     ms.RLock()
     var m = make(map[string][]API_ManifestVersionInterface)
     for k := range ms.internal {
-        m[k] = getMapValues(ms.internal[k])
+        m[k] = getSortedVersions(ms.internal[k])
     }
     ms.RUnlock()
     return m
 }
 
+// Returns the PackageIdentifiers of all packages with an installer of this InstallerSha256
+func (ms *ManifestsStore) GetPackageIdentifiersByInstallerSha(installerSha256 string) []string {
+    ms.RLock()
+    defer ms.RUnlock()
+    var packageIdentifiers []string
+    for key := range ms.installers[strings.ToLower(installerSha256)] {
+        packageIdentifiers = appendUnique(packageIdentifiers, key.PackageIdentifier)
+    }
+    return packageIdentifiers
+}
+
+// Returns all package versions with an installer of this InstallerSha256
+func (ms *ManifestsStore) GetPackageVersionsByInstallerSha(installerSha256 string) []ManifestKey {
+    ms.RLock()
+    defer ms.RUnlock()
+    keys := make([]ManifestKey, 0, len(ms.installers[strings.ToLower(installerSha256)]))
+    for key := range ms.installers[strings.ToLower(installerSha256)] {
+        keys = append(keys, key)
+    }
+    return keys
+}
+
+// Returns the InstallerSha256 of every installer of every package version, in lowercase
+func (ms *ManifestsStore) GetInstallerShas() map[string]bool {
+    ms.RLock()
+    defer ms.RUnlock()
+    installerShas := make(map[string]bool, len(ms.installers))
+    for sha := range ms.installers {
+        installerShas[sha] = true
+    }
+    return installerShas
+}
+
 func (ms *ManifestsStore) GetAllPackageIdentifiers() (value []API_Package) {
     ms.RLock()
     var p []API_Package
@@ -123,6 +349,10 @@ func (ms *ManifestsStore) GetAllPackageIdentifiers() (value []API_Package) {
         })
     }
     ms.RUnlock()
+    // Always return packages in the same order so they can be paginated
+    slices.SortFunc(p, func(a, b API_Package) int {
+        return strings.Compare(a.PackageIdentifier, b.PackageIdentifier)
+    })
     return p
 }
 
@@ -134,193 +364,110 @@ func (ms *ManifestsStore) GetManifestCount() (value int) {
     return count
 }
 
+// GetPackageVersionCount returns the number of versions of all packages together
+func (ms *ManifestsStore) GetPackageVersionCount() int {
+    ms.RLock()
+    defer ms.RUnlock()
+    var count int
+    for _, versions := range ms.internal {
+        count += len(versions)
+    }
+    return count
+}
+
 func (ms *ManifestsStore) GetByKeyword (keyword string) map[string][]API_ManifestVersionInterface {
   var manifestResultsMap = make(map[string][]API_ManifestVersionInterface)
   ms.RLock()
-  for packageIdentifier, packageVersions := range ms.internal {
-    for _, version := range packageVersions {
-      if caseInsensitiveContains(version.GetDefaultLocalePackageName(), keyword) || caseInsensitiveContains(version.GetDefaultLocaleShortDescription(), keyword) {
-        manifestResultsMap[packageIdentifier] = append(manifestResultsMap[packageIdentifier], version)
-      }
-    }
+  for key := range ms.index.searchKeyword(keyword) {
+    manifestResultsMap[key.PackageIdentifier] = append(manifestResultsMap[key.PackageIdentifier], ms.internal[key.PackageIdentifier][key.PackageVersion])
   }
   ms.RUnlock()
 
+  for _, packageVersions := range manifestResultsMap {
+    SortVersionsNewestFirst(packageVersions)
+  }
   return manifestResultsMap
 }
 
+// GetByMatchFilter returns all package versions matching all of the filters and
+// at least one of the inclusions (if there are any), as well as the list of
+// PackageMatchFields in the request that are not supported and were ignored.
 func (ms *ManifestsStore) GetByMatchFilter (
   inclusions []API_SearchRequestPackageMatchFilter_1_1_0,
   filters []API_SearchRequestPackageMatchFilter_1_1_0,
 ) (
   map[string][]API_ManifestVersionInterface,
+  []string,
 ) {
   var manifestResultsMap = make(map[string][]API_ManifestVersionInterface)
-  normalizeReplacer := strings.NewReplacer(" ", "", "-", "", "+", "")
+  var unsupportedPackageMatchFields = []string{}
 
   ms.RLock()
-  for packageIdentifier, packageVersions := range ms.internal {
-    // Loop through every version of the package as well because MatchFields like
-    // ProductCode, PackageName etc. can change between versions.
-    NEXT_VERSION:
-    for _, packageVersion := range packageVersions {
-      // From what I can gather from https://github.com/microsoft/winget-cli-restsource/blob/01542050d79da0efbd11c0a5be543cb970b86eb9/src/WinGet.RestSource/Cosmos/CosmosDataStore.cs#L452
-      // the difference between inclusions and filters are that inclusions are evaluated with a logical OR (only one of them has to match) and filters are evaluated with a logical AND
-      // (all filter specified have to match) - so this is what I implemented here. But I am not 100% sure this is the correct/intended use for inclusions vs. filters.
-
-      // process filters (if any)
-      for _, filter := range filters {
-        var requestMatchValue string
-
-        switch filter.PackageMatchField {
-          case "NormalizedPackageNameAndPublisher":
-            // winget only ever sends the package / software name, the publisher isn't included so to
-            // enable proper matching we also only compare against the normalized packagename.
-            requestMatchValue = normalizeReplacer.Replace(strings.ToLower(packageVersion.GetDefaultLocalePackageName()))
-          case "PackageIdentifier":
-            // We don't need to recursively search for this field, it's easy to get to
-            requestMatchValue = packageIdentifier
-          case "PackageName":
-            fallthrough
-          case "Moniker":
-            fallthrough
-          case "Command":
-            fallthrough
-          case "Tag":
-            fallthrough
-          case "PackageFamilyName":
-            fallthrough
-          case "ProductCode":
-            fallthrough
-          case "Market":
-            fallthrough
-          default:
-            // Just search the whole struct for a field with the right name
-            // Get the value of a nested struct field passing in the field name to search for as a string
-            // Source: https://stackoverflow.com/a/38407429
-            f := findField(packageVersion, string(filter.PackageMatchField))
-            requestMatchValue = string(f.String())
-        }
+  defer ms.RUnlock()
 
-        // Because all filters (if any) must match (logical AND)
-        // we just skip to the next packageversion if any did not match
-        switch filter.RequestMatch.MatchType {
-          // TODO: `winget list -s rewinged-local -q lapce` searches for the ProductCode with MatchType Exact
-          // Why does it use MatchType Exact?? Does the reference / official source normalize all ProductCodes on ingest??
-          case "Exact":
-            if requestMatchValue != filter.RequestMatch.KeyWord {
-              continue NEXT_VERSION
-            }
-          case "CaseInsensitive":
-            if !strings.EqualFold(requestMatchValue, filter.RequestMatch.KeyWord) {
-              continue NEXT_VERSION
-            }
-          case "StartsWith":
-            // StartsWith is implemented as case-sensitive, because it is that way in the reference implementation as well:
-            // https://github.com/microsoft/winget-cli-restsource/blob/01542050d79da0efbd11c0a5be543cb970b86eb9/src/WinGet.RestSource/Cosmos/PredicateGenerator.cs#L92-L102
-            if !strings.HasPrefix(requestMatchValue, filter.RequestMatch.KeyWord) {
-              continue NEXT_VERSION
-            }
-          case "Substring":
-            // Substring comparison is case-insensitive, because it is that way in the reference implementation as well:
-            // https://github.com/microsoft/winget-cli-restsource/blob/01542050d79da0efbd11c0a5be543cb970b86eb9/src/WinGet.RestSource/Cosmos/PredicateGenerator.cs#L92-L102
-            if !caseInsensitiveContains(requestMatchValue, filter.RequestMatch.KeyWord) {
-              continue NEXT_VERSION
-            }
-          default:
-            // Unimplemented: Wildcard, Fuzzy, FuzzySubstring
-        }
-      }
+  for _, matchFilter := range slices.Concat(filters, inclusions) {
+    if !ms.index.supports(matchFilter.PackageMatchField) && !slices.Contains(unsupportedPackageMatchFields, matchFilter.PackageMatchField) {
+      logging.Logger.Debug().Msgf("ignoring unsupported PackageMatchField %v", matchFilter.PackageMatchField)
+      unsupportedPackageMatchFields = append(unsupportedPackageMatchFields, matchFilter.PackageMatchField)
+    }
+  }
 
-      if len(inclusions) == 0 {
-        manifestResultsMap[packageIdentifier] = append(manifestResultsMap[packageIdentifier], packageVersion)
-        continue NEXT_VERSION
-      }
+  // From what I can gather from https://github.com/microsoft/winget-cli-restsource/blob/01542050d79da0efbd11c0a5be543cb970b86eb9/src/WinGet.RestSource/Cosmos/CosmosDataStore.cs#L452
+  // the difference between inclusions and filters are that inclusions are evaluated with a logical OR (only one of them has to match) and filters are evaluated with a logical AND
+  // (all filter specified have to match) - so this is what I implemented here. But I am not 100% sure this is the correct/intended use for inclusions vs. filters.
 
-      var anyInclusionMatched bool
-      anyInclusionMatched = true
-      // process inclusions (if any)
-      NEXT_INCLUSION:
-      for _, inclusion := range inclusions {
-        var requestMatchValue string
-        anyInclusionMatched = false
-
-        switch inclusion.PackageMatchField {
-          case "NormalizedPackageNameAndPublisher":
-            // winget only ever sends the package / software name, the publisher isn't included so to
-            // enable proper matching we also only compare against the normalized packagename.
-            requestMatchValue = normalizeReplacer.Replace(strings.ToLower(packageVersion.GetDefaultLocalePackageName()))
-          case "PackageIdentifier":
-            // We don't need to recursively search for this field, it's easy to get to
-            requestMatchValue = packageIdentifier
-          case "PackageName":
-            fallthrough
-          case "Moniker":
-            fallthrough
-          case "Command":
-            fallthrough
-          case "Tag":
-            fallthrough
-          case "PackageFamilyName":
-            fallthrough
-          case "ProductCode":
-            fallthrough
-          case "Market":
-            fallthrough
-          default:
-            // Just search the whole struct for a field with the right name
-            // Get the value of a nested struct field passing in the field name to search for as a string
-            // Source: https://stackoverflow.com/a/38407429
-            f := findField(packageVersion, string(inclusion.PackageMatchField))
-            requestMatchValue = string(f.String())
-        }
+  // nil means every package version is still a candidate
+  var candidates postings
 
-        switch inclusion.RequestMatch.MatchType {
-          // TODO: `winget list -s rewinged-local -q lapce` searches for the ProductCode with MatchType Exact
-          // Why does it use MatchType Exact?? Does the reference / official source normalize all ProductCodes on ingest??
-          case "Exact":
-            if requestMatchValue == inclusion.RequestMatch.KeyWord {
-              // Break out of the inclusions loop after one successful match
-              anyInclusionMatched = true
-              break NEXT_INCLUSION
-            }
-          case "CaseInsensitive":
-            if strings.EqualFold(requestMatchValue, inclusion.RequestMatch.KeyWord) {
-              // Break out of the inclusions loop after one successful match
-              anyInclusionMatched = true
-              break NEXT_INCLUSION
-            }
-          case "StartsWith":
-            // StartsWith is implemented as case-sensitive, because it is that way in the reference implementation as well:
-            // https://github.com/microsoft/winget-cli-restsource/blob/01542050d79da0efbd11c0a5be543cb970b86eb9/src/WinGet.RestSource/Cosmos/PredicateGenerator.cs#L92-L102
-            if strings.HasPrefix(requestMatchValue, inclusion.RequestMatch.KeyWord) {
-              // Break out of the inclusions loop after one successful match
-              anyInclusionMatched = true
-              break NEXT_INCLUSION
-            }
-          case "Substring":
-            // Substring comparison is case-insensitive, because it is that way in the reference implementation as well:
-            // https://github.com/microsoft/winget-cli-restsource/blob/01542050d79da0efbd11c0a5be543cb970b86eb9/src/WinGet.RestSource/Cosmos/PredicateGenerator.cs#L92-L102
-            if caseInsensitiveContains(requestMatchValue, inclusion.RequestMatch.KeyWord) {
-              // Break out of the inclusions loop after one successful match
-              anyInclusionMatched = true
-              break NEXT_INCLUSION
-            }
-          default:
-            // Unimplemented: Wildcard, Fuzzy, FuzzySubstring
-        }
-      }
+  for _, filter := range filters {
+    matches, supported := ms.index.search(filter.PackageMatchField, filter.RequestMatch)
+    // Unsupported filters are reported back to the client and otherwise ignored
+    if !supported {
+      continue
+    }
+    candidates = intersectPostings(candidates, matches)
+  }
 
-      // All filters and inclusions have passed for this manifest, add it to the returned map
-      if anyInclusionMatched {
-        logging.Logger.Debug().Msgf("adding to the results map: %v version %v", packageIdentifier, packageVersion.GetPackageVersion())
-        manifestResultsMap[packageIdentifier] = append(manifestResultsMap[packageIdentifier], packageVersion)
+  if len(inclusions) > 0 {
+    included := make(postings)
+    for _, inclusion := range inclusions {
+      matches, _ := ms.index.search(inclusion.PackageMatchField, inclusion.RequestMatch)
+      for key := range matches {
+        included[key] = true
       }
     }
+    candidates = intersectPostings(candidates, included)
   }
-  ms.RUnlock()
 
-  return manifestResultsMap
+  if candidates == nil {
+    for packageIdentifier, packageVersions := range ms.internal {
+      manifestResultsMap[packageIdentifier] = getSortedVersions(packageVersions)
+    }
+    return manifestResultsMap, unsupportedPackageMatchFields
+  }
+
+  for key := range candidates {
+    logging.Logger.Trace().Msgf("adding to the results map: %v version %v", key.PackageIdentifier, key.PackageVersion)
+    manifestResultsMap[key.PackageIdentifier] = append(manifestResultsMap[key.PackageIdentifier], ms.internal[key.PackageIdentifier][key.PackageVersion])
+  }
+  for _, packageVersions := range manifestResultsMap {
+    SortVersionsNewestFirst(packageVersions)
+  }
+
+  return manifestResultsMap, unsupportedPackageMatchFields
+}
+
+// Returns the package versions in both a and b, where nil stands for all package versions
+func intersectPostings(a postings, b postings) postings {
+  if a == nil {
+    return b
+  }
+  for key := range a {
+    if !b[key] {
+      delete(a, key)
+    }
+  }
+  return a
 }
 
 // This function takes two values and returns
@@ -336,12 +483,13 @@ func isDefault(v reflect.Value) bool {
   return v.IsZero()
 }
 
-// This map is used to remember which InstallerSHAs were successfully
-// internalized on manifest ingestion and can have their InstallerUrls rewritten.
-var InternalizedInstallers = make(map[string]bool)
-
 // Global variable that will hold all in-memory manifest data
 var Manifests = ManifestsStore{
     internal: make(map[string]map[string]API_ManifestVersionInterface),
+    origins: make(map[ManifestKey][]string),
+    sources: make(map[string]map[ManifestKey]bool),
+    index: newSearchIndex(),
+    installers: make(map[string]map[ManifestKey]bool),
+    latest: make(map[string]string),
 }
 
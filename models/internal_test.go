@@ -0,0 +1,269 @@
+package models
+
+import (
+    "slices"
+    "strings"
+    "testing"
+    "path/filepath"
+)
+
+// A package version as ingested from manifest files below /manifests
+type testSource struct {
+    packageIdentifier string
+    packageVersion string
+    installerSha256 string
+    files []string
+}
+
+var testSources = []testSource{
+    {"Contoso.App", "1.0.0", "AAAA", []string{"contoso/app/1.0.0/Contoso.App.yaml"}},
+    {"Contoso.App", "2.0.0", "BBBB", []string{"contoso/app/2.0.0/Contoso.App.yaml", "contoso/app/2.0.0/Contoso.App.installer.yaml", "contoso/app/2.0.0/Contoso.App.locale.en-US.yaml"}},
+    {"Contoso.Application", "1.0.0", "CCCC", []string{"contoso/application/1.0.0/Contoso.Application.yaml"}},
+    {"Fabrikam.Tool", "1.0.0", "AAAA", []string{"fabrikam/tool/1.0.0/Fabrikam.Tool.yaml"}},
+}
+
+func testSourceFile(file string) string {
+    return filepath.Join(string(filepath.Separator) + "manifests", filepath.FromSlash(file))
+}
+
+func (s testSource) set(ms *ManifestsStore) {
+    version := API_ManifestVersion_1_10_0{PackageVersion: s.packageVersion}
+    version.DefaultLocale.PackageName = s.packageIdentifier
+    version.Installers = []API_Installer_1_10_0{{InstallerSha256: s.installerSha256}}
+    var files []string
+    for _, file := range s.files {
+        files = append(files, testSourceFile(file))
+    }
+    ms.Set(s.packageIdentifier, s.packageVersion, version, files...)
+}
+
+func newSourcesTestStore() *ManifestsStore {
+    ms := newTestStore(nil)
+    for _, s := range testSources {
+        s.set(ms)
+    }
+    return ms
+}
+
+// Returns the sorted PackageIdentifier/PackageVersion pairs
+func keyStrings(keys []ManifestKey) []string {
+    var s []string
+    for _, key := range keys {
+        s = append(s, key.PackageIdentifier + "/" + key.PackageVersion)
+    }
+    slices.Sort(s)
+    return s
+}
+
+// Checks that exactly the package versions in want are left, and that the evicted ones
+// are gone from the search index and the installer index as well
+func checkRemaining(t *testing.T, ms *ManifestsStore, want []string) {
+    t.Helper()
+    var got []string
+    for _, s := range testSources {
+        if ms.Get(s.packageIdentifier, s.packageVersion) != nil {
+            got = append(got, s.packageIdentifier + "/" + s.packageVersion)
+        }
+    }
+    slices.Sort(got)
+    if !slices.Equal(got, want) {
+        t.Errorf("got package versions %v, want %v", got, want)
+    }
+    if searched := resultKeys(ms.GetByKeyword("o")); !slices.Equal(searched, want) {
+        t.Errorf("got search results %v, want %v", searched, want)
+    }
+    var indexed []string
+    for _, sha := range []string{"aaaa", "bbbb", "cccc"} {
+        indexed = append(indexed, keyStrings(ms.GetPackageVersionsByInstallerSha(sha))...)
+    }
+    slices.Sort(indexed)
+    if !slices.Equal(indexed, want) {
+        t.Errorf("got package versions by installer %v, want %v", indexed, want)
+    }
+}
+
+func TestRemoveSourcesBelow(t *testing.T) {
+    tests := []struct {
+        name string
+        dir string
+        wantEvicted []string
+    }{
+        {"version directory", "contoso/app/2.0.0", []string{"Contoso.App/2.0.0"}},
+        {"package directory", "contoso/app", []string{"Contoso.App/1.0.0", "Contoso.App/2.0.0"}},
+        {"publisher directory", "contoso", []string{"Contoso.App/1.0.0", "Contoso.App/2.0.0", "Contoso.Application/1.0.0"}},
+        {"trailing separator", "fabrikam/", []string{"Fabrikam.Tool/1.0.0"}},
+        {"all manifests", "", []string{"Contoso.App/1.0.0", "Contoso.App/2.0.0", "Contoso.Application/1.0.0", "Fabrikam.Tool/1.0.0"}},
+        // Only whole path elements count, contoso/app is not a prefix of contoso/application
+        {"directory with a common prefix", "contoso/app/1", nil},
+        {"unknown directory", "wingetcreate", nil},
+        // A manifest file is not a directory
+        {"file", "contoso/app/1.0.0/Contoso.App.yaml", nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            ms := newSourcesTestStore()
+            evicted := ms.RemoveSourcesBelow(testSourceFile(tt.dir))
+            if got := keyStrings(evicted); !slices.Equal(got, tt.wantEvicted) {
+                t.Errorf("got evicted %v, want %v", got, tt.wantEvicted)
+            }
+            var want []string
+            for _, s := range testSources {
+                if key := s.packageIdentifier + "/" + s.packageVersion; !slices.Contains(tt.wantEvicted, key) {
+                    want = append(want, key)
+                }
+            }
+            checkRemaining(t, ms, want)
+            for _, file := range ms.GetSourceFiles() {
+                if strings.HasPrefix(file, testSourceFile(tt.dir) + string(filepath.Separator)) {
+                    t.Errorf("the source file %v is still known", file)
+                }
+            }
+        })
+    }
+}
+
+func TestPruneDirectory(t *testing.T) {
+    tests := []struct {
+        name string
+        dir string
+        // The manifest files found when the directory was ingested again
+        reingested []testSource
+        wantEvicted []string
+    }{
+        {"unchanged", "contoso/app/2.0.0", []testSource{testSources[1]}, nil},
+        {"all files removed", "contoso/app/2.0.0", nil, []string{"Contoso.App/2.0.0"}},
+        {
+            "file renamed",
+            "contoso/app/1.0.0",
+            []testSource{{"Contoso.App", "1.0.0", "AAAA", []string{"contoso/app/1.0.0/contoso-app.yaml"}}},
+            nil,
+        },
+        {
+            "file edited to describe another PackageVersion",
+            "contoso/app/1.0.0",
+            []testSource{{"Contoso.App", "1.0.1", "AAAA", []string{"contoso/app/1.0.0/Contoso.App.yaml"}}},
+            []string{"Contoso.App/1.0.0"},
+        },
+        {
+            "file edited to describe another PackageIdentifier",
+            "fabrikam/tool/1.0.0",
+            []testSource{{"Fabrikam.Toolkit", "1.0.0", "AAAA", []string{"fabrikam/tool/1.0.0/Fabrikam.Tool.yaml"}}},
+            []string{"Fabrikam.Tool/1.0.0"},
+        },
+        // Only manifest files directly inside the directory are ingested together
+        {"parent directory", "contoso/app", nil, nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            ms := newSourcesTestStore()
+            keep := make(map[ManifestKey]bool)
+            for _, s := range tt.reingested {
+                s.set(ms)
+                keep[ManifestKey{PackageIdentifier: s.packageIdentifier, PackageVersion: s.packageVersion}] = true
+            }
+            evicted := ms.PruneDirectory(testSourceFile(tt.dir), keep)
+            if got := keyStrings(evicted); !slices.Equal(got, tt.wantEvicted) {
+                t.Errorf("got evicted %v, want %v", got, tt.wantEvicted)
+            }
+            for _, s := range tt.reingested {
+                var want []string
+                for _, file := range s.files {
+                    want = append(want, testSourceFile(file))
+                }
+                if got := ms.GetSourceFilesOf(s.packageIdentifier, s.packageVersion); !slices.Equal(got, want) {
+                    t.Errorf("got source files %v of %v/%v, want %v", got, s.packageIdentifier, s.packageVersion, want)
+                }
+            }
+            for _, key := range tt.wantEvicted {
+                for _, s := range testSources {
+                    if s.packageIdentifier + "/" + s.packageVersion == key && ms.Get(s.packageIdentifier, s.packageVersion) != nil {
+                        t.Errorf("%v is still there", key)
+                    }
+                }
+            }
+        })
+    }
+}
+
+// A package version whose manifest moved to another file only depends on the new one
+func TestMoveVersionToNewSourceFile(t *testing.T) {
+    ms := newSourcesTestStore()
+    moved := testSource{"Contoso.App", "1.0.0", "AAAA", []string{"contoso/app/1.0.0-moved/Contoso.App.yaml"}}
+    moved.set(ms)
+
+    if got, want := ms.GetSourceFilesOf("Contoso.App", "1.0.0"), []string{testSourceFile(moved.files[0])}; !slices.Equal(got, want) {
+        t.Errorf("got source files %v, want %v", got, want)
+    }
+    oldFile := testSourceFile(testSources[0].files[0])
+    if got := ms.GetPackageVersionsFrom(oldFile); len(got) != 0 {
+        t.Errorf("got package versions %v from the old file", got)
+    }
+    if slices.Contains(ms.GetSourceFiles(), oldFile) {
+        t.Errorf("the old file %v is still a source file", oldFile)
+    }
+
+    // The old file being removed afterwards doesn't evict the package version
+    if evicted := ms.PruneDirectory(filepath.Dir(oldFile), map[ManifestKey]bool{}); len(evicted) != 0 {
+        t.Errorf("pruning the old directory evicted %v", evicted)
+    }
+    if evicted := ms.RemoveSourcesBelow(filepath.Dir(oldFile)); len(evicted) != 0 {
+        t.Errorf("removing the old directory evicted %v", evicted)
+    }
+    checkRemaining(t, ms, []string{"Contoso.App/1.0.0", "Contoso.App/2.0.0", "Contoso.Application/1.0.0", "Fabrikam.Tool/1.0.0"})
+
+    // Unlike removing the new one
+    if evicted := keyStrings(ms.RemoveSourcesBelow(testSourceFile("contoso/app/1.0.0-moved"))); !slices.Equal(evicted, []string{"Contoso.App/1.0.0"}) {
+        t.Errorf("removing the new directory evicted %v", evicted)
+    }
+    if got := ms.GetSourceFilesOf("Contoso.App", "1.0.0"); len(got) != 0 {
+        t.Errorf("got source files %v of an evicted package version", got)
+    }
+    checkRemaining(t, ms, []string{"Contoso.App/2.0.0", "Contoso.Application/1.0.0", "Fabrikam.Tool/1.0.0"})
+}
+
+// The newest PackageVersion is kept track of as versions come and go
+func TestGetLatestVersion(t *testing.T) {
+    ms := newTestStore(nil)
+    steps := []struct {
+        name string
+        set string
+        delete string
+        // Empty if the package is gone
+        want string
+    }{
+        {"first version", "1.2", "", "1.2"},
+        {"newer version", "1.10", "", "1.10"},
+        {"older version", "1.9.9", "", "1.10"},
+        {"prerelease of the latest version", "1.10-beta", "", "1.10"},
+        {"same version again", "1.10", "", "1.10"},
+        // A prerelease of 1.10 is still newer than 1.9.9
+        {"latest version deleted", "", "1.10", "1.10-beta"},
+        {"older version deleted", "", "1.2", "1.10-beta"},
+        {"latest version deleted again", "", "1.10-beta", "1.9.9"},
+        {"last version deleted", "", "1.9.9", ""},
+        {"version of a package that was gone", "0.1", "", "0.1"},
+    }
+    for _, step := range steps {
+        if step.set != "" {
+            ms.Set("Contoso.App", step.set, API_ManifestVersion_1_10_0{PackageVersion: step.set})
+        }
+        if step.delete != "" {
+            ms.Lock()
+            ms.delete(ManifestKey{PackageIdentifier: "Contoso.App", PackageVersion: step.delete})
+            ms.Unlock()
+        }
+        latest := ms.GetLatestVersion("Contoso.App")
+        if step.want == "" && latest != nil {
+            t.Errorf("%v: got %v, want no version", step.name, latest.GetPackageVersion())
+        } else if step.want != "" && (latest == nil || latest.GetPackageVersion() != step.want) {
+            t.Errorf("%v: got %v, want %v", step.name, latest, step.want)
+        }
+        if _, ok := ms.latest["Contoso.App"]; ok != (step.want != "") {
+            t.Errorf("%v: got a latest version remembered %v for a package with versions %v", step.name, ok, len(ms.internal["Contoso.App"]))
+        }
+    }
+
+    if latest := ms.GetLatestVersion("Contoso.Unknown"); latest != nil {
+        t.Errorf("got %v for an unknown package", latest)
+    }
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+    "slices"
+    "strings"
+    "sync"
+    "time"
+
+    "rewinged/installerinfo"
+)
+
+// An installer that was downloaded for auto-internalization and is served by rewinged itself
+type InternalizedInstaller struct {
+    InstallerSha256 string // always lowercase, also the name of the file
+    Path string
+    Size int64
+    SourceURL string // the original InstallerUrl it was downloaded from
+    DownloadTime time.Time // for installers that were already downloaded before rewinged started, when the file was last modified
+    Metadata *installerinfo.Metadata // nil if the installer type isn't supported
+}
+
+// Registry of all internalized installers, keyed by their InstallerSha256. It is
+// written by the manifest ingest workers and the installer download workers while
+// the API handlers read it concurrently, so all access has to go through its methods.
+type InternalizedInstallersRegistry struct {
+    sync.RWMutex
+    internal map[string]InternalizedInstaller
+}
+
+func (ir *InternalizedInstallersRegistry) Set(installer InternalizedInstaller) {
+    installer.InstallerSha256 = strings.ToLower(installer.InstallerSha256)
+    ir.Lock()
+    ir.internal[installer.InstallerSha256] = installer
+    ir.Unlock()
+}
+
+func (ir *InternalizedInstallersRegistry) Remove(installerSha256 string) {
+    ir.Lock()
+    delete(ir.internal, strings.ToLower(installerSha256))
+    ir.Unlock()
+}
+
+func (ir *InternalizedInstallersRegistry) Get(installerSha256 string) (InternalizedInstaller, bool) {
+    ir.RLock()
+    installer, ok := ir.internal[strings.ToLower(installerSha256)]
+    ir.RUnlock()
+    return installer, ok
+}
+
+// Reports whether the installer with this InstallerSha256 is served by rewinged,
+// which means the InstallerUrls referencing it can be rewritten to point to rewinged
+func (ir *InternalizedInstallersRegistry) IsInternalized(installerSha256 string) bool {
+    _, ok := ir.Get(installerSha256)
+    return ok
+}
+
+// Returns all internalized installers, sorted by InstallerSha256
+func (ir *InternalizedInstallersRegistry) GetAll() []InternalizedInstaller {
+    ir.RLock()
+    installers := make([]InternalizedInstaller, 0, len(ir.internal))
+    for _, installer := range ir.internal {
+        installers = append(installers, installer)
+    }
+    ir.RUnlock()
+    slices.SortFunc(installers, func(a, b InternalizedInstaller) int {
+        return strings.Compare(a.InstallerSha256, b.InstallerSha256)
+    })
+    return installers
+}
+
+func (ir *InternalizedInstallersRegistry) Count() int {
+    ir.RLock()
+    defer ir.RUnlock()
+    return len(ir.internal)
+}
+
+// Global variable that will hold all internalized installers
+var InternalizedInstallers = InternalizedInstallersRegistry{
+    internal: make(map[string]InternalizedInstaller),
+}
@@ -29,6 +29,7 @@ type Manifest_SingletonManifest_1_10_0 struct {
     DownloadCommandProhibited bool `yaml:"DownloadCommandProhibited" json:",omitempty"`
     RepairBehavior string `yaml:"RepairBehavior" json:",omitempty"`
     ArchiveBinariesDependOnPath bool `yaml:"ArchiveBinariesDependOnPath" json:",omitempty"`
+    Channel string `yaml:"Channel"`
     Installers [1]Manifest_Installer_1_10_0 `yaml:"Installers"`
     ManifestType string `yaml:"ManifestType"`
     ManifestVersion string `yaml:"ManifestVersion"`
@@ -50,7 +51,7 @@ func (in *Manifest_SingletonManifest_1_10_0) ToApiManifest() API_ManifestInterfa
           Moniker: in.Moniker,
           Tags: in.Tags,
         },
-        Channel: "",
+        Channel: in.Channel,
         Locales: []API_Locale_1_10_0{},
         Installers: []API_Installer_1_10_0{in.Installers[0].ToApiInstaller()},
       },
@@ -134,6 +135,10 @@ type Manifest_InstallerManifest_1_10_0 struct {
 }
 
 // implement Manifest_InstallerManifestInterface interface
+func (instm Manifest_InstallerManifest_1_10_0) GetChannel() string {
+  return instm.Channel
+}
+
 func (instm Manifest_InstallerManifest_1_10_0) ToApiInstallers() []API_InstallerInterface {
   var apiInstallers []API_InstallerInterface
 
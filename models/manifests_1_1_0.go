@@ -17,6 +17,7 @@ type Manifest_SingletonManifest_1_1_0 struct {
     Moniker string `yaml:"Moniker"`
     Tags []string `yaml:"Tags"`
     ReleaseDate string `yaml:"ReleaseDate" json:",omitempty"`
+    Channel string `yaml:"Channel"`
     Installers [1]Manifest_Installer_1_1_0 `yaml:"Installers"`
     ManifestType string `yaml:"ManifestType"`
     ManifestVersion string `yaml:"ManifestVersion"`
@@ -38,7 +39,7 @@ func (in *Manifest_SingletonManifest_1_1_0) ToApiManifest() API_ManifestInterfac
           Moniker: in.Moniker,
           Tags: in.Tags,
         },
-        Channel: "",
+        Channel: in.Channel,
         Locales: []API_Locale_1_1_0{},
         Installers: []API_Installer_1_1_0{in.Installers[0].ToApiInstaller()},
       },
@@ -109,6 +110,10 @@ type Manifest_InstallerManifest_1_1_0 struct {
 }
 
 // implement Manifest_InstallerManifestInterface interface
+func (instm Manifest_InstallerManifest_1_1_0) GetChannel() string {
+  return instm.Channel
+}
+
 func (instm Manifest_InstallerManifest_1_1_0) ToApiInstallers() []API_InstallerInterface {
   var apiInstallers []API_InstallerInterface
 
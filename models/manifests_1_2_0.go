@@ -20,6 +20,7 @@ type Manifest_SingletonManifest_1_2_0 struct {
     InstallationNotes string `yaml:"InstallationNotes"`
     Documentations []Manifest_Documentation_1_2_0 `yaml:"Documentations"`
     ReleaseDate string `yaml:"ReleaseDate" json:",omitempty"`
+    Channel string `yaml:"Channel"`
     Installers [1]Manifest_Installer_1_2_0 `yaml:"Installers"`
     ManifestType string `yaml:"ManifestType"`
     ManifestVersion string `yaml:"ManifestVersion"`
@@ -41,7 +42,7 @@ func (in *Manifest_SingletonManifest_1_2_0) ToApiManifest() API_ManifestInterfac
           Moniker: in.Moniker,
           Tags: in.Tags,
         },
-        Channel: "",
+        Channel: in.Channel,
         Locales: []API_Locale_1_4_0{},
         Installers: []API_Installer_1_4_0{in.Installers[0].ToApiInstaller()},
       },
@@ -117,6 +118,10 @@ type Manifest_InstallerManifest_1_2_0 struct {
 }
 
 // implement Manifest_InstallerManifestInterface interface
+func (instm Manifest_InstallerManifest_1_2_0) GetChannel() string {
+  return instm.Channel
+}
+
 func (instm Manifest_InstallerManifest_1_2_0) ToApiInstallers() []API_InstallerInterface {
   var apiInstallers []API_InstallerInterface
 
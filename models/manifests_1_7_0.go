@@ -24,6 +24,7 @@ type Manifest_SingletonManifest_1_7_0 struct {
     NestedInstallerFiles []Manifest_NestedInstallerFile_1_7_0 `yaml:"NestedInstallerFiles" json:",omitempty"`
     ReleaseDate string `yaml:"ReleaseDate" json:",omitempty"`
     InstallationMetadata Manifest_InstallationMetadata_1_7_0 `yaml:"InstallationMetadata"`
+    Channel string `yaml:"Channel"`
     Installers [1]Manifest_Installer_1_7_0 `yaml:"Installers"`
     ManifestType string `yaml:"ManifestType"`
     ManifestVersion string `yaml:"ManifestVersion"`
@@ -45,7 +46,7 @@ func (in *Manifest_SingletonManifest_1_7_0) ToApiManifest() API_ManifestInterfac
           Moniker: in.Moniker,
           Tags: in.Tags,
         },
-        Channel: "",
+        Channel: in.Channel,
         Locales: []API_Locale_1_7_0{},
         Installers: []API_Installer_1_7_0{in.Installers[0].ToApiInstaller()},
       },
@@ -128,6 +129,10 @@ type Manifest_InstallerManifest_1_7_0 struct {
 }
 
 // implement Manifest_InstallerManifestInterface interface
+func (instm Manifest_InstallerManifest_1_7_0) GetChannel() string {
+  return instm.Channel
+}
+
 func (instm Manifest_InstallerManifest_1_7_0) ToApiInstallers() []API_InstallerInterface {
   var apiInstallers []API_InstallerInterface
 
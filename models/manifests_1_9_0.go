@@ -27,6 +27,7 @@ type Manifest_SingletonManifest_1_9_0 struct {
     DownloadCommandProhibited bool `yaml:"DownloadCommandProhibited" json:",omitempty"`
     RepairBehavior string `yaml:"RepairBehavior" json:",omitempty"`
     ArchiveBinariesDependOnPath bool `yaml:"ArchiveBinariesDependOnPath" json:",omitempty"`
+    Channel string `yaml:"Channel"`
     Installers [1]Manifest_Installer_1_9_0 `yaml:"Installers"`
     ManifestType string `yaml:"ManifestType"`
     ManifestVersion string `yaml:"ManifestVersion"`
@@ -48,7 +49,7 @@ func (in *Manifest_SingletonManifest_1_9_0) ToApiManifest() API_ManifestInterfac
           Moniker: in.Moniker,
           Tags: in.Tags,
         },
-        Channel: "",
+        Channel: in.Channel,
         Locales: []API_Locale_1_9_0{},
         Installers: []API_Installer_1_9_0{in.Installers[0].ToApiInstaller()},
       },
@@ -132,6 +133,10 @@ type Manifest_InstallerManifest_1_9_0 struct {
 }
 
 // implement Manifest_InstallerManifestInterface interface
+func (instm Manifest_InstallerManifest_1_9_0) GetChannel() string {
+  return instm.Channel
+}
+
 func (instm Manifest_InstallerManifest_1_9_0) ToApiInstallers() []API_InstallerInterface {
   var apiInstallers []API_InstallerInterface
 
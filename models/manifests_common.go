@@ -47,6 +47,7 @@ type Manifest_VersionManifestInterface interface {
 }
 
 type Manifest_InstallerManifestInterface interface {
+    GetChannel() string
     ToApiInstallers() []API_InstallerInterface
 }
 
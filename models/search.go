@@ -0,0 +1,119 @@
+package models
+
+import (
+    "strings"
+    "unicode"
+)
+
+// The names of the struct fields holding the values of each PackageMatchField that
+// has to be searched for in the manifest data. PackageIdentifier and
+// NormalizedPackageNameAndPublisher are not in here as they are handled separately.
+var packageMatchFieldStructFields = map[string]string{
+    "PackageName": "PackageName",
+    "Moniker": "Moniker",
+    "Command": "Commands",
+    "Tag": "Tags",
+    "PackageFamilyName": "PackageFamilyName",
+    "ProductCode": "ProductCode",
+    "UpgradeCode": "UpgradeCode",
+}
+
+var normalizeReplacer = strings.NewReplacer(" ", "", "-", "", "+", "")
+
+// Returns all values of a PackageMatchField in a package version. A PackageMatchField
+// can have multiple values, e.g. one Tag for every tag or one ProductCode per installer.
+// The boolean is false if the PackageMatchField is not supported for searching.
+// This is only used to build the searchIndex when a package version is stored
+// because searching the manifest data with reflection is way too slow for searches.
+func packageMatchFieldValues(packageIdentifier string, packageVersion API_ManifestVersionInterface, packageMatchField string) ([]string, bool) {
+    switch packageMatchField {
+    case "PackageIdentifier":
+        return []string{packageIdentifier}, true
+    case "NormalizedPackageNameAndPublisher":
+        // winget only ever sends the package / software name, the publisher isn't included so to
+        // enable proper matching we also only compare against the normalized packagename.
+        return []string{normalizeReplacer.Replace(strings.ToLower(packageVersion.GetDefaultLocalePackageName()))}, true
+    }
+
+    structField, ok := packageMatchFieldStructFields[packageMatchField]
+    if !ok {
+        return nil, false
+    }
+
+    var values []string
+    for _, f := range findFields(packageVersion, structField) {
+        switch v := f.Interface().(type) {
+        case string:
+            if v != "" {
+                values = append(values, v)
+            }
+        case []string:
+            values = append(values, v...)
+        }
+    }
+    return values, true
+}
+
+func requestMatches(value string, requestMatch API_SearchRequestMatch_1_1_0) bool {
+    switch requestMatch.MatchType {
+    // TODO: `winget list -s rewinged-local -q lapce` searches for the ProductCode with MatchType Exact
+    // Why does it use MatchType Exact?? Does the reference / official source normalize all ProductCodes on ingest??
+    case "Exact":
+        return value == requestMatch.KeyWord
+    case "CaseInsensitive":
+        return strings.EqualFold(value, requestMatch.KeyWord)
+    case "StartsWith":
+        // StartsWith is implemented as case-sensitive, because it is that way in the reference implementation as well:
+        // https://github.com/microsoft/winget-cli-restsource/blob/01542050d79da0efbd11c0a5be543cb970b86eb9/src/WinGet.RestSource/Cosmos/PredicateGenerator.cs#L92-L102
+        return strings.HasPrefix(value, requestMatch.KeyWord)
+    case "Substring":
+        // Substring comparison is case-insensitive, because it is that way in the reference implementation as well:
+        // https://github.com/microsoft/winget-cli-restsource/blob/01542050d79da0efbd11c0a5be543cb970b86eb9/src/WinGet.RestSource/Cosmos/PredicateGenerator.cs#L92-L102
+        return caseInsensitiveContains(value, requestMatch.KeyWord)
+    case "Wildcard":
+        // * matches any number of characters and ? matches exactly one, case-insensitive
+        return wildcardMatch([]rune(strings.ToLower(value)), []rune(strings.ToLower(requestMatch.KeyWord)))
+    case "Fuzzy":
+        // Fuzzy matching compares the values after dropping case, whitespace and punctuation
+        // so that e.g. "Visual Studio Code" matches "visual-studio-code" or "VisualStudioCode".
+        return fuzzyNormalize(value) == fuzzyNormalize(requestMatch.KeyWord)
+    case "FuzzySubstring":
+        return strings.Contains(fuzzyNormalize(value), fuzzyNormalize(requestMatch.KeyWord))
+    default:
+        return false
+    }
+}
+
+func fuzzyNormalize(s string) string {
+    return strings.Map(func(r rune) rune {
+        if unicode.IsLetter(r) || unicode.IsDigit(r) {
+            return unicode.ToLower(r)
+        }
+        return -1
+    }, s)
+}
+
+func wildcardMatch(value []rune, pattern []rune) bool {
+    // Position of the last * in the pattern and the position in
+    // the value it was matched up to, to backtrack to on mismatch
+    star, starMatch := -1, 0
+    v, p := 0, 0
+    for v < len(value) {
+        if p < len(pattern) && (pattern[p] == '?' || pattern[p] == value[v]) {
+            v++
+            p++
+        } else if p < len(pattern) && pattern[p] == '*' {
+            star, starMatch = p, v
+            p++
+        } else if star != -1 {
+            starMatch++
+            v, p = starMatch, star + 1
+        } else {
+            return false
+        }
+    }
+    for p < len(pattern) && pattern[p] == '*' {
+        p++
+    }
+    return p == len(pattern)
+}
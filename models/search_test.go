@@ -0,0 +1,85 @@
+package models
+
+import (
+    "testing"
+)
+
+func TestRequestMatches(t *testing.T) {
+    tests := []struct {
+        value string
+        keyword string
+        matchType string
+        want bool
+    }{
+        {"Visual Studio Code", "Visual Studio Code", "Exact", true},
+        {"Visual Studio Code", "visual studio code", "Exact", false},
+        {"Visual Studio Code", "visual studio code", "CaseInsensitive", true},
+        {"Visual Studio Code", "Visual Studio", "CaseInsensitive", false},
+        {"Visual Studio Code", "Visual", "StartsWith", true},
+        {"Visual Studio Code", "visual", "StartsWith", false},
+        {"Visual Studio Code", "STUDIO", "Substring", true},
+        {"Visual Studio Code", "Studios", "Substring", false},
+
+        {"Visual Studio Code", "*", "Wildcard", true},
+        {"", "*", "Wildcard", true},
+        {"", "?", "Wildcard", false},
+        {"Visual Studio Code", "visual*", "Wildcard", true},
+        {"Visual Studio Code", "*code", "Wildcard", true},
+        {"Visual Studio Code", "*studio*", "Wildcard", true},
+        {"Visual Studio Code", "*Studio", "Wildcard", false},
+        {"Visual Studio Code", "Visual Studio Cod?", "Wildcard", true},
+        {"Visual Studio Code", "Visual Studio Code?", "Wildcard", false},
+        {"Visual Studio Code", "v?sual*c?de", "Wildcard", true},
+        {"Visual Studio Code", "v**l***", "Wildcard", true},
+        // Needs backtracking: the first "o" after the * doesn't lead to a match
+        {"Visual Studio Code", "*o code", "Wildcard", true},
+        {"aaab", "*a?b", "Wildcard", true},
+        {"aaab", "*a?a", "Wildcard", false},
+        // Without wildcards the whole value has to match
+        {"Visual Studio Code", "studio", "Wildcard", false},
+        {"Visual Studio Code", "visual studio code", "Wildcard", true},
+        // ? matches a character, not a byte
+        {"Café", "caf?", "Wildcard", true},
+
+        {"Visual Studio Code", "visualstudiocode", "Fuzzy", true},
+        {"Visual Studio Code", "visual-studio-code", "Fuzzy", true},
+        {"Visual Studio Code", "  Visual.Studio_Code!", "Fuzzy", true},
+        {"Visual Studio Code", "visualstudio", "Fuzzy", false},
+        {"Notepad++", "notepad", "Fuzzy", true},
+        {"Café", "cafe", "Fuzzy", false},
+        {"Visual Studio Code", "studio-code", "FuzzySubstring", true},
+        {"Visual Studio Code", "o c", "FuzzySubstring", true},
+        {"Visual Studio Code", "studios", "FuzzySubstring", false},
+        {"Visual Studio Code", "", "FuzzySubstring", true},
+
+        {"Visual Studio Code", "Visual Studio Code", "", false},
+        {"Visual Studio Code", "Visual Studio Code", "Unknown", false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.matchType + " " + tt.keyword, func(t *testing.T) {
+            requestMatch := API_SearchRequestMatch_1_1_0{KeyWord: tt.keyword, MatchType: tt.matchType}
+            if got := requestMatches(tt.value, requestMatch); got != tt.want {
+                t.Errorf("requestMatches(%q, %v %q) = %v, want %v", tt.value, tt.matchType, tt.keyword, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestFuzzyNormalize(t *testing.T) {
+    tests := []struct {
+        value string
+        want string
+    }{
+        {"Visual Studio Code", "visualstudiocode"},
+        {"7-Zip 23.01 (x64)", "7zip2301x64"},
+        {"Notepad++", "notepad"},
+        {"Éditeur", "éditeur"},
+        {"", ""},
+        {"--- ...", ""},
+    }
+    for _, tt := range tests {
+        if got := fuzzyNormalize(tt.value); got != tt.want {
+            t.Errorf("fuzzyNormalize(%q) = %q, want %q", tt.value, got, tt.want)
+        }
+    }
+}
@@ -0,0 +1,111 @@
+package models
+
+import (
+    "cmp"
+    "slices"
+    "strconv"
+    "strings"
+)
+
+// One dot-separated part of a version: its leading number and whatever follows it, e.g. 3 and "-beta" for "3-beta"
+type versionPart struct {
+    integer uint64
+    other string
+}
+
+// Splits a version into its parts like winget does. Trailing parts that are zero don't
+// count, so that 1.0.0 is the same version as 1.
+func parseVersion(version string) []versionPart {
+    var parts []versionPart
+    for _, s := range strings.Split(strings.TrimSpace(version), ".") {
+        s = strings.TrimSpace(s)
+        digits := len(s) - len(strings.TrimLeft(s, "0123456789"))
+        part := versionPart{other: s[digits:]}
+        if digits > 0 {
+            n, err := strconv.ParseUint(s[:digits], 10, 64)
+            if err != nil {
+                // winget compares numbers too large for it as text
+                part = versionPart{other: s}
+            } else {
+                part.integer = n
+            }
+        }
+        parts = append(parts, part)
+    }
+    for len(parts) > 0 && parts[len(parts) - 1] == (versionPart{}) {
+        parts = parts[:len(parts) - 1]
+    }
+    return parts
+}
+
+func (a versionPart) compare(b versionPart) int {
+    if c := cmp.Compare(a.integer, b.integer); c != 0 {
+        return c
+    }
+    // A number without a suffix is newer than the same number with one, e.g. 3 is newer than 3-beta
+    if a.other == "" && b.other == "" {
+        return 0
+    } else if a.other == "" {
+        return 1
+    } else if b.other == "" {
+        return -1
+    }
+    return strings.Compare(strings.ToLower(a.other), strings.ToLower(b.other))
+}
+
+// CompareVersions compares two PackageVersions by the rules winget uses, so that rewinged and
+// winget agree on which version of a package is the latest. It returns -1 if a is older than b,
+// 0 if they are the same version and +1 if a is newer than b.
+//
+// Versions are compared part by part, where parts are separated by dots and missing parts count
+// as 0. Parts are compared by their leading number first, and if that is the same, by what
+// follows it, case-insensitively, except that a part with nothing following the number is newer.
+// "Unknown" is older and "Latest" is newer than any other version.
+func CompareVersions(a string, b string) int {
+    for _, special := range []string{"Latest", "Unknown"} {
+        isA, isB := strings.EqualFold(strings.TrimSpace(a), special), strings.EqualFold(strings.TrimSpace(b), special)
+        if isA || isB {
+            c := 0
+            if isA && !isB {
+                c = 1
+            } else if isB && !isA {
+                c = -1
+            }
+            if special == "Unknown" {
+                c = -c
+            }
+            return c
+        }
+    }
+
+    partsA, partsB := parseVersion(a), parseVersion(b)
+    for i := 0; i < max(len(partsA), len(partsB)); i++ {
+        var partA, partB versionPart
+        if i < len(partsA) {
+            partA = partsA[i]
+        }
+        if i < len(partsB) {
+            partB = partsB[i]
+        }
+        if c := partA.compare(partB); c != 0 {
+            return c
+        }
+    }
+    return 0
+}
+
+// Orders PackageVersions from newest to oldest. Different spellings of the same version,
+// like 1.0 and 1.0.0, are ordered by their text so that the order is always the same.
+func compareNewestFirst(a string, b string) int {
+    if c := CompareVersions(b, a); c != 0 {
+        return c
+    }
+    return strings.Compare(a, b)
+}
+
+// SortVersionsNewestFirst sorts package versions from the newest to the oldest PackageVersion
+func SortVersionsNewestFirst(versions []API_ManifestVersionInterface) {
+    slices.SortFunc(versions, func(a, b API_ManifestVersionInterface) int {
+        return compareNewestFirst(a.GetPackageVersion(), b.GetPackageVersion())
+    })
+}
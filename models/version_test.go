@@ -0,0 +1,84 @@
+package models
+
+import (
+    "slices"
+    "testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+    tests := []struct {
+        a string
+        b string
+        want int
+    }{
+        {"1.0", "1.0", 0},
+        {"1.0", "1", 0},
+        {"1", "1.0.0.0", 0},
+        {"1.0.1", "1", 1},
+        {"1.2", "1.10", -1},
+        {"2", "1.99.99", 1},
+        {"10.0", "9.0", 1},
+        {" 1.0 ", "1", 0},
+        {"01.002", "1.2", 0},
+        {"", "0", 0},
+        {"", "0.1", -1},
+
+        {"3", "3-beta", 1},
+        {"3-beta", "3-alpha", 1},
+        {"3-BETA", "3-beta", 0},
+        {"3-beta", "4-alpha", -1},
+        {"3.0-beta", "3", -1},
+        {"1.0.0-rc1", "1.0.0", -1},
+        {"1.0.0-rc1", "1.0.1", -1},
+        {"1.a", "1.b", -1},
+        {"1.a", "1.0", -1},
+        {"1.a", "1", -1},
+        {"v2", "v1", 1},
+        {"v2", "1", -1},
+        // Numbers too large for winget are compared as text, like a part without any number
+        {"99999999999999999999", "1", -1},
+        {"99999999999999999999", "100000000000000000000", 1},
+
+        {"Unknown", "0", -1},
+        {"unknown", "0.0.0.1-alpha", -1},
+        {"Unknown", "Unknown", 0},
+        {"UNKNOWN", "unknown", 0},
+        {"Latest", "999.999", 1},
+        {"latest", "LATEST", 0},
+        {"Latest", "Unknown", 1},
+        {"Unknown", "Latest", -1},
+    }
+    for _, tt := range tests {
+        t.Run(tt.a + " " + tt.b, func(t *testing.T) {
+            if got := CompareVersions(tt.a, tt.b); got != tt.want {
+                t.Errorf("CompareVersions(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+            }
+            if got := CompareVersions(tt.b, tt.a); got != -tt.want {
+                t.Errorf("CompareVersions(%q, %q) = %v, want %v", tt.b, tt.a, got, -tt.want)
+            }
+        })
+    }
+}
+
+func TestSortVersionsNewestFirst(t *testing.T) {
+    packageVersions := []string{"1.0.0", "Unknown", "1.10", "3-beta", "1.2", "Latest", "1.0", "3", "1"}
+    want := []string{"Latest", "3", "3-beta", "1.10", "1.2", "1", "1.0", "1.0.0", "Unknown"}
+
+    // The order must not depend on the order the versions come in
+    for range 10 {
+        var versions []API_ManifestVersionInterface
+        for _, packageVersion := range packageVersions {
+            versions = append(versions, API_ManifestVersion_1_10_0{PackageVersion: packageVersion})
+        }
+        SortVersionsNewestFirst(versions)
+
+        var got []string
+        for _, version := range versions {
+            got = append(got, version.GetPackageVersion())
+        }
+        if !slices.Equal(got, want) {
+            t.Fatalf("got %v, want %v", got, want)
+        }
+        packageVersions = append(packageVersions[1:], packageVersions[0])
+    }
+}
@@ -1,12 +1,29 @@
 package settings
 
 import (
+    "time"
     "net/netip"
 )
 
 var (
+    // Forwarding headers like X-Forwarded-For or Forwarded are only honored from these
     TrustedProxies []netip.Prefix = []netip.Prefix{}
+    // The URL clients reach rewinged under, overrides what is derived from requests if set
+    PublicBaseURL = ""
     SourceAuthenticationType = "none"
     SourceAuthenticationEntraIDResource = ""
     SourceAuthenticationEntraIDAuthorityURL = ""
+    // The OIDC issuer and audience client access tokens are verified against, for both
+    // the microsoftEntraId and the generic oidc SourceAuthenticationType
+    SourceAuthenticationIssuerURL = ""
+    SourceAuthenticationAudience = ""
+    // Claims that client access tokens must contain, each with one of the listed values
+    SourceAuthenticationRequiredClaims = map[string][]string{}
+    // Optional JSON file with rules which packages authenticated clients may access
+    SourceAuthorizationPolicyFile = ""
+    // Client access tokens are still accepted for this long after they have expired
+    SourceAuthenticationClockSkew = 5 * time.Minute
+    // The maximum number of packages returned in one page of /packages or /manifestSearch
+    // results. Clients have to follow the ContinuationToken to retrieve any further pages.
+    MaximumPageSize = 1000
 )
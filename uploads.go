@@ -0,0 +1,250 @@
+package main
+
+import (
+  "os"
+  "io"
+  "time"
+  "bytes"
+  "errors"
+  "slices"
+  "strings"
+  "net/http"
+  "io/fs"
+  "path/filepath"
+  "text/template"
+  "crypto/sha256"
+  "encoding/hex"
+
+  "rewinged/forwarded"
+  "rewinged/installerinfo"
+  "rewinged/logging"
+  "rewinged/models"
+)
+
+// The values a manifest template can use to describe an uploaded installer
+type manifestTemplateData struct {
+  PackageIdentifier string
+  PackageVersion string
+  Architecture string
+  InstallerUrl string
+  InstallerSha256 string
+  // Read from the installer file, empty for installer types that aren't supported
+  installerinfo.Metadata
+}
+
+// installerUploader stores uploaded installers next to the internalized ones, under their
+// SHA256, and describes them with a package version rendered from the manifest template of
+// their package. The package version is written through the package management, so it ends
+// up in the manifestPath like any other change.
+type installerUploader struct {
+  packages *packageManager
+  installerPath string
+  templatePath string
+  // In bytes, larger uploads are rejected before they fill up the disk
+  maxSize int64
+}
+
+func newInstallerUploader(packages *packageManager, installerPath string, templatePath string, maxSize int64) *installerUploader {
+  return &installerUploader{packages: packages, installerPath: installerPath, templatePath: templatePath, maxSize: maxSize}
+}
+
+func (u *installerUploader) registerRoutes(router *http.ServeMux, protect func(http.Handler) http.Handler) {
+  router.Handle("POST /api/admin/installers/{package_identifier}/{package_version}", protect(http.HandlerFunc(u.upload)))
+}
+
+// Streams an uploaded installer into the installerPath under its SHA256, like an internalized
+// one. Also returns whether the file was created by this upload rather than already there.
+func (u *installerUploader) storeInstaller(body io.Reader) (models.InternalizedInstaller, bool, error) {
+  if err := os.MkdirAll(u.installerPath, 0755); err != nil {
+    return models.InternalizedInstaller{}, false, err
+  }
+  // Hidden until its hash and therefore its name is known
+  part, err := os.CreateTemp(u.installerPath, ".upload-*.part")
+  if err != nil {
+    return models.InternalizedInstaller{}, false, err
+  }
+  defer os.Remove(part.Name())
+
+  hash := sha256.New()
+  n, err := io.Copy(io.MultiWriter(part, hash), body)
+  var tooLarge *http.MaxBytesError
+  if errors.As(err, &tooLarge) {
+    err = newManagementError(http.StatusRequestEntityTooLarge, "the installer must not be larger than %v bytes", tooLarge.Limit)
+  }
+  if err == nil {
+    err = part.Chmod(0644)
+  }
+  if closeErr := part.Close(); err == nil {
+    err = closeErr
+  }
+  if err != nil {
+    return models.InternalizedInstaller{}, false, err
+  }
+  if n == 0 {
+    return models.InternalizedInstaller{}, false, newManagementError(http.StatusBadRequest, "the request body must be the installer")
+  }
+
+  installer := models.InternalizedInstaller{
+    InstallerSha256: hex.EncodeToString(hash.Sum(nil)),
+    Size: n,
+    DownloadTime: time.Now(),
+  }
+  installer.Path = filepath.Join(u.installerPath, installer.InstallerSha256)
+  // The same installer was uploaded or internalized before, keep that file
+  if _, err := os.Stat(installer.Path); err == nil {
+    return withMetadata(installer), false, nil
+  }
+  if err := os.Rename(part.Name(), installer.Path); err != nil {
+    return models.InternalizedInstaller{}, false, err
+  }
+  return withMetadata(installer), true, nil
+}
+
+func (u *installerUploader) loadTemplate(packageIdentifier string) (*template.Template, error) {
+  file := filepath.Join(u.templatePath, packageIdentifier + ".yaml")
+  data, err := os.ReadFile(file)
+  if errors.Is(err, fs.ErrNotExist) {
+    return nil, newManagementError(http.StatusNotFound, "there is no manifest template for package %v", packageIdentifier)
+  } else if err != nil {
+    return nil, err
+  }
+  tmpl, err := template.New(filepath.Base(file)).Option("missingkey=error").Parse(string(data))
+  if err != nil {
+    return nil, invalidTemplateError(file, err.Error())
+  }
+  return tmpl, nil
+}
+
+// Problems with a template are not the uploader's fault, but they still need to know about them
+func invalidTemplateError(file string, message string) error {
+  logging.Logger.Error().Str("file", file).Msgf("invalid manifest template: %v", message)
+  return newManagementError(http.StatusInternalServerError, "invalid manifest template %v: %v", filepath.Base(file), message)
+}
+
+// Renders a manifest template, which has to be a singleton manifest of any ManifestVersion,
+// into a package version in the schema of the management API
+func (u *installerUploader) renderTemplate(tmpl *template.Template, data manifestTemplateData) (models.API_ManifestVersion_1_10_0, error) {
+  file := filepath.Join(u.templatePath, tmpl.Name())
+  var rendered bytes.Buffer
+  if err := tmpl.Execute(&rendered, data); err != nil {
+    return models.API_ManifestVersion_1_10_0{}, invalidTemplateError(file, err.Error())
+  }
+
+  nodes, err := decodeBaseManifests(&rendered, file)
+  if err != nil {
+    return models.API_ManifestVersion_1_10_0{}, invalidTemplateError(file, err.Error())
+  }
+  if len(nodes) != 1 || nodes[0].ManifestType != "singleton" {
+    return models.API_ManifestVersion_1_10_0{}, invalidTemplateError(file, "it must be exactly one singleton manifest")
+  }
+  if nodes[0].PackageIdentifier != data.PackageIdentifier || nodes[0].PackageVersion != data.PackageVersion {
+    return models.API_ManifestVersion_1_10_0{}, invalidTemplateError(file, "PackageIdentifier and PackageVersion must be {{.PackageIdentifier}} and {{.PackageVersion}}")
+  }
+  manifest, err := parseNodeAsSingletonManifest(nodes[0].ManifestVersion, nodes[0].Node)
+  if err != nil {
+    return models.API_ManifestVersion_1_10_0{}, invalidTemplateError(file, err.Error())
+  }
+  converted, err := models.ConvertManifestVersion(manifest.GetVersions()[0], managementSchemaVersion)
+  if err != nil {
+    return models.API_ManifestVersion_1_10_0{}, err
+  }
+  return converted.(models.API_ManifestVersion_1_10_0), nil
+}
+
+// Adds the installers of an upload to those of an existing package version, replacing the ones
+// they would otherwise get the same InstallerIdentifier as, e.g. a previous upload for the same
+// architecture. This way a package version can be uploaded one architecture at a time.
+func mergeInstallers(existing []models.API_Installer_1_10_0, uploaded []models.API_Installer_1_10_0) []models.API_Installer_1_10_0 {
+  sameInstaller := func(a, b models.API_Installer_1_10_0) bool {
+    return strings.EqualFold(a.Architecture, b.Architecture) &&
+      strings.EqualFold(a.InstallerType, b.InstallerType) &&
+      strings.EqualFold(a.Scope, b.Scope) &&
+      strings.EqualFold(a.InstallerLocale, b.InstallerLocale)
+  }
+  var merged []models.API_Installer_1_10_0
+  for _, installer := range existing {
+    if !slices.ContainsFunc(uploaded, func(u models.API_Installer_1_10_0) bool { return sameInstaller(installer, u) }) {
+      merged = append(merged, installer)
+    }
+  }
+  return append(merged, uploaded...)
+}
+
+func (u *installerUploader) upload(w http.ResponseWriter, r *http.Request) {
+  packageIdentifier, packageVersion := r.PathValue("package_identifier"), r.PathValue("package_version")
+  architecture := r.URL.Query().Get("architecture")
+  // The PackageIdentifier becomes a file name before the package management checks it
+  if len(packageIdentifier) > maxPackageIdentifierLength || !packageIdentifierPattern.MatchString(packageIdentifier) {
+    writeManagementError(w, newManagementError(http.StatusBadRequest, "PackageIdentifier %q does not match the pattern Publisher.Package required by the schema", packageIdentifier))
+    return
+  }
+  if architecture != "" && !slices.Contains(architectures, architecture) {
+    writeManagementError(w, newManagementError(http.StatusBadRequest, "architecture must be one of %v", strings.Join(architectures, ", ")))
+    return
+  }
+  tmpl, err := u.loadTemplate(packageIdentifier)
+  if err != nil {
+    writeManagementError(w, err)
+    return
+  }
+
+  installer, created, err := u.storeInstaller(http.MaxBytesReader(w, r.Body, u.maxSize))
+  if err != nil {
+    writeManagementError(w, err)
+    return
+  }
+  data := manifestTemplateData{
+    PackageIdentifier: packageIdentifier,
+    PackageVersion: packageVersion,
+    Architecture: architecture,
+    // Where internalized installers are served. Uploads require autoInternalize, so when the package
+    // version is served, this is rewritten to wherever the client reached rewinged like for any other
+    // internalized installer, and the host of the admin request doesn't leak to clients.
+    InstallerUrl: forwarded.BaseURL(r) + "/installers/" + installer.InstallerSha256,
+    // Uppercase like in the winget-pkgs repository
+    InstallerSha256: strings.ToUpper(installer.InstallerSha256),
+  }
+  if installer.Metadata != nil {
+    data.Metadata = *installer.Metadata
+  }
+  // An installer that was uploaded or internalized before keeps where and when it came from
+  if _, ok := models.InternalizedInstallers.Get(installer.InstallerSha256); created || !ok {
+    installer.SourceURL = data.InstallerUrl
+    models.InternalizedInstallers.Set(installer)
+  }
+
+  var version models.API_ManifestVersion_1_10_0
+  ok := u.packages.change(w, func() error {
+    uploaded, err := u.renderTemplate(tmpl, data)
+    if err != nil {
+      return err
+    }
+    for i := range uploaded.Installers {
+      if installer.Metadata != nil && strings.EqualFold(uploaded.Installers[i].InstallerSha256, installer.InstallerSha256) {
+        fillInstallerMetadata(&uploaded.Installers[i], *installer.Metadata, packageVersion)
+      }
+    }
+    if existing, err := readManagedVersion(packageIdentifier, packageVersion); err == nil {
+      uploaded.Installers = mergeInstallers(existing.Installers, uploaded.Installers)
+    }
+    change, err := u.packages.prepareWrite(packageIdentifier, uploaded)
+    if err != nil {
+      return err
+    }
+    if err := u.packages.apply(change); err != nil {
+      return err
+    }
+    version, err = getManagedVersion(packageIdentifier, packageVersion)
+    return err
+  })
+  if !ok {
+    // Nothing references an installer that was only just uploaded
+    if created {
+      models.InternalizedInstallers.Remove(installer.InstallerSha256)
+      os.Remove(installer.Path)
+    }
+    return
+  }
+  logging.Logger.Info().Str("package", packageIdentifier).Str("packageversion", packageVersion).Int64("bytes", installer.Size).Msgf("uploaded installer %v", installer.InstallerSha256)
+  writeManagementData(w, version)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+  "os"
+  "io"
+  "strings"
+  "testing"
+  "net/http"
+  "path/filepath"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+
+  "rewinged/controllers"
+  "rewinged/models"
+)
+
+const testManifestTemplate = `PackageIdentifier: {{.PackageIdentifier}}
+PackageVersion: "{{.PackageVersion}}"
+PackageLocale: en-US
+Publisher: Contoso
+PackageName: Contoso App
+License: Proprietary
+ShortDescription: The Contoso app
+Installers:
+  - Architecture: {{.Architecture}}
+    InstallerType: exe
+    InstallerUrl: {{.InstallerUrl}}
+    InstallerSha256: {{.InstallerSha256}}
+ManifestType: singleton
+ManifestVersion: 1.10.0
+`
+
+// The package management of a managementTest with installer uploads and the manifest templates in templates
+func newUploadTest(t *testing.T, maxSize int64, templates map[string]string) *managementTest {
+  test := newManagementTest(t, true)
+  templatePath := t.TempDir()
+  for packageIdentifier, template := range templates {
+    if err := os.WriteFile(filepath.Join(templatePath, packageIdentifier + ".yaml"), []byte(template), 0644); err != nil {
+      t.Fatal(err)
+    }
+  }
+  newInstallerUploader(test.packages, test.installerPath, templatePath, maxSize).registerRoutes(test.router, func(h http.Handler) http.Handler { return h })
+  test.router.Handle("GET /api/packageManifests/{package_identifier}", http.HandlerFunc((&controllers.GetPackageHandler{InternalizationEnabled: true}).GetPackage))
+  t.Cleanup(func() {
+    for _, installer := range models.InternalizedInstallers.GetAll() {
+      if filepath.Dir(installer.Path) == test.installerPath {
+        models.InternalizedInstallers.Remove(installer.InstallerSha256)
+      }
+    }
+  })
+  return test
+}
+
+// Uploads an installer as if the admin reached rewinged under host and returns the status code and response body
+func (test *managementTest) upload(t *testing.T, path string, content string, host string) (int, []byte) {
+  r, err := http.NewRequest("POST", test.server.URL + path, strings.NewReader(content))
+  if err != nil {
+    t.Fatal(err)
+  }
+  r.Host = host
+  resp, err := http.DefaultClient.Do(r)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer resp.Body.Close()
+  data, err := io.ReadAll(resp.Body)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return resp.StatusCode, data
+}
+
+func sha256Of(content string) string {
+  hash := sha256.Sum256([]byte(content))
+  return hex.EncodeToString(hash[:])
+}
+
+// Uploads for different architectures add up to one package version, uploading one again replaces it
+func TestUploadMergesInstallersByArchitecture(t *testing.T) {
+  test := newUploadTest(t, 1 << 20, map[string]string{"Contoso.Upload": testManifestTemplate})
+
+  installers := func(data []byte) map[string]string {
+    var version models.API_ManifestVersion_1_10_0
+    if err := json.Unmarshal(data, &struct{ Data any }{&version}); err != nil {
+      t.Fatalf("%v: %s", err, data)
+    }
+    architectures := make(map[string]string)
+    for _, installer := range version.Installers {
+      architectures[installer.Architecture] = strings.ToLower(installer.InstallerSha256)
+    }
+    return architectures
+  }
+
+  steps := []struct {
+    architecture string
+    content string
+    want map[string]string
+  }{
+    {"x64", "x64 installer", map[string]string{"x64": sha256Of("x64 installer")}},
+    {"arm64", "arm64 installer", map[string]string{"x64": sha256Of("x64 installer"), "arm64": sha256Of("arm64 installer")}},
+    {"x64", "new x64 installer", map[string]string{"x64": sha256Of("new x64 installer"), "arm64": sha256Of("arm64 installer")}},
+  }
+  for _, step := range steps {
+    status, data := test.upload(t, "/api/admin/installers/Contoso.Upload/1.0.0?architecture=" + step.architecture, step.content, "admin.internal")
+    if status != http.StatusOK {
+      t.Fatalf("uploading the %v installer: got status %v: %s", step.architecture, status, data)
+    }
+    got := installers(data)
+    if len(got) != len(step.want) || got["x64"] != step.want["x64"] || got["arm64"] != step.want["arm64"] {
+      t.Errorf("after uploading the %v installer got %v, want %v", step.architecture, got, step.want)
+    }
+    if _, err := os.Stat(filepath.Join(test.installerPath, sha256Of(step.content))); err != nil {
+      t.Errorf("the %v installer wasn't stored: %v", step.architecture, err)
+    }
+  }
+}
+
+// The manifest points to the host the upload was sent to, but clients get the InstallerUrl they can reach
+func TestUploadedInstallerUrlIsRewritten(t *testing.T) {
+  test := newUploadTest(t, 1 << 20, map[string]string{"Contoso.Rewrite": testManifestTemplate})
+  installerSha256 := sha256Of("installer")
+  if status, data := test.upload(t, "/api/admin/installers/Contoso.Rewrite/1.0.0?architecture=x64", "installer", "admin.internal"); status != http.StatusOK {
+    t.Fatalf("got status %v: %s", status, data)
+  }
+  dir := test.versionDir("Contoso.Rewrite", "1.0.0")
+  if manifest := readFile(t, filepath.Join(dir, "Contoso.Rewrite.installer.yaml")); !strings.Contains(manifest, "http://admin.internal/installers/" + installerSha256) {
+    t.Errorf("the InstallerUrl isn't the one of the upload:\n%v", manifest)
+  }
+
+  served := func() string {
+    var response struct {
+      Data struct {
+        Versions []struct {
+          Installers []struct {
+            InstallerUrl string
+          }
+        }
+      }
+    }
+    status, data := test.request(t, "GET", "/api/packageManifests/Contoso.Rewrite", nil)
+    if err := json.Unmarshal(data, &response); status != http.StatusOK || err != nil || len(response.Data.Versions) != 1 {
+      t.Fatalf("got status %v, %v: %s", status, err, data)
+    }
+    return response.Data.Versions[0].Installers[0].InstallerUrl
+  }
+  want := test.server.URL + "/installers/" + installerSha256
+  if got := served(); got != want {
+    t.Errorf("got InstallerUrl %v, want %v", got, want)
+  }
+
+  // After a restart, the installer is registered as internalized again even if rewinged's own host is skipped
+  models.InternalizedInstallers.Remove(installerSha256)
+  ingestManifestDirectory(dir, true, test.installerPath, []string{"admin.internal"})
+  if got := served(); got != want {
+    t.Errorf("got InstallerUrl %v after a restart, want %v", got, want)
+  }
+}
+
+// Uploading an installer that is already there keeps the file and where it originally came from
+func TestUploadSameInstallerTwice(t *testing.T) {
+  test := newUploadTest(t, 1 << 20, map[string]string{"Contoso.First": testManifestTemplate, "Contoso.Second": testManifestTemplate})
+  installerSha256 := sha256Of("shared installer")
+  for _, packageIdentifier := range []string{"Contoso.First", "Contoso.Second"} {
+    if status, data := test.upload(t, "/api/admin/installers/" + packageIdentifier + "/1.0.0?architecture=x64", "shared installer", strings.ToLower(packageIdentifier) + ".internal"); status != http.StatusOK {
+      t.Fatalf("uploading for %v: got status %v: %s", packageIdentifier, status, data)
+    }
+  }
+  installer, ok := models.InternalizedInstallers.Get(installerSha256)
+  if !ok || installer.SourceURL != "http://contoso.first.internal/installers/" + installerSha256 {
+    t.Errorf("got registry entry %+v, %v", installer, ok)
+  }
+  entries, err := os.ReadDir(test.installerPath)
+  if err != nil || len(entries) != 1 || entries[0].Name() != installerSha256 {
+    t.Errorf("got installer files %v, %v", entries, err)
+  }
+}
+
+func TestUploadErrors(t *testing.T) {
+  test := newUploadTest(t, 16, map[string]string{
+    "Contoso.Upload": testManifestTemplate,
+    "Contoso.Broken": "PackageIdentifier: {{.PackageIdentifier}",
+    "Contoso.Multiple": testManifestTemplate + "---\n" + testManifestTemplate,
+    "Contoso.Other": strings.Replace(testManifestTemplate, "{{.PackageIdentifier}}", "Contoso.Upload", 1),
+    "Contoso.Unknown": strings.Replace(testManifestTemplate, "{{.Architecture}}", "{{.Platform}}", 1),
+  })
+  tests := []struct {
+    name string
+    path string
+    content string
+    want int
+  }{
+    {"no template", "/api/admin/installers/Contoso.Missing/1.0.0?architecture=x64", "installer", http.StatusNotFound},
+    {"PackageIdentifier that isn't a file name", "/api/admin/installers/..%2FContoso.Upload/1.0.0?architecture=x64", "installer", http.StatusBadRequest},
+    {"unknown architecture", "/api/admin/installers/Contoso.Upload/1.0.0?architecture=sparc", "installer", http.StatusBadRequest},
+    {"empty installer", "/api/admin/installers/Contoso.Upload/1.0.0?architecture=x64", "", http.StatusBadRequest},
+    {"installer too large", "/api/admin/installers/Contoso.Upload/1.0.0?architecture=x64", "an installer larger than 16 bytes", http.StatusRequestEntityTooLarge},
+    {"invalid PackageVersion", "/api/admin/installers/Contoso.Upload/1.0%3A0?architecture=x64", "installer", http.StatusBadRequest},
+    {"template that cannot be parsed", "/api/admin/installers/Contoso.Broken/1.0.0?architecture=x64", "installer", http.StatusInternalServerError},
+    {"template with more than one manifest", "/api/admin/installers/Contoso.Multiple/1.0.0?architecture=x64", "installer", http.StatusInternalServerError},
+    {"template for another package", "/api/admin/installers/Contoso.Other/1.0.0?architecture=x64", "installer", http.StatusInternalServerError},
+    {"template with an unknown value", "/api/admin/installers/Contoso.Unknown/1.0.0?architecture=x64", "installer", http.StatusInternalServerError},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      if status, data := test.upload(t, tt.path, tt.content, "admin.internal"); status != tt.want {
+        t.Errorf("got status %v, want %v: %s", status, tt.want, data)
+      }
+    })
+  }
+
+  // Nothing references the installers of failed uploads, so they are gone again
+  if entries, err := os.ReadDir(test.installerPath); err != nil || len(entries) != 0 {
+    t.Errorf("got installer files %v, %v", entries, err)
+  }
+  if models.InternalizedInstallers.IsInternalized(sha256Of("installer")) {
+    t.Errorf("the installer of the failed uploads is still registered")
+  }
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+  "os"
+  "io"
+  "fmt"
+  "flag"
+  "errors"
+  "sync"
+  "slices"
+  "regexp"
+  "runtime"
+  "strings"
+  "net/http"
+  "io/fs"
+  "path/filepath"
+  "encoding/json"
+
+  "github.com/peterbourgon/ff/v3"
+  "gopkg.in/yaml.v3"
+
+  "rewinged/logging"
+  "rewinged/models"
+)
+
+// Patterns and limits from the winget manifest JSON schemas
+var (
+  packageIdentifierPattern = regexp.MustCompile(`^[^\.\s\\/:\*\?"<>\|\x01-\x1f]{1,32}(\.[^\.\s\\/:\*\?"<>\|\x01-\x1f]{1,32}){1,7}$`)
+  packageVersionPattern = regexp.MustCompile(`^[^\\/:\*\?"<>\|\x01-\x1f]+$`)
+  localePattern = regexp.MustCompile(`^([a-zA-Z]{2,3}|[iI]-[a-zA-Z]+|[xX]-[a-zA-Z]{1,8})(-[a-zA-Z]{1,8})*$`)
+  installerUrlPattern = regexp.MustCompile(`^([Hh][Tt][Tt][Pp][Ss]?)://.+$`)
+  installerShaPattern = regexp.MustCompile(`^[A-Fa-f0-9]{64}$`)
+  architectures = []string{"x86", "x64", "arm", "arm64", "neutral"}
+  installerTypes = []string{"msix", "msi", "appx", "exe", "zip", "inno", "nullsoft", "wix", "burn", "pwa", "portable", "font"}
+)
+
+const (
+  maxPackageIdentifierLength = 128
+  maxPackageVersionLength = 128
+  maxInstallerUrlLength = 2048
+)
+
+// The result of validating all manifests in a manifestPath. File paths of problems are
+// relative to the manifestPath.
+type validationReport struct {
+  ManifestPath string `json:"manifestPath"`
+  Files int `json:"files"`
+  PackageVersions int `json:"packageVersions"`
+  Errors int `json:"errors"`
+  Warnings int `json:"warnings"`
+  Problems []manifestProblem `json:"problems"`
+}
+
+// Parses all manifests in the manifestPath exactly like they are ingested and additionally
+// checks them against the rules of the manifest schema, against the metadata of their installers
+// as far as lookup knows it, and for package versions that are defined more than once. Unlike
+// ingesting, this always reads everything from disk.
+func validateManifests(manifestPath string, lookup installerMetadataLookup) (validationReport, error) {
+  report := validationReport{ManifestPath: manifestPath, Problems: []manifestProblem{}}
+
+  var directories []string
+  err := filepath.WalkDir(manifestPath, func(path string, d fs.DirEntry, err error) error {
+    if err != nil {
+      if path == manifestPath {
+        return err
+      }
+      report.Problems = append(report.Problems, manifestProblem{Severity: severityError, File: path, Message: "cannot read directory", Error: err.Error()})
+      return nil
+    }
+    if d.IsDir() {
+      directories = append(directories, path)
+    } else if caseInsensitiveHasSuffix(d.Name(), ".yml") || caseInsensitiveHasSuffix(d.Name(), ".yaml") {
+      report.Files++
+    }
+    return nil
+  })
+  if err != nil {
+    return report, err
+  }
+
+  // Directories are independent of each other, so they can be parsed in parallel like on ingest
+  var mu sync.Mutex
+  var manifests []parsedManifest
+  var wg sync.WaitGroup
+  queue := make(chan string)
+  for w := 1; w <= runtime.NumCPU(); w++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      for directory := range queue {
+        parsed, problems, err := parseManifestDirectory(directory)
+        if err != nil {
+          problems = append(problems, manifestProblem{Severity: severityError, File: directory, Message: "cannot read directory", Error: err.Error()})
+        }
+        for _, manifest := range parsed {
+          problems = append(problems, checkManifestSchema(manifest)...)
+          problems = append(problems, checkInstallerMetadata(manifest, lookup)...)
+        }
+        mu.Lock()
+        manifests = append(manifests, parsed...)
+        report.Problems = append(report.Problems, problems...)
+        mu.Unlock()
+      }
+    }()
+  }
+  for _, directory := range directories {
+    queue <- directory
+  }
+  close(queue)
+  wg.Wait()
+
+  report.PackageVersions = len(manifests)
+  report.Problems = append(report.Problems, findDuplicateManifests(manifestPath, manifests)...)
+
+  for i, problem := range report.Problems {
+    report.Problems[i].File = relativePath(manifestPath, problem.File)
+    if problem.Severity == severityWarning {
+      report.Warnings++
+    } else {
+      report.Errors++
+    }
+  }
+  slices.SortStableFunc(report.Problems, func(a, b manifestProblem) int {
+    if c := strings.Compare(a.File, b.File); c != 0 {
+      return c
+    }
+    return a.Line - b.Line
+  })
+
+  return report, nil
+}
+
+// Package versions are stored by their exact PackageIdentifier and PackageVersion, so if
+// more than one set of manifest files defines the same one, only one of them is served and
+// which one depends on the order they were ingested in. winget itself treats PackageIdentifiers
+// case-insensitively, so identifiers that only differ in case are confusing at least.
+func findDuplicateManifests(manifestPath string, manifests []parsedManifest) []manifestProblem {
+  var problems []manifestProblem
+  seen := make(map[models.ManifestKey]parsedManifest)
+  seenCaseInsensitive := make(map[models.ManifestKey]parsedManifest)
+
+  // Report in the order of the files so that the first definition is the one not reported
+  slices.SortFunc(manifests, func(a, b parsedManifest) int {
+    return strings.Compare(a.nodes[0].SourceFile, b.nodes[0].SourceFile)
+  })
+  for _, manifest := range manifests {
+    key := models.ManifestKey{PackageIdentifier: manifest.packageIdentifier, PackageVersion: manifest.version.GetPackageVersion()}
+    lowerKey := models.ManifestKey{PackageIdentifier: strings.ToLower(key.PackageIdentifier), PackageVersion: key.PackageVersion}
+    problem := manifestProblem{
+      File: manifest.nodes[0].SourceFile,
+      Line: manifest.nodes[0].Node.Line,
+      PackageIdentifier: key.PackageIdentifier,
+      PackageVersion: key.PackageVersion,
+    }
+    if first, ok := seen[key]; ok {
+      problem.Severity = severityError
+      problem.Message = fmt.Sprintf("package version is also defined in %v, only one of them is served", relativePath(manifestPath, first.nodes[0].SourceFile))
+      problems = append(problems, problem)
+    } else if first, ok := seenCaseInsensitive[lowerKey]; ok {
+      problem.Severity = severityWarning
+      problem.Message = fmt.Sprintf("package version differs only in the case of its PackageIdentifier from %v in %v", first.packageIdentifier, relativePath(manifestPath, first.nodes[0].SourceFile))
+      problems = append(problems, problem)
+    } else {
+      seen[key] = manifest
+      seenCaseInsensitive[lowerKey] = manifest
+    }
+  }
+  return problems
+}
+
+// Checks the YAML documents of a package version against the parts of the manifest schema
+// that rewinged itself doesn't need to be satisfied to serve it, but winget clients might.
+// The property names are the same in all ManifestVersions, so this works on the YAML directly.
+func checkManifestSchema(manifest parsedManifest) []manifestProblem {
+  var problems []manifestProblem
+  var versionDefaultLocale, defaultLocale *models.ManifestNode
+  documentsByType := make(map[string]int)
+
+  for i := range manifest.nodes {
+    node := &manifest.nodes[i]
+    violation := func(line int, format string, a ...any) {
+      problems = append(problems, manifestProblem{
+        Severity: severityWarning,
+        File: node.SourceFile,
+        Line: line,
+        PackageIdentifier: node.PackageIdentifier,
+        PackageVersion: node.PackageVersion,
+        Message: fmt.Sprintf(format, a...),
+      })
+    }
+    documentsByType[node.ManifestType]++
+
+    if len(node.PackageIdentifier) > maxPackageIdentifierLength || !packageIdentifierPattern.MatchString(node.PackageIdentifier) {
+      violation(node.Node.Line, "PackageIdentifier %q does not match the pattern Publisher.Package required by the schema", node.PackageIdentifier)
+    }
+    if len(node.PackageVersion) > maxPackageVersionLength || !packageVersionPattern.MatchString(node.PackageVersion) {
+      violation(node.Node.Line, "PackageVersion %q contains characters that are not allowed or is too long", node.PackageVersion)
+    }
+
+    switch node.ManifestType {
+    case "version":
+      versionDefaultLocale = node
+      checkLocale(node.Node, "DefaultLocale", violation)
+    case "defaultLocale":
+      defaultLocale = node
+      checkDefaultLocale(node.Node, violation)
+    case "locale":
+      checkLocale(node.Node, "PackageLocale", violation)
+    case "installer":
+      checkInstallers(node.Node, violation)
+    case "singleton", "merged":
+      checkDefaultLocale(node.Node, violation)
+      if localizations := findMappingValue(node.Node, "Localization"); localizations != nil {
+        for _, localization := range localizations.Content {
+          checkLocale(*localization, "PackageLocale", violation)
+        }
+      }
+      installers := checkInstallers(node.Node, violation)
+      if node.ManifestType == "singleton" && installers > 1 {
+        violation(node.Node.Line, "singleton manifests may only contain one installer, use a multi-file manifest for more")
+      }
+    }
+  }
+
+  for _, manifestType := range []string{"version", "defaultLocale"} {
+    if documentsByType[manifestType] > 1 {
+      problems = append(problems, manifestProblem{
+        Severity: severityWarning,
+        File: filepath.Dir(manifest.nodes[0].SourceFile),
+        PackageIdentifier: manifest.packageIdentifier,
+        PackageVersion: manifest.version.GetPackageVersion(),
+        Message: fmt.Sprintf("package version has %d %s manifests, only one of them is used", documentsByType[manifestType], manifestType),
+      })
+    }
+  }
+
+  if versionDefaultLocale != nil && defaultLocale != nil {
+    expected := scalarValue(versionDefaultLocale.Node, "DefaultLocale")
+    if actual := scalarValue(defaultLocale.Node, "PackageLocale"); expected != "" && !strings.EqualFold(expected, actual) {
+      problems = append(problems, manifestProblem{
+        Severity: severityWarning,
+        File: defaultLocale.SourceFile,
+        Line: defaultLocale.Node.Line,
+        PackageIdentifier: defaultLocale.PackageIdentifier,
+        PackageVersion: defaultLocale.PackageVersion,
+        Message: fmt.Sprintf("PackageLocale %q of the defaultLocale manifest does not match the DefaultLocale %q of the version manifest", actual, expected),
+      })
+    }
+  }
+
+  return problems
+}
+
+type violationFunc func(line int, format string, a ...any)
+
+func checkLocale(node yaml.Node, key string, violation violationFunc) {
+  value := findMappingValue(node, key)
+  if value == nil || value.Value == "" {
+    violation(node.Line, "required property %v is missing", key)
+  } else if !localePattern.MatchString(value.Value) {
+    violation(value.Line, "%v %q is not a valid locale", key, value.Value)
+  }
+}
+
+func checkDefaultLocale(node yaml.Node, violation violationFunc) {
+  checkLocale(node, "PackageLocale", violation)
+  for _, key := range []string{"Publisher", "PackageName", "License", "ShortDescription"} {
+    if scalarValue(node, key) == "" {
+      violation(node.Line, "required property %v is missing", key)
+    }
+  }
+}
+
+// Checks the installers of an installer, singleton or merged manifest and returns how many there are
+func checkInstallers(node yaml.Node, violation violationFunc) int {
+  installers := findMappingValue(node, "Installers")
+  if installers == nil || installers.Kind != yaml.SequenceNode || len(installers.Content) == 0 {
+    violation(node.Line, "Installers must list at least one installer")
+    return 0
+  }
+
+  for _, installer := range installers.Content {
+    if architecture := scalarValue(*installer, "Architecture"); architecture == "" {
+      violation(installer.Line, "required installer property Architecture is missing")
+    } else if !slices.Contains(architectures, architecture) {
+      violation(installer.Line, "Architecture %q is not one of %v", architecture, strings.Join(architectures, ", "))
+    }
+
+    // The InstallerType can also be set once for all installers at the root of the manifest
+    installerType := scalarValue(*installer, "InstallerType")
+    if installerType == "" {
+      installerType = scalarValue(node, "InstallerType")
+    }
+    if installerType == "" {
+      violation(installer.Line, "required installer property InstallerType is missing")
+    } else if !slices.Contains(installerTypes, installerType) {
+      violation(installer.Line, "InstallerType %q is not one of %v", installerType, strings.Join(installerTypes, ", "))
+    }
+
+    if installerUrl := scalarValue(*installer, "InstallerUrl"); installerUrl == "" {
+      violation(installer.Line, "required installer property InstallerUrl is missing")
+    } else if len(installerUrl) > maxInstallerUrlLength || !installerUrlPattern.MatchString(installerUrl) {
+      violation(installer.Line, "InstallerUrl %q is not an http or https URL", installerUrl)
+    }
+
+    if installerSha := scalarValue(*installer, "InstallerSha256"); installerSha == "" {
+      violation(installer.Line, "required installer property InstallerSha256 is missing")
+    } else if !installerShaPattern.MatchString(installerSha) {
+      violation(installer.Line, "InstallerSha256 %q is not a SHA256 hash", installerSha)
+    }
+  }
+  return len(installers.Content)
+}
+
+// Reports don't reveal where the manifestPath is on the server
+func relativePath(manifestPath string, file string) string {
+  if relative, err := filepath.Rel(manifestPath, file); err == nil {
+    return filepath.ToSlash(relative)
+  }
+  return file
+}
+
+// Returns the value of a scalar property of a YAML mapping, or an empty string
+func scalarValue(node yaml.Node, key string) string {
+  value := findMappingValue(node, key)
+  if value == nil || value.Kind != yaml.ScalarNode {
+    return ""
+  }
+  return value.Value
+}
+
+func (r validationReport) writeText(w io.Writer) {
+  for _, problem := range r.Problems {
+    location := problem.File
+    if problem.Line > 0 {
+      location = fmt.Sprintf("%v:%d", location, problem.Line)
+    }
+    fmt.Fprintf(w, "%-7v  %v", problem.Severity, location)
+    if problem.PackageIdentifier != "" {
+      fmt.Fprintf(w, "  %v %v", problem.PackageIdentifier, problem.PackageVersion)
+    }
+    fmt.Fprintf(w, "  %v", problem.Message)
+    if problem.Error != "" {
+      fmt.Fprintf(w, ": %v", problem.Error)
+    }
+    fmt.Fprintln(w)
+  }
+  if len(r.Problems) > 0 {
+    fmt.Fprintln(w)
+  }
+  fmt.Fprintf(w, "checked %d files with %d package versions in %v: %d errors, %d warnings\n", r.Files, r.PackageVersions, r.ManifestPath, r.Errors, r.Warnings)
+}
+
+// `rewinged validate` reports all problems with the manifests in the manifestPath and exits with
+// 1 if there are any, so it can be used to check changes to a manifest repository in CI.
+func runValidateCommand(args []string) int {
+  flags := flag.NewFlagSet("rewinged validate", flag.ContinueOnError)
+  var (
+    packagePathPtr         = flags.String("manifestPath", "./packages", "The directory to search for package manifest files")
+    autoInternalizePathPtr = flags.String("autoInternalizePath", "./installers", "The directory with the auto-internalized installers to check the manifests against")
+    formatPtr              = flags.String("format", "text", "Output format of the validation report: text or json")
+    strictPtr              = flags.Bool("strict", false, "Also exit with 1 when there are only warnings")
+    _                      = flags.String("configFile", "", "Path to a json configuration file (optional)")
+  )
+
+  // Takes the manifestPath and autoInternalizePath from the same places as the server does, ignoring all other settings
+  err := ff.Parse(flags, args,
+    ff.WithEnvVarPrefix("REWINGED"),
+    ff.WithConfigFileFlag("configFile"),
+    ff.WithConfigFileParser(ff.JSONParser),
+    ff.WithIgnoreUndefined(true),
+  )
+  if err != nil {
+    if errors.Is(err, flag.ErrHelp) {
+      return 0
+    }
+    fmt.Fprintln(os.Stderr, err)
+    return 2
+  }
+  if *formatPtr != "text" && *formatPtr != "json" {
+    fmt.Fprintln(os.Stderr, "format must be one of text or json")
+    return 2
+  }
+
+  // Everything worth knowing ends up in the report
+  logging.InitLogger("disable", releaseMode == "true")
+
+  report, err := validateManifests(*packagePathPtr, installerFileMetadata(*autoInternalizePathPtr))
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "cannot validate manifestPath: %v\n", err)
+    return 2
+  }
+
+  if *formatPtr == "json" {
+    encoder := json.NewEncoder(os.Stdout)
+    encoder.SetIndent("", "  ")
+    encoder.Encode(report)
+  } else {
+    report.writeText(os.Stdout)
+  }
+
+  // Warnings are values rewinged serves anyway, they only fail the validation when asked to
+  if report.Errors > 0 || (*strictPtr && report.Warnings > 0) {
+    return 1
+  }
+  return 0
+}
+
+// Serves the validation report of the manifestPath as it is on disk right now
+func validationReportHandler(manifestPath string) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    report, err := validateManifests(manifestPath, internalizedMetadata)
+    if err != nil {
+      logging.Logger.Error().Err(err).Msg("cannot validate manifestPath")
+      http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+      return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusOK)
+    json.NewEncoder(w).Encode(struct{ Data validationReport }{report})
+  }
+}